@@ -10,13 +10,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 	"time"
 
+	"github.com/fabian4/gateway-homebrew-go/internal/cache"
 	cfg "github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/discovery"
 	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
 	"github.com/fabian4/gateway-homebrew-go/internal/proxy"
+	"github.com/fabian4/gateway-homebrew-go/internal/tracing"
 	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var version = "v0.6.0"
@@ -56,7 +63,30 @@ func updateRegistry(reg *transport.Registry, services map[string]cfg.Service) {
 	}
 }
 
-func watchConfig(path string, interval time.Duration, onChange func(*cfg.Config)) {
+// altSvc advertises the HTTP/3 endpoint on HTTP/1.1 and h2 responses.
+func altSvc(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newTracer builds the tracer for tc, or returns nil if tracing is off.
+func newTracer(tc cfg.TracingConfig) *tracing.Tracer {
+	if tc.Endpoint == "" {
+		return nil
+	}
+	log.Printf("tracing: exporting to %s (sampling %g)", tc.Endpoint, tc.Sampling)
+	return tracing.New(tracing.Config{
+		Endpoint:    tc.Endpoint,
+		Headers:     tc.Headers,
+		ServiceName: tc.ServiceName,
+		Sampling:    tc.Sampling,
+		B3:          tc.B3,
+	})
+}
+
+func watchConfig(path string, interval time.Duration, onChange func(*cfg.Config) error) {
 	var lastMod time.Time
 	if info, err := os.Stat(path); err == nil {
 		lastMod = info.ModTime()
@@ -80,7 +110,10 @@ func watchConfig(path string, interval time.Duration, onChange func(*cfg.Config)
 				continue
 			}
 
-			onChange(newCfg)
+			if err := onChange(newCfg); err != nil {
+				log.Printf("config reload failed: %v", err)
+				continue
+			}
 			log.Printf("config reloaded successfully")
 		}
 	}
@@ -88,29 +121,21 @@ func watchConfig(path string, interval time.Duration, onChange func(*cfg.Config)
 
 func main() {
 	configPath := flag.String("config", "./cmd/config.yaml", "path to YAML config")
+	zone := flag.String("zone", os.Getenv("GATEWAY_ZONE"), "zone of this gateway for zone-aware load balancing (env GATEWAY_ZONE)")
 	flag.Parse()
 
 	c, err := cfg.Load(*configPath)
 	if err != nil {
 		log.Fatalf("config: %v", err)
 	}
+	c.SetLocalZone(*zone)
 
-	// Metrics
 	m := metrics.NewRegistry()
-	if c.Metrics.Address != "" {
-		go func() {
-			mux := http.NewServeMux()
-			mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-				m.WritePrometheus(w)
-			})
-			log.Printf("metrics listening on %s/metrics", c.Metrics.Address)
-			if err := http.ListenAndServe(c.Metrics.Address, mux); err != nil {
-				log.Printf("metrics server error: %v", err)
-			}
-		}()
-	}
 
-	rt := proxy.NewRouter(c.Routes)
+	rt, err := proxy.NewRouter(c.Routes)
+	if err != nil {
+		log.Fatalf("routes: %v", err)
+	}
 
 	// Transport options
 	fwdOpts := transport.DefaultOptions()
@@ -119,6 +144,8 @@ func main() {
 	fwdOpts.IdleConnTimeout = c.Transport.IdleConnTimeout
 	fwdOpts.DialTimeout = c.Transport.DialTimeout
 	fwdOpts.DialKeepAlive = c.Transport.DialKeepAlive
+	fwdOpts.H2PingInterval = c.Transport.H2PingInterval
+	fwdOpts.H2PingTimeout = c.Transport.H2PingTimeout
 
 	reg := transport.NewRegistry(fwdOpts)
 
@@ -126,16 +153,91 @@ func main() {
 	updateRegistry(reg, c.Services)
 
 	gw := proxy.NewGateway(rt, c.Services, reg, c.Timeouts.Upstream, os.Stdout, c.AccessLog, m)
+	gw.UpdateHTTP(c.HTTP)
+	gw.UpdateTracer(newTracer(c.Tracing))
+	// the store is sized once; routes opt in with options.cache, also on reload
+	store := cache.NewMemory(c.Cache.MaxBytes)
+	if c.Cache.DiskPath != "" {
+		if store, err = cache.NewDisk(c.Cache.DiskPath, c.Cache.DiskMaxBytes); err != nil {
+			log.Fatalf("cache: %v", err)
+		}
+	}
+	gw.Cache = cache.New(store)
+
+	// Admin API: on its own listener if admin.address is set, else next to /metrics
+	admin := proxy.NewAdminHandler(gw, c.Admin.Token)
+	if c.Admin.Token == "" && (c.Admin.Address != "" || c.Metrics.Address != "") {
+		log.Printf("admin: no admin.token set, /admin/ only answers loopback clients")
+	}
+	if c.Admin.Address != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/admin/", admin)
+			log.Printf("admin listening on %s/admin/", c.Admin.Address)
+			if err := http.ListenAndServe(c.Admin.Address, mux); err != nil {
+				log.Printf("admin server error: %v", err)
+			}
+		}()
+	}
+
+	// Metrics
+	if c.Metrics.Address != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+				m.WritePrometheus(w)
+			})
+			if c.Admin.Address == "" {
+				mux.Handle("/admin/", admin)
+				log.Printf("metrics listening on %s/metrics (admin on %s/admin/)", c.Metrics.Address, c.Metrics.Address)
+			} else {
+				log.Printf("metrics listening on %s/metrics", c.Metrics.Address)
+			}
+			if err := http.ListenAndServe(c.Metrics.Address, mux); err != nil {
+				log.Printf("metrics server error: %v", err)
+			}
+		}()
+	}
+
+	// Dynamic endpoint sources (endpoints_file, consul) update balancers in place
+	disc := discovery.NewManager(gw, c.RefreshInterval)
+	disc.Reconcile(c.Services)
+	defer disc.Stop()
 
 	if c.RefreshInterval > 0 {
-		go watchConfig(*configPath, c.RefreshInterval, func(newC *cfg.Config) {
+		tracingCfg := c.Tracing
+		go watchConfig(*configPath, c.RefreshInterval, func(newC *cfg.Config) error {
+			rt, err := proxy.NewRouter(newC.Routes)
+			if err != nil {
+				return err
+			}
+			newC.SetLocalZone(*zone)
 			updateRegistry(reg, newC.Services)
-			rt := proxy.NewRouter(newC.Routes)
 			gw.UpdateState(rt, newC.Services, newC.Timeouts.Upstream, newC.AccessLog)
+			gw.UpdateHTTP(newC.HTTP)
+			disc.SetInterval(newC.RefreshInterval)
+			disc.Reconcile(newC.Services)
+			if !reflect.DeepEqual(newC.Tracing, tracingCfg) {
+				if newC.Tracing.Endpoint == "" {
+					log.Printf("tracing: disabled")
+				}
+				old := gw.UpdateTracer(newTracer(newC.Tracing))
+				tracingCfg = newC.Tracing
+				// flush the old exporter without holding up the reload
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := old.Shutdown(ctx); err != nil {
+						log.Printf("tracing: flush of previous tracer: %v", err)
+					}
+				}()
+			}
+			return nil
 		})
 	}
 
 	var httpServers []*http.Server
+	var h3Servers []*http3.Server
 	var tcpListeners []net.Listener
 
 	log.Printf("gateway-homebrew-go %s starting...", version)
@@ -147,7 +249,8 @@ func main() {
 			if !ok {
 				log.Fatalf("listener %s: service %s not found", l.Name, l.Service)
 			}
-			balancer := proxy.NewSmoothWRR(svc.Endpoints)
+			// picks from the gateway's balancer so discovery and reloads apply
+			balancer := gw.ServiceBalancer(svc.Name)
 			proxy := proxy.NewTCPProxy(balancer, c.Timeouts.TCPIdle, c.Timeouts.TCPConnection, m, l.Name, l.Service)
 
 			ln, err := net.Listen("tcp", l.Address)
@@ -170,9 +273,14 @@ func main() {
 
 		} else {
 			// L7 HTTP Proxy
+			var handler http.Handler = gw
+			if l.H2C {
+				// serves HTTP/1.1 as before; hands h2c connections to the HTTP/2 server
+				handler = h2c.NewHandler(gw, &http2.Server{IdleTimeout: 60 * time.Second})
+			}
 			srv := &http.Server{
 				Addr:              l.Address,
-				Handler:           gw,
+				Handler:           handler,
 				ReadTimeout:       c.Timeouts.Read,
 				ReadHeaderTimeout: 10 * time.Second,
 				WriteTimeout:      c.Timeouts.Write,
@@ -192,12 +300,29 @@ func main() {
 					tlsConfig.Certificates = append(tlsConfig.Certificates, c)
 				}
 				srv.TLSConfig = tlsConfig
+
+				if l.HTTP3 {
+					h3 := &http3.Server{
+						Addr:        l.Address,
+						Handler:     gw,
+						TLSConfig:   http3.ConfigureTLSConfig(tlsConfig),
+						IdleTimeout: 60 * time.Second,
+					}
+					h3Servers = append(h3Servers, h3)
+					srv.Handler = altSvc(h3, handler)
+					log.Printf("L7 listener %s on %s/udp (http3)", l.Name, l.Address)
+					go func() {
+						if err := h3.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+							log.Fatalf("listen quic: %v", err)
+						}
+					}()
+				}
 			}
 
 			httpServers = append(httpServers, srv)
 
-			log.Printf("L7 listener %s on %s (routes=%d services=%d tls=%v)",
-				l.Name, l.Address, len(c.Routes), len(c.Services), c.TLS.Enabled)
+			log.Printf("L7 listener %s on %s (routes=%d services=%d tls=%v h2c=%v)",
+				l.Name, l.Address, len(c.Routes), len(c.Services), c.TLS.Enabled, l.H2C)
 
 			go func(srv *http.Server) {
 				if c.TLS.Enabled {
@@ -223,7 +348,13 @@ func main() {
 	for _, srv := range httpServers {
 		_ = srv.Shutdown(shutdownCtx)
 	}
+	for _, srv := range h3Servers {
+		_ = srv.Shutdown(shutdownCtx)
+	}
 	for _, ln := range tcpListeners {
 		_ = ln.Close()
 	}
+	if err := gw.UpdateTracer(nil).Shutdown(shutdownCtx); err != nil {
+		log.Printf("tracing: flush on shutdown: %v", err)
+	}
 }
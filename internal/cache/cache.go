@@ -0,0 +1,243 @@
+// Package cache implements a shared HTTP cache with RFC 9111 semantics on top
+// of a size-bounded store.
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is a stored response. It is never modified once stored; updates
+// store a copy.
+type Response struct {
+	Route string // identifies the entry for purging
+	Host  string
+	URI   string
+
+	Status       int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time         // when the request that produced it was sent
+	ResponseTime time.Time         // when the response was received
+	Vary         map[string]string // request header values selecting this variant
+}
+
+func (r *Response) size() int64 {
+	n := int64(len(r.Body) + len(r.Route) + len(r.Host) + len(r.URI))
+	for k, vv := range r.Header {
+		for _, v := range vv {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// Age is the response's current age (RFC 9111, 4.2.3).
+func (r *Response) Age(now time.Time) time.Duration {
+	date := r.ResponseTime
+	if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+		date = t
+	}
+	apparent := max(r.ResponseTime.Sub(date), 0)
+	var ageValue time.Duration
+	if n, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("Age")), 10, 64); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+	corrected := ageValue + r.ResponseTime.Sub(r.RequestTime)
+	return max(apparent, corrected) + now.Sub(r.ResponseTime)
+}
+
+// Lifetime is the response's freshness lifetime.
+func (r *Response) Lifetime() time.Duration { return lifetime(r.Header, r.ResponseTime) }
+
+// matches reports whether req selects this variant.
+func (r *Response) matches(req *http.Request) bool {
+	for name, v := range r.Vary {
+		if normalizeField(req.Header.Values(name)) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Freshness is how a stored response may be used for a request.
+type Freshness int
+
+const (
+	Fresh        Freshness = iota // serve as is
+	StaleRefresh                  // serve, and revalidate in the background
+	Stale                         // validate (or fetch) before serving
+)
+
+// Evaluate decides whether r may answer req at now, taking both the
+// response's and the request's Cache-Control into account.
+func (r *Response) Evaluate(req *http.Request, now time.Time) Freshness {
+	res, rq := ParseCacheControl(r.Header), ParseCacheControl(req.Header)
+	if res.Has("no-cache") || rq.Has("no-cache") ||
+		(req.Header.Get("Pragma") == "no-cache" && len(rq) == 0) {
+		return Stale
+	}
+	age, life := r.Age(now), r.Lifetime()
+	if d, ok := rq.Seconds("max-age"); ok && age > d {
+		return Stale
+	}
+	if d, ok := rq.Seconds("min-fresh"); ok {
+		life -= d
+	}
+	if age < life {
+		return Fresh
+	}
+	if mustRevalidate(res) {
+		return Stale
+	}
+	if v, ok := rq["max-stale"]; ok {
+		// the client accepts stale responses: without a value, of any age
+		if d, valid := rq.Seconds("max-stale"); v == "" || valid && age < life+d {
+			return Fresh
+		}
+	}
+	if d, ok := res.Seconds("stale-while-revalidate"); ok && age < life+d {
+		return StaleRefresh
+	}
+	return Stale
+}
+
+// StaleOnError reports whether r may be served because the upstream failed
+// (RFC 5861 stale-if-error, from the response or the request).
+func (r *Response) StaleOnError(req *http.Request, now time.Time) bool {
+	res := ParseCacheControl(r.Header)
+	if mustRevalidate(res) {
+		return false
+	}
+	d, ok := res.Seconds("stale-if-error")
+	if rd, rok := ParseCacheControl(req.Header).Seconds("stale-if-error"); rok {
+		d, ok = rd, true
+	}
+	return ok && r.Age(now) < r.Lifetime()+d
+}
+
+// s-maxage implies proxy-revalidate for shared caches (RFC 9111, 5.2.2.10).
+func mustRevalidate(cc Directives) bool {
+	return cc.Has("must-revalidate") || cc.Has("proxy-revalidate") || cc.Has("s-maxage")
+}
+
+// Store holds responses by key within a size budget.
+type Store interface {
+	Get(key string) (*Response, bool)
+	Set(key string, r *Response)
+	Delete(key string)
+	// Purge removes the entries match selects and returns how many.
+	Purge(match func(route, host, uri string) bool) int
+	Stats() Stats
+}
+
+// Stats describes a store's contents.
+type Stats struct {
+	Backend  string `json:"backend"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// Cache layers RFC 9111 lookups and updates over a Store.
+type Cache struct {
+	store Store
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+}
+
+// New returns a cache backed by store.
+func New(store Store) *Cache {
+	return &Cache{store: store, refreshing: make(map[string]bool)}
+}
+
+// Key identifies the cached resource of a request on a route. GET and HEAD
+// share it. The values of the named request headers are part of the key, for
+// headers that select the upstream (e.g. route subsets).
+func Key(route string, req *http.Request, headers ...string) string {
+	var b strings.Builder
+	b.WriteString(route + "\x00" + strings.ToLower(req.Host) + "\x00" + req.URL.RequestURI())
+	for _, name := range headers {
+		b.WriteString("\x00" + req.Header.Get(name))
+	}
+	return b.String()
+}
+
+// Lookup returns the stored response for req, if any. vary is true when a
+// response is stored for the key but for another variant.
+func (c *Cache) Lookup(key string, req *http.Request) (r *Response, vary bool) {
+	r, ok := c.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if !r.matches(req) {
+		return nil, true
+	}
+	return r, false
+}
+
+// Store saves the response to req. Only one variant is kept per key.
+func (c *Cache) Store(key, route string, req *http.Request, status int, h http.Header, body []byte, sent, received time.Time) {
+	c.store.Set(key, &Response{
+		Route: route, Host: strings.ToLower(req.Host), URI: req.URL.RequestURI(),
+		Status: status, Header: h.Clone(), Body: body,
+		RequestTime: sent, ResponseTime: received,
+		Vary: varyValues(req, h),
+	})
+}
+
+// excludedOn304 are the stored fields a 304 must not replace.
+var excludedOn304 = map[string]bool{"Content-Length": true, "Content-Encoding": true, "Transfer-Encoding": true, "Trailer": true}
+
+// Refreshed stores the result of a successful revalidation: r's body with
+// the header fields of the 304 response (RFC 9111, 4.3.4).
+func (c *Cache) Refreshed(key string, r *Response, notModified http.Header, sent, received time.Time) *Response {
+	nr := *r
+	nr.Header = r.Header.Clone()
+	for k, vv := range notModified {
+		if !excludedOn304[k] {
+			nr.Header[k] = append([]string(nil), vv...)
+		}
+	}
+	nr.RequestTime, nr.ResponseTime = sent, received
+	c.store.Set(key, &nr)
+	return &nr
+}
+
+// Invalidate drops the entry of key, e.g. after an unsafe request succeeded.
+func (c *Cache) Invalidate(key string) { c.store.Delete(key) }
+
+// Purge removes entries matching every non-empty filter; pathPrefix applies
+// to the request URI.
+func (c *Cache) Purge(route, host, pathPrefix string) int {
+	host = strings.ToLower(host)
+	return c.store.Purge(func(r, h, uri string) bool {
+		return (route == "" || r == route) && (host == "" || h == host) && strings.HasPrefix(uri, pathPrefix)
+	})
+}
+
+// Stats reports the store's usage.
+func (c *Cache) Stats() Stats { return c.store.Stats() }
+
+// BeginRefresh claims the background revalidation of key; it returns false
+// if one is already running.
+func (c *Cache) BeginRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+// EndRefresh releases the claim taken by BeginRefresh.
+func (c *Cache) EndRefresh(key string) {
+	c.mu.Lock()
+	delete(c.refreshing, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func response(cc string, age time.Duration) *Response {
+	now := time.Now()
+	h := http.Header{"Date": {now.Add(-age).UTC().Format(http.TimeFormat)}}
+	if cc != "" {
+		h.Set("Cache-Control", cc)
+	}
+	return &Response{Status: 200, Header: h, RequestTime: now.Add(-age), ResponseTime: now.Add(-age)}
+}
+
+func TestEvaluate(t *testing.T) {
+	cases := []struct {
+		name  string
+		cc    string
+		age   time.Duration
+		reqCC string
+		want  Freshness
+	}{
+		{name: "fresh", cc: "max-age=60", age: 10 * time.Second, want: Fresh},
+		{name: "expired", cc: "max-age=60", age: 90 * time.Second, want: Stale},
+		{name: "s_maxage_wins", cc: "max-age=600, s-maxage=30", age: 60 * time.Second, want: Stale},
+		{name: "no_cache", cc: "max-age=60, no-cache", age: time.Second, want: Stale},
+		{name: "request_no_cache", cc: "max-age=60", age: time.Second, reqCC: "no-cache", want: Stale},
+		{name: "request_max_age", cc: "max-age=60", age: 20 * time.Second, reqCC: "max-age=10", want: Stale},
+		{name: "request_min_fresh", cc: "max-age=60", age: 50 * time.Second, reqCC: "min-fresh=20", want: Stale},
+		{name: "swr", cc: "max-age=60, stale-while-revalidate=60", age: 90 * time.Second, want: StaleRefresh},
+		{name: "swr_exhausted", cc: "max-age=60, stale-while-revalidate=60", age: 150 * time.Second, want: Stale},
+		{name: "swr_must_revalidate", cc: "max-age=60, stale-while-revalidate=60, must-revalidate", age: 90 * time.Second, want: Stale},
+		{name: "max_stale", cc: "max-age=60", age: 90 * time.Second, reqCC: "max-stale=60", want: Fresh},
+		{name: "max_stale_any", cc: "max-age=60", age: time.Hour, reqCC: "max-stale", want: Fresh},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.reqCC != "" {
+				req.Header.Set("Cache-Control", tc.reqCC)
+			}
+			if got := response(tc.cc, tc.age).Evaluate(req, time.Now()); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAge(t *testing.T) {
+	r := response("max-age=60", 0)
+	r.Header.Set("Age", "30") // already aged in an upstream cache
+	if age := r.Age(time.Now()); age < 30*time.Second || age > 31*time.Second {
+		t.Errorf("age: got %v, want about 30s", age)
+	}
+}
+
+func TestLifetime_ExpiresAndHeuristic(t *testing.T) {
+	now := time.Now().UTC()
+	h := http.Header{"Date": {now.Format(http.TimeFormat)}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}
+	if got := lifetime(h, now); got != time.Hour {
+		t.Errorf("expires: got %v, want 1h", got)
+	}
+	h.Set("Expires", "0")
+	if got := lifetime(h, now); got != 0 {
+		t.Errorf("invalid expires: got %v, want 0", got)
+	}
+	h.Del("Expires")
+	h.Set("Last-Modified", now.Add(-10*time.Hour).Format(http.TimeFormat))
+	if got := lifetime(h, now); got != time.Hour {
+		t.Errorf("heuristic: got %v, want 1h", got)
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if !response("max-age=60, stale-if-error=300", 120*time.Second).StaleOnError(req, time.Now()) {
+		t.Error("within stale-if-error: want true")
+	}
+	if response("max-age=60", 120*time.Second).StaleOnError(req, time.Now()) {
+		t.Error("without stale-if-error: want false")
+	}
+	req.Header.Set("Cache-Control", "stale-if-error=300")
+	if !response("max-age=60", 120*time.Second).StaleOnError(req, time.Now()) {
+		t.Error("request stale-if-error: want true")
+	}
+	if response("max-age=60, must-revalidate", 120*time.Second).StaleOnError(req, time.Now()) {
+		t.Error("must-revalidate: want false")
+	}
+}
+
+func TestStorable(t *testing.T) {
+	get := httptest.NewRequest("GET", "/", nil)
+	auth := httptest.NewRequest("GET", "/", nil)
+	auth.Header.Set("Authorization", "Bearer x")
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+		h      http.Header
+		want   bool
+	}{
+		{"max_age", get, 200, http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{"validator_only", get, 200, http.Header{"Etag": {`"a"`}}, true},
+		{"nothing", get, 200, http.Header{}, false},
+		{"no_store", get, 200, http.Header{"Cache-Control": {"max-age=60, no-store"}}, false},
+		{"private", get, 200, http.Header{"Cache-Control": {"private, max-age=60"}}, false},
+		{"vary_star", get, 200, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, false},
+		{"set_cookie", get, 200, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, false},
+		{"status", get, 500, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"post", httptest.NewRequest("POST", "/", nil), 200, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"authorization", auth, 200, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"authorization_public", auth, 200, http.Header{"Cache-Control": {"public, max-age=60"}}, true},
+	}
+	for _, tc := range cases {
+		if got := Storable(tc.req, tc.status, tc.h); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCache_Vary(t *testing.T) {
+	c := New(NewMemory(1 << 20))
+	req := httptest.NewRequest("GET", "http://a.example/x", nil)
+	req.Header.Set("Accept-Language", "en, de")
+	key := Key("r", req)
+	h := http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-language"}}
+	c.Store(key, "r", req, 200, h, []byte("hello"), time.Now(), time.Now())
+
+	same := httptest.NewRequest("GET", "http://a.example/x", nil)
+	same.Header.Set("Accept-Language", "en,de")
+	if r, _ := c.Lookup(key, same); r == nil || string(r.Body) != "hello" {
+		t.Errorf("same variant: got %v", r)
+	}
+	other := httptest.NewRequest("GET", "http://a.example/x", nil)
+	other.Header.Set("Accept-Language", "fr")
+	if r, vary := c.Lookup(key, other); r != nil || !vary {
+		t.Errorf("other variant: got %v vary=%v", r, vary)
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	body := make([]byte, 400)
+	for i := 0; i < 3; i++ {
+		s.Set(fmt.Sprint("k", i), &Response{Route: "r", Host: "h", URI: fmt.Sprint("/p/", i), Status: 200, Body: body})
+	}
+	// 3 x ~410 bytes exceed 1000: the least recently used entry went
+	if _, ok := s.Get("k0"); ok {
+		t.Error("k0 should have been evicted")
+	}
+	if r, ok := s.Get("k1"); !ok || len(r.Body) != 400 {
+		t.Error("k1 missing")
+	}
+	s.Set("k3", &Response{Route: "r", Host: "h", URI: "/q", Status: 200, Body: body})
+	if _, ok := s.Get("k2"); ok {
+		t.Error("k2 should have been evicted after k1 was used")
+	}
+	s.Set("huge", &Response{Body: make([]byte, 2000)})
+	if _, ok := s.Get("huge"); ok {
+		t.Error("an entry above the budget must not be stored")
+	}
+	if n := s.Purge(func(route, host, uri string) bool { return uri == "/q" }); n != 1 {
+		t.Errorf("purge: got %d, want 1", n)
+	}
+	if st := s.Stats(); st.Entries != 1 || st.Bytes > 1000 {
+		t.Errorf("stats: %+v", st)
+	}
+}
+
+func TestMemoryStore(t *testing.T) { testStore(t, NewMemory(1000)) }
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDisk(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+
+	// entries survive a restart
+	s, err = NewDisk(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := s.Get("k1"); !ok || r.URI != "/p/1" {
+		t.Errorf("after reopen: got %+v, %v", r, ok)
+	}
+}
+
+func TestDiskStore_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewDisk(dir, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := st.(*diskStore)
+	body := make([]byte, 400)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprint("k", (g+i)%6)
+				switch i % 3 {
+				case 0, 1:
+					d.Set(key, &Response{Route: "r", Host: "h", URI: "/" + key, Status: 200, Body: body})
+				default:
+					d.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// every indexed entry still has its file
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.lru.items {
+		if _, err := os.Stat(d.path(key)); err != nil {
+			t.Errorf("index entry %s without file: %v", key, err)
+		}
+	}
+}
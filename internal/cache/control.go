@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are parsed Cache-Control directives; names are lower case and
+// valueless directives map to "".
+type Directives map[string]string
+
+// ParseCacheControl parses all Cache-Control field lines of h.
+func ParseCacheControl(h http.Header) Directives {
+	d := make(Directives)
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				d[name] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+	}
+	return d
+}
+
+// Has reports whether the directive is present.
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds returns a delta-seconds directive; ok is false if it is absent or
+// malformed.
+func (d Directives) Seconds(name string) (time.Duration, bool) {
+	v, present := d[name]
+	if !present {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	const maxDelta = 1<<31 - 1 // RFC 9111, 1.2.2
+	return time.Duration(min(n, maxDelta)) * time.Second, true
+}
+
+// heuristicStatus lists the status codes that are cacheable without explicit
+// freshness (RFC 9110, 15.1); they are also the only ones this cache stores.
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// CacheableStatus reports whether status is a final, complete response
+// that is cacheable by default.
+func CacheableStatus(status int) bool { return heuristicStatus[status] }
+
+// Storable reports whether a shared cache may store the response to req
+// (RFC 9111, 3). Responses setting cookies are never stored.
+func Storable(req *http.Request, status int, h http.Header) bool {
+	if req.Method != http.MethodGet || !heuristicStatus[status] {
+		return false
+	}
+	if ParseCacheControl(req.Header).Has("no-store") {
+		return false
+	}
+	cc := ParseCacheControl(h)
+	if cc.Has("no-store") || cc.Has("private") || h.Get("Set-Cookie") != "" || len(h.Values("Trailer")) > 0 {
+		return false
+	}
+	if hasVaryStar(h) {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" && !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+		return false
+	}
+	// worth keeping only if it can be served fresh, stale or revalidated
+	return lifetime(h, time.Now()) > 0 || h.Get("Etag") != "" || h.Get("Last-Modified") != "" ||
+		cc.Has("stale-while-revalidate") || cc.Has("stale-if-error")
+}
+
+// lifetime is the freshness lifetime of a response received at recv
+// (RFC 9111, 4.2.1), with the usual 10% of Last-Modified heuristic capped at
+// a day.
+func lifetime(h http.Header, recv time.Time) time.Duration {
+	cc := ParseCacheControl(h)
+	if d, ok := cc.Seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.Seconds("max-age"); ok {
+		return d
+	}
+	date := recv
+	if t, err := http.ParseTime(h.Get("Date")); err == nil {
+		date = t
+	}
+	if v := h.Get("Expires"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			return 0 // invalid dates mean "already expired"
+		}
+		return max(t.Sub(date), 0)
+	}
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil && !cc.Has("no-cache") {
+		return min(max(date.Sub(lm)/10, 0), 24*time.Hour)
+	}
+	return 0
+}
+
+func hasVaryStar(h http.Header) bool {
+	for _, name := range VaryNames(h) {
+		if name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// varyNames returns the canonical header names listed in Vary.
+func VaryNames(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				out = append(out, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return out
+}
+
+// varyValues captures the request header values a response varies on.
+func varyValues(req *http.Request, h http.Header) map[string]string {
+	names := VaryNames(h)
+	if len(names) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(names))
+	for _, name := range names {
+		out[name] = normalizeField(req.Header.Values(name))
+	}
+	return out
+}
+
+// normalizeField joins field lines and drops optional whitespace, so that
+// "gzip,br" and "gzip, br" select the same variant.
+func normalizeField(vals []string) string {
+	var parts []string
+	for _, v := range vals {
+		for _, p := range strings.Split(v, ",") {
+			parts = append(parts, strings.TrimSpace(p))
+		}
+	}
+	return strings.Join(parts, ",")
+}
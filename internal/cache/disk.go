@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// diskStore keeps one file per response in a directory. The recency index
+// lives in memory and is rebuilt from the files on startup. Files are only
+// renamed into place or removed under mu, together with the index change, so
+// the index never points at a file another goroutine is replacing.
+type diskStore struct {
+	dir string
+
+	mu  sync.Mutex
+	lru *lru
+}
+
+type diskRecord struct {
+	Key  string
+	Resp *Response
+}
+
+// NewDisk returns a store under dir holding up to maxBytes of responses.
+// Entries left by a previous run are kept, up to the budget.
+func NewDisk(dir string, maxBytes int64) (Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	d := &diskStore{dir: dir, lru: newLRU(maxBytes)}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".entry") {
+			if strings.HasSuffix(f.Name(), ".tmp") {
+				_ = os.Remove(path) // interrupted write
+			}
+			continue
+		}
+		rec, err := readRecord(path)
+		if err != nil {
+			log.Printf("cache: dropping unreadable entry %s: %v", f.Name(), err)
+			_ = os.Remove(path)
+			continue
+		}
+		d.indexLocked(rec.Key, rec.Resp)
+	}
+	return d, nil
+}
+
+func (d *diskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".entry")
+}
+
+// indexLocked records key in the LRU and deletes the files of evicted
+// entries. The caller holds d.mu.
+func (d *diskStore) indexLocked(key string, r *Response) {
+	evicted := d.lru.add(&lruEntry{key: key, size: r.size(), route: r.Route, host: r.Host, uri: r.URI})
+	for _, e := range evicted {
+		_ = os.Remove(d.path(e.key))
+	}
+}
+
+func (d *diskStore) Get(key string) (*Response, bool) {
+	d.mu.Lock()
+	e, ok := d.lru.get(key)
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	rec, err := readRecord(d.path(key))
+	if err != nil || rec.Key != key {
+		// drop the entry only if no Set replaced it in the meantime
+		d.mu.Lock()
+		if cur, ok := d.lru.items[key]; ok && cur.Value.(*lruEntry) == e {
+			d.lru.remove(key)
+			_ = os.Remove(d.path(key))
+		}
+		d.mu.Unlock()
+		return nil, false
+	}
+	return rec.Resp, true
+}
+
+func (d *diskStore) Set(key string, r *Response) {
+	if r.size() > d.lru.max {
+		d.Delete(key)
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(diskRecord{Key: key, Resp: r}); err != nil {
+		log.Printf("cache: encode: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		log.Printf("cache: write: %v", err)
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Printf("cache: write: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Printf("cache: write: %v", err)
+		return
+	}
+	d.indexLocked(key, r)
+}
+
+func (d *diskStore) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lru.remove(key)
+	_ = os.Remove(d.path(key))
+}
+
+func (d *diskStore) Purge(match func(route, host, uri string) bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	purged := d.lru.purge(match)
+	for _, e := range purged {
+		_ = os.Remove(d.path(e.key))
+	}
+	return len(purged)
+}
+
+func (d *diskStore) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Stats{Backend: "disk", Entries: d.lru.ll.Len(), Bytes: d.lru.size, MaxBytes: d.lru.max}
+}
+
+func readRecord(path string) (diskRecord, error) {
+	var rec diskRecord
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return rec, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&rec); err != nil {
+		return rec, err
+	}
+	if rec.Resp == nil {
+		return rec, fmt.Errorf("empty record")
+	}
+	return rec, nil
+}
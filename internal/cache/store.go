@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is the recency index shared by the stores. Entries are evicted from the
+// back until the total size fits max.
+type lru struct {
+	max   int64
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key              string
+	size             int64
+	route, host, uri string
+	resp             *Response // nil in the disk store
+}
+
+func newLRU(max int64) *lru {
+	return &lru{max: max, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *lru) get(key string) (*lruEntry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*lruEntry), true
+}
+
+// add inserts or replaces e and returns the entries evicted to make room.
+func (l *lru) add(e *lruEntry) []*lruEntry {
+	if el, ok := l.items[e.key]; ok {
+		l.size -= el.Value.(*lruEntry).size
+		el.Value = e
+		l.ll.MoveToFront(el)
+	} else {
+		l.items[e.key] = l.ll.PushFront(e)
+	}
+	l.size += e.size
+	var evicted []*lruEntry
+	for l.size > l.max && l.ll.Len() > 0 {
+		old := l.ll.Back().Value.(*lruEntry)
+		l.remove(old.key)
+		evicted = append(evicted, old)
+	}
+	return evicted
+}
+
+func (l *lru) remove(key string) (*lruEntry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	l.ll.Remove(el)
+	delete(l.items, key)
+	l.size -= e.size
+	return e, true
+}
+
+func (l *lru) purge(match func(route, host, uri string) bool) []*lruEntry {
+	var out []*lruEntry
+	for key, el := range l.items {
+		if e := el.Value.(*lruEntry); match(e.route, e.host, e.uri) {
+			l.remove(key)
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// memoryStore keeps responses in memory.
+type memoryStore struct {
+	mu  sync.Mutex
+	lru *lru
+}
+
+// NewMemory returns an in-memory LRU store holding up to maxBytes of
+// responses.
+func NewMemory(maxBytes int64) Store {
+	return &memoryStore{lru: newLRU(maxBytes)}
+}
+
+func (m *memoryStore) Get(key string) (*Response, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lru.get(key)
+	if !ok {
+		return nil, false
+	}
+	return e.resp, true
+}
+
+func (m *memoryStore) Set(key string, r *Response) {
+	size := r.size()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size > m.lru.max {
+		m.lru.remove(key)
+		return
+	}
+	m.lru.add(&lruEntry{key: key, size: size, route: r.Route, host: r.Host, uri: r.URI, resp: r})
+}
+
+func (m *memoryStore) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lru.remove(key)
+}
+
+func (m *memoryStore) Purge(match func(route, host, uri string) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.lru.purge(match))
+}
+
+func (m *memoryStore) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Stats{Backend: "memory", Entries: m.lru.ll.Len(), Bytes: m.lru.size, MaxBytes: m.lru.max}
+}
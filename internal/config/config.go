@@ -1,13 +1,18 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/fabian4/gateway-homebrew-go/internal/headers"
+	"golang.org/x/net/http/httpguts"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,17 +21,30 @@ type rawConfig struct {
 		Name    string `yaml:"name"`
 		Address string `yaml:"address"`
 		Service string `yaml:"service"`
+		H2C     bool   `yaml:"h2c"`
+		HTTP3   bool   `yaml:"http3"`
 	} `yaml:"entrypoint"`
 	Services []struct {
-		Name      string `yaml:"name"`
-		Proto     string `yaml:"proto"`
-		Endpoints []any  `yaml:"endpoints"`
-		TLS       struct {
+		Name          string    `yaml:"name"`
+		Proto         string    `yaml:"proto"`
+		Endpoints     []any     `yaml:"endpoints"`
+		EndpointsFile string    `yaml:"endpoints_file"`
+		Discovery     string    `yaml:"discovery"`
+		Consul        rawConsul `yaml:"consul"`
+		LB            struct {
+			Policy            string   `yaml:"policy"`
+			EWMADecay         string   `yaml:"ewma_decay"`
+			FailoverThreshold *float64 `yaml:"failover_threshold"`
+			ZoneTrafficShare  float64  `yaml:"zone_traffic_share"`
+		} `yaml:"lb"`
+		TLS struct {
 			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 			CAFile             string `yaml:"ca_file"`
 			CertFile           string `yaml:"cert_file"`
 			KeyFile            string `yaml:"key_file"`
 		} `yaml:"tls"`
+		RequestHeaders  *rawHeaderPolicy `yaml:"request_headers"`
+		ResponseHeaders *rawHeaderPolicy `yaml:"response_headers"`
 	} `yaml:"services"`
 	Routes []struct {
 		Name  string `yaml:"name"`
@@ -36,9 +54,30 @@ type rawConfig struct {
 		} `yaml:"match"`
 		Service string `yaml:"service"`
 		Options struct {
-			PreserveHost bool             `yaml:"preserve_host"`
-			HostRewrite  string           `yaml:"host_rewrite"`
-			RateLimit    *RateLimitConfig `yaml:"rate_limit"`
+			PreserveHost   bool   `yaml:"preserve_host"`
+			HostRewrite    string `yaml:"host_rewrite"`
+			Timeout        string `yaml:"timeout"`
+			GRPCWeb        bool   `yaml:"grpc_web"`
+			ExpectContinue string `yaml:"expect_continue"`
+			Disconnect     string `yaml:"client_disconnect"`
+			Transcode      *struct {
+				DescriptorSet string   `yaml:"descriptor_set"`
+				Services      []string `yaml:"services"`
+			} `yaml:"grpc_transcode"`
+			RequestHeaders  *rawHeaderPolicy `yaml:"request_headers"`
+			ResponseHeaders *rawHeaderPolicy `yaml:"response_headers"`
+			RateLimit       *RateLimitConfig `yaml:"rate_limit"`
+			Compression     *rawCompression  `yaml:"compression"`
+			Coalesce        *rawCoalesce     `yaml:"coalesce"`
+			Cache           *struct {
+				MaxObjectSize *int64 `yaml:"max_object_size"`
+			} `yaml:"cache"`
+			Subset *struct {
+				Selector map[string]string `yaml:"selector"`
+				Headers  map[string]string `yaml:"headers"`
+				Fallback string            `yaml:"fallback"`
+				Default  map[string]string `yaml:"default"`
+			} `yaml:"subset"`
 		} `yaml:"options"`
 	} `yaml:"routes"`
 	Timeouts struct {
@@ -58,20 +97,101 @@ type rawConfig struct {
 	Metrics struct {
 		Address string `yaml:"address"`
 	} `yaml:"metrics"`
+	Admin struct {
+		Address string `yaml:"address"`
+		Token   string `yaml:"token"`
+	} `yaml:"admin"`
 	AccessLog struct {
 		Fields   []string `yaml:"fields"`
 		Sampling *float64 `yaml:"sampling"`
 	} `yaml:"access_log"`
+	Tracing struct {
+		Endpoint    string            `yaml:"endpoint"`
+		Headers     map[string]string `yaml:"headers"`
+		ServiceName string            `yaml:"service_name"`
+		Sampling    *float64          `yaml:"sampling"`
+		Propagation []string          `yaml:"propagation"`
+	} `yaml:"tracing"`
+	Cache struct {
+		MaxBytes *int64 `yaml:"max_bytes"`
+		Disk     struct {
+			Path     string `yaml:"path"`
+			MaxBytes *int64 `yaml:"max_bytes"`
+		} `yaml:"disk"`
+	} `yaml:"cache"`
 	Transport struct {
 		MaxIdleConns        int    `yaml:"max_idle_conns"`
 		MaxIdleConnsPerHost int    `yaml:"max_idle_conns_per_host"`
 		IdleConnTimeout     string `yaml:"idle_conn_timeout"`
 		DialTimeout         string `yaml:"dial_timeout"`
 		DialKeepAlive       string `yaml:"dial_keep_alive"`
+		H2PingInterval      string `yaml:"h2_ping_interval"`
+		H2PingTimeout       string `yaml:"h2_ping_timeout"`
 	} `yaml:"transport"`
+	HTTP struct {
+		Headers struct {
+			Strict        bool `yaml:"strict"`
+			MaxCount      int  `yaml:"max_count"`
+			MaxBytes      int  `yaml:"max_bytes"`
+			MaxFieldBytes int  `yaml:"max_field_bytes"`
+		} `yaml:"headers"`
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		Forwarded      bool     `yaml:"forwarded"`
+		RequestID      struct {
+			Header string `yaml:"header"`
+			Format string `yaml:"format"`
+		} `yaml:"request_id"`
+		NormalizePath struct {
+			MergeSlashes     bool `yaml:"merge_slashes"`
+			ResolveDots      bool `yaml:"resolve_dots"`
+			DecodeUnreserved bool `yaml:"decode_unreserved"`
+		} `yaml:"normalize_path"`
+	} `yaml:"http"`
 	RefreshInterval string `yaml:"refresh_interval"`
 }
 
+type rawCompression struct {
+	Algorithms   []string `yaml:"algorithms"`
+	MinSize      *int64   `yaml:"min_size"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+type rawCoalesce struct {
+	Headers     []string `yaml:"headers"`
+	MaxBodySize *int64   `yaml:"max_body_size"`
+	Timeout     string   `yaml:"timeout"`
+}
+
+type rawHeaderPolicy struct {
+	Set            map[string]string `yaml:"set"`
+	Add            map[string]string `yaml:"add"`
+	Remove         []string          `yaml:"remove"`
+	AppendIfAbsent map[string]string `yaml:"append_if_absent"`
+}
+
+// compileHeaders compiles an optional header policy block; nil stays nil.
+func compileHeaders(raw *rawHeaderPolicy) (*headers.Policy, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	return headers.Compile(headers.Spec{
+		Set:            raw.Set,
+		Add:            raw.Add,
+		Remove:         raw.Remove,
+		AppendIfAbsent: raw.AppendIfAbsent,
+	})
+}
+
+type rawConsul struct {
+	Address    string `yaml:"address"`
+	Service    string `yaml:"service"`
+	Tag        string `yaml:"tag"`
+	Datacenter string `yaml:"datacenter"`
+	Token      string `yaml:"token"`
+	Scheme     string `yaml:"scheme"`
+	Wait       string `yaml:"wait"`
+}
+
 type Config struct {
 	Listen          string
 	RefreshInterval time.Duration
@@ -81,8 +201,46 @@ type Config struct {
 	Timeouts        Timeouts
 	TLS             TLSConfig
 	Metrics         MetricsConfig
+	Admin           AdminConfig
 	AccessLog       AccessLogConfig
+	Tracing         TracingConfig
+	Cache           CacheConfig
 	Transport       TransportConfig
+	HTTP            HTTPConfig
+}
+
+// HTTPConfig is the request handling policy shared by all L7 listeners.
+type HTTPConfig struct {
+	Headers       HeaderLimits
+	NormalizePath PathNormalization
+	// TrustedProxies are the peers whose X-Forwarded-For / Forwarded hops are
+	// believed when resolving the client IP. Empty keeps the legacy behaviour:
+	// the client is the peer and forwarding headers are appended to as-is.
+	TrustedProxies []netip.Prefix
+	Forwarded      bool // also send an RFC 7239 Forwarded header upstream
+	RequestID      RequestIDConfig
+}
+
+// RequestIDConfig names the correlation header that is accepted from clients,
+// forwarded upstream and echoed on responses.
+type RequestIDConfig struct {
+	Header string // canonical header name; empty means DefaultRequestIDHeader
+	Format string // "uuid" (v4) or "ulid" for generated IDs; empty means uuid
+}
+
+// HeaderLimits bounds and disambiguates request headers before routing.
+type HeaderLimits struct {
+	Strict        bool // reject ambiguous requests instead of normalizing them
+	MaxCount      int  // header field lines
+	MaxBytes      int  // names plus values of all fields
+	MaxFieldBytes int  // name plus value of a single field
+}
+
+// PathNormalization rewrites the request path before route matching.
+type PathNormalization struct {
+	MergeSlashes     bool // "//" -> "/"
+	ResolveDots      bool // remove "." and ".." segments
+	DecodeUnreserved bool // "%7E" -> "~"; reserved escapes are kept
 }
 
 type TransportConfig struct {
@@ -91,17 +249,43 @@ type TransportConfig struct {
 	IdleConnTimeout     time.Duration
 	DialTimeout         time.Duration
 	DialKeepAlive       time.Duration
+	H2PingInterval      time.Duration // h2c upstream PING interval, 0 disables
+	H2PingTimeout       time.Duration
 }
 
 type MetricsConfig struct {
 	Address string
 }
 
+// AdminConfig places and protects the admin API (peer overrides, cache purge).
+type AdminConfig struct {
+	Address string // own listener; empty serves the API on the metrics address
+	Token   string // bearer token required on every admin request; empty serves localhost only
+}
+
 type AccessLogConfig struct {
 	Fields   []string
 	Sampling float64
 }
 
+// TracingConfig enables OpenTelemetry spans exported over OTLP/HTTP. Tracing
+// is off when Endpoint is empty.
+type TracingConfig struct {
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	Sampling    float64 // fraction of new traces recorded; callers' decisions are kept
+	B3          bool    // accept and send B3 headers besides W3C traceparent
+}
+
+// CacheConfig sizes the response cache shared by routes with options.cache.
+// Entries live in memory unless DiskPath is set.
+type CacheConfig struct {
+	MaxBytes     int64
+	DiskPath     string
+	DiskMaxBytes int64
+}
+
 type TLSConfig struct {
 	Enabled      bool
 	Certificates []Certificate
@@ -121,9 +305,26 @@ type Timeouts struct {
 }
 
 const (
+	// DefaultFailoverThreshold mirrors Envoy's overprovisioning factor of 1.4.
+	DefaultFailoverThreshold = 0.7
+	// DefaultEWMADecay is the peak_ewma latency decay time constant.
+	DefaultEWMADecay = 10 * time.Second
+
 	DefaultReadTimeout    = 15 * time.Second
 	DefaultWriteTimeout   = 30 * time.Second
 	DefaultTCPIdleTimeout = 5 * time.Minute
+
+	DefaultMaxHeaderCount      = 100
+	DefaultMaxHeaderBytes      = 64 << 10
+	DefaultMaxHeaderFieldBytes = 16 << 10
+	DefaultRequestIDHeader     = "X-Request-Id"
+
+	DefaultCacheMaxBytes      = 64 << 20
+	DefaultCacheDiskMaxBytes  = 1 << 30
+	DefaultCacheMaxObjectSize = 1 << 20
+
+	DefaultCoalesceMaxBodySize = 1 << 20
+	DefaultCoalesceTimeout     = 5 * time.Second
 )
 
 func Load(path string) (*Config, error) {
@@ -139,15 +340,27 @@ func Load(path string) (*Config, error) {
 	// listen
 	var listeners []Listener
 	if len(rc.EntryPoint) > 0 {
-		for _, ep := range rc.EntryPoint {
+		for i, ep := range rc.EntryPoint {
 			addr := strings.TrimSpace(ep.Address)
 			if addr == "" {
 				addr = ":8080"
 			}
+			service := strings.TrimSpace(ep.Service)
+			if ep.H2C && service != "" {
+				return nil, fmt.Errorf("entrypoint[%d].h2c: only applies to L7 listeners", i)
+			}
+			if ep.H2C && rc.TLS.Enabled {
+				return nil, fmt.Errorf("entrypoint[%d].h2c: TLS listeners already negotiate h2 via ALPN", i)
+			}
+			if ep.HTTP3 && (service != "" || !rc.TLS.Enabled) {
+				return nil, fmt.Errorf("entrypoint[%d].http3: requires an L7 listener with tls.enabled", i)
+			}
 			listeners = append(listeners, Listener{
 				Name:    strings.TrimSpace(ep.Name),
 				Address: addr,
-				Service: strings.TrimSpace(ep.Service),
+				Service: service,
+				H2C:     ep.H2C,
+				HTTP3:   ep.HTTP3,
 			})
 		}
 	} else {
@@ -176,42 +389,84 @@ func Load(path string) (*Config, error) {
 		default:
 			return nil, fmt.Errorf("services[%d]: unknown proto %q", i, proto)
 		}
-		if len(s.Endpoints) == 0 {
-			return nil, fmt.Errorf("services[%d]: endpoints is empty", i)
+		endpointsFile := strings.TrimSpace(s.EndpointsFile)
+		discovery := strings.ToLower(strings.TrimSpace(s.Discovery))
+		var consul *ConsulDiscovery
+		switch discovery {
+		case "":
+		case "consul":
+			if endpointsFile != "" {
+				return nil, fmt.Errorf("services[%d]: discovery and endpoints_file are mutually exclusive", i)
+			}
+			hasTLS := s.TLS.InsecureSkipVerify || s.TLS.CAFile != "" || s.TLS.CertFile != "" || s.TLS.KeyFile != ""
+			cd, err := parseConsul(name, proto, hasTLS, s.Consul)
+			if err != nil {
+				return nil, fmt.Errorf("services[%d].consul: %v", i, err)
+			}
+			consul = cd
+		default:
+			return nil, fmt.Errorf("services[%d]: unknown discovery %q", i, discovery)
 		}
 		var eps []Endpoint
-		for j, raw := range s.Endpoints {
-			var rawURL string
-			weight := 1
-
-			switch v := raw.(type) {
-			case string:
-				rawURL = v
-			case map[string]any:
-				if u, ok := v["url"].(string); ok {
-					rawURL = u
-				}
-				if w, ok := v["weight"].(int); ok {
-					weight = w
-				}
-			default:
-				return nil, fmt.Errorf("services[%d].endpoints[%d]: invalid format", i, j)
+		if endpointsFile != "" {
+			if len(s.Endpoints) > 0 {
+				return nil, fmt.Errorf("services[%d]: endpoints and endpoints_file are mutually exclusive", i)
 			}
-
-			u, err := url.Parse(strings.TrimSpace(rawURL))
+			fileEps, err := LoadEndpoints(endpointsFile)
 			if err != nil {
-				return nil, fmt.Errorf("services[%d].endpoints[%d]: parse: %v", i, j, err)
+				return nil, fmt.Errorf("services[%d]: %v", i, err)
+			}
+			eps = fileEps
+		} else {
+			// discovered services may start empty and fill in from the provider
+			if len(s.Endpoints) == 0 && consul == nil {
+				return nil, fmt.Errorf("services[%d]: endpoints is empty", i)
 			}
-			if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tcp") || u.Host == "" {
-				return nil, fmt.Errorf("services[%d].endpoints[%d]: must be http(s) or tcp URL with host", i, j)
+			for j, raw := range s.Endpoints {
+				ep, err := parseEndpoint(raw)
+				if err != nil {
+					return nil, fmt.Errorf("services[%d].endpoints[%d]: %v", i, j, err)
+				}
+				eps = append(eps, ep)
 			}
-			eps = append(eps, Endpoint{URL: u, Weight: weight})
 		}
 		if _, dup := svcs[name]; dup {
 			return nil, fmt.Errorf("services: duplicate name %q", name)
 		}
+		lb := LoadBalancing{
+			Policy:            strings.ToLower(strings.TrimSpace(s.LB.Policy)),
+			EWMADecay:         DefaultEWMADecay,
+			FailoverThreshold: DefaultFailoverThreshold,
+		}
+		switch lb.Policy {
+		case "":
+			lb.Policy = "wrr"
+		case "wrr", "peak_ewma":
+		default:
+			return nil, fmt.Errorf("services[%d].lb.policy: unknown policy %q", i, lb.Policy)
+		}
+		if s.LB.EWMADecay != "" {
+			d, err := time.ParseDuration(s.LB.EWMADecay)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("services[%d].lb.ewma_decay: must be a positive duration", i)
+			}
+			lb.EWMADecay = d
+		}
+		if t := s.LB.FailoverThreshold; t != nil {
+			if *t <= 0 || *t > 1 {
+				return nil, fmt.Errorf("services[%d].lb.failover_threshold: must be in (0, 1]", i)
+			}
+			lb.FailoverThreshold = *t
+		}
+		if z := s.LB.ZoneTrafficShare; z < 0 || z > 1 {
+			return nil, fmt.Errorf("services[%d].lb.zone_traffic_share: must be in [0, 1]", i)
+		}
+		lb.ZoneTrafficShare = s.LB.ZoneTrafficShare
 		var upstreamTLS *UpstreamTLS
 		if s.TLS.InsecureSkipVerify || s.TLS.CAFile != "" || s.TLS.CertFile != "" || s.TLS.KeyFile != "" {
+			if proto == "h2c" {
+				return nil, fmt.Errorf("services[%d]: proto h2c is cleartext; use proto auto for HTTP/2 over TLS", i)
+			}
 			upstreamTLS = &UpstreamTLS{
 				InsecureSkipVerify: s.TLS.InsecureSkipVerify,
 				CAFile:             s.TLS.CAFile,
@@ -219,11 +474,25 @@ func Load(path string) (*Config, error) {
 				KeyFile:            s.TLS.KeyFile,
 			}
 		}
+		reqHeaders, err := compileHeaders(s.RequestHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("services[%d].request_headers: %v", i, err)
+		}
+		resHeaders, err := compileHeaders(s.ResponseHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("services[%d].response_headers: %v", i, err)
+		}
 		svcs[name] = Service{
-			Name:      name,
-			Proto:     proto,
-			Endpoints: eps,
-			TLS:       upstreamTLS,
+			Name:            name,
+			Proto:           proto,
+			Endpoints:       eps,
+			EndpointsFile:   endpointsFile,
+			Discovery:       discovery,
+			Consul:          consul,
+			LB:              lb,
+			TLS:             upstreamTLS,
+			RequestHeaders:  reqHeaders,
+			ResponseHeaders: resHeaders,
 		}
 	}
 	if len(svcs) == 0 {
@@ -249,14 +518,127 @@ func Load(path string) (*Config, error) {
 		if _, ok := svcs[service]; !ok {
 			return nil, fmt.Errorf("routes[%d]: service=%q not found in services", i, service)
 		}
+		if r.Options.GRPCWeb && !speaksHTTP2(svcs[service]) {
+			return nil, fmt.Errorf("routes[%d].options.grpc_web: service %q must use proto h2c or auto", i, service)
+		}
+		var subset *Subset
+		if ss := r.Options.Subset; ss != nil {
+			subset = &Subset{
+				Selector: ss.Selector,
+				Headers:  ss.Headers,
+				Fallback: strings.ToLower(strings.TrimSpace(ss.Fallback)),
+				Default:  ss.Default,
+			}
+			switch subset.Fallback {
+			case "":
+				subset.Fallback = "any"
+			case "any", "none":
+			case "default":
+				if len(subset.Default) == 0 {
+					return nil, fmt.Errorf("routes[%d].options.subset: fallback default requires a default selector", i)
+				}
+			default:
+				return nil, fmt.Errorf("routes[%d].options.subset: unknown fallback %q", i, subset.Fallback)
+			}
+			if len(subset.Selector) == 0 && len(subset.Headers) == 0 {
+				return nil, fmt.Errorf("routes[%d].options.subset: selector or headers is required", i)
+			}
+		}
+		var timeout time.Duration
+		if r.Options.Timeout != "" {
+			d, err := time.ParseDuration(r.Options.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("routes[%d].options.timeout: must be a positive duration", i)
+			}
+			timeout = d
+		}
+		expect := strings.ToLower(strings.TrimSpace(r.Options.ExpectContinue))
+		switch expect {
+		case "":
+			expect = "upstream"
+		case "upstream", "local":
+		default:
+			return nil, fmt.Errorf("routes[%d].options.expect_continue: must be upstream or local, got %q", i, r.Options.ExpectContinue)
+		}
+		disconnect := strings.ToLower(strings.TrimSpace(r.Options.Disconnect))
+		switch disconnect {
+		case "":
+			disconnect = "cancel"
+		case "cancel", "finish":
+		default:
+			return nil, fmt.Errorf("routes[%d].options.client_disconnect: must be cancel or finish, got %q", i, r.Options.Disconnect)
+		}
+		if rl := r.Options.RateLimit; rl != nil {
+			switch rl.Key = strings.ToLower(strings.TrimSpace(rl.Key)); rl.Key {
+			case "":
+				rl.Key = "route"
+			case "route", "ip":
+			default:
+				return nil, fmt.Errorf("routes[%d].options.rate_limit.key: must be route or ip, got %q", i, rl.Key)
+			}
+		}
+		compression, err := parseCompression(r.Options.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d].options.compression: %v", i, err)
+		}
+		var routeCache *RouteCache
+		if c := r.Options.Cache; c != nil {
+			if r.Options.GRPCWeb || r.Options.Transcode != nil {
+				return nil, fmt.Errorf("routes[%d].options.cache: cannot be combined with grpc_web or grpc_transcode", i)
+			}
+			routeCache = &RouteCache{MaxObjectSize: DefaultCacheMaxObjectSize}
+			if c.MaxObjectSize != nil {
+				if *c.MaxObjectSize <= 0 {
+					return nil, fmt.Errorf("routes[%d].options.cache.max_object_size: must be positive", i)
+				}
+				routeCache.MaxObjectSize = *c.MaxObjectSize
+			}
+		}
+		coalesce, err := parseCoalesce(r.Options.Coalesce)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d].options.coalesce: %v", i, err)
+		}
+		if coalesce != nil && (r.Options.GRPCWeb || r.Options.Transcode != nil) {
+			return nil, fmt.Errorf("routes[%d].options.coalesce: cannot be combined with grpc_web or grpc_transcode", i)
+		}
+		reqHeaders, err := compileHeaders(r.Options.RequestHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d].options.request_headers: %v", i, err)
+		}
+		resHeaders, err := compileHeaders(r.Options.ResponseHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d].options.response_headers: %v", i, err)
+		}
+		var tc *Transcode
+		if t := r.Options.Transcode; t != nil {
+			path := strings.TrimSpace(t.DescriptorSet)
+			if path == "" {
+				return nil, fmt.Errorf("routes[%d].options.grpc_transcode: descriptor_set is required", i)
+			}
+			if !speaksHTTP2(svcs[service]) {
+				return nil, fmt.Errorf("routes[%d].options.grpc_transcode: service %q must use proto h2c or auto", i, service)
+			}
+			tc = &Transcode{DescriptorSet: path, Services: t.Services}
+		}
 		rt := Route{
-			Name:         name,
-			Host:         host, // empty => wildcard
-			PathPrefix:   pfx,
-			Service:      service,
-			PreserveHost: r.Options.PreserveHost,
-			HostRewrite:  strings.TrimSpace(r.Options.HostRewrite),
-			RateLimit:    r.Options.RateLimit,
+			Name:            name,
+			Host:            host, // empty => wildcard
+			PathPrefix:      pfx,
+			Service:         service,
+			PreserveHost:    r.Options.PreserveHost,
+			HostRewrite:     strings.TrimSpace(r.Options.HostRewrite),
+			Timeout:         timeout,
+			GRPCWeb:         r.Options.GRPCWeb,
+			Expect:          expect,
+			Disconnect:      disconnect,
+			RequestHeaders:  reqHeaders,
+			ResponseHeaders: resHeaders,
+			Transcode:       tc,
+			RateLimit:       r.Options.RateLimit,
+			Subset:          subset,
+			Compression:     compression,
+			Cache:           routeCache,
+			Coalesce:        coalesce,
 		}
 		routes = append(routes, rt)
 	}
@@ -336,6 +718,18 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	// admin
+	admin := AdminConfig{
+		Address: strings.TrimSpace(rc.Admin.Address),
+		Token:   strings.TrimSpace(rc.Admin.Token),
+	}
+	if admin.Address != "" && admin.Address == rc.Metrics.Address {
+		return nil, fmt.Errorf("admin.address: must differ from metrics.address; leave it empty to share the metrics listener")
+	}
+	if admin.Address == "" && rc.Metrics.Address == "" && admin.Token != "" {
+		return nil, fmt.Errorf("admin.token: admin API needs admin.address or metrics.address")
+	}
+
 	// access log
 	var accessLog AccessLogConfig
 	accessLog.Fields = rc.AccessLog.Fields
@@ -345,6 +739,54 @@ func Load(path string) (*Config, error) {
 		accessLog.Sampling = 1.0 // default 100%
 	}
 
+	// tracing
+	raw := rc.Tracing
+	tracing := TracingConfig{
+		Endpoint:    strings.TrimSpace(raw.Endpoint),
+		Headers:     raw.Headers,
+		ServiceName: cmp.Or(strings.TrimSpace(raw.ServiceName), "gateway-homebrew-go"),
+		Sampling:    1.0,
+	}
+	if tracing.Endpoint != "" {
+		if u, err := url.Parse(tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("tracing.endpoint: must be an http(s) URL, got %q", raw.Endpoint)
+		}
+	}
+	if raw.Sampling != nil {
+		if *raw.Sampling < 0 || *raw.Sampling > 1 {
+			return nil, fmt.Errorf("tracing.sampling: must be between 0 and 1")
+		}
+		tracing.Sampling = *raw.Sampling
+	}
+	for j, p := range raw.Propagation {
+		switch strings.ToLower(strings.TrimSpace(p)) {
+		case "w3c":
+		case "b3":
+			tracing.B3 = true
+		default:
+			return nil, fmt.Errorf("tracing.propagation[%d]: must be w3c or b3, got %q", j, p)
+		}
+	}
+
+	// cache
+	cacheCfg := CacheConfig{
+		MaxBytes:     DefaultCacheMaxBytes,
+		DiskPath:     strings.TrimSpace(rc.Cache.Disk.Path),
+		DiskMaxBytes: DefaultCacheDiskMaxBytes,
+	}
+	if v := rc.Cache.MaxBytes; v != nil {
+		if *v <= 0 {
+			return nil, fmt.Errorf("cache.max_bytes: must be positive")
+		}
+		cacheCfg.MaxBytes = *v
+	}
+	if v := rc.Cache.Disk.MaxBytes; v != nil {
+		if *v <= 0 {
+			return nil, fmt.Errorf("cache.disk.max_bytes: must be positive")
+		}
+		cacheCfg.DiskMaxBytes = *v
+	}
+
 	// transport
 	var transport TransportConfig
 	transport.MaxIdleConns = rc.Transport.MaxIdleConns
@@ -386,6 +828,67 @@ func Load(path string) (*Config, error) {
 		transport.DialKeepAlive = 60 * time.Second
 	}
 
+	transport.H2PingInterval = 30 * time.Second
+	if rc.Transport.H2PingInterval != "" {
+		d, err := time.ParseDuration(rc.Transport.H2PingInterval)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("transport.h2_ping_interval: must be a non-negative duration")
+		}
+		transport.H2PingInterval = d
+	}
+	transport.H2PingTimeout = 15 * time.Second
+	if rc.Transport.H2PingTimeout != "" {
+		d, err := time.ParseDuration(rc.Transport.H2PingTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("transport.h2_ping_timeout: must be a positive duration")
+		}
+		transport.H2PingTimeout = d
+	}
+
+	hl := rc.HTTP.Headers
+	if hl.MaxCount < 0 || hl.MaxBytes < 0 || hl.MaxFieldBytes < 0 {
+		return nil, fmt.Errorf("http.headers: limits must not be negative")
+	}
+	var trusted []netip.Prefix
+	for j, s := range rc.HTTP.TrustedProxies {
+		pfx, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("http.trusted_proxies[%d]: %v", j, err)
+		}
+		trusted = append(trusted, pfx)
+	}
+	rid := rc.HTTP.RequestID
+	rid.Header = strings.TrimSpace(rid.Header)
+	if rid.Header == "" {
+		rid.Header = DefaultRequestIDHeader
+	}
+	if !httpguts.ValidHeaderFieldName(rid.Header) {
+		return nil, fmt.Errorf("http.request_id.header: invalid header name %q", rid.Header)
+	}
+	switch rid.Format = strings.ToLower(strings.TrimSpace(rid.Format)); rid.Format {
+	case "":
+		rid.Format = "uuid"
+	case "uuid", "ulid":
+	default:
+		return nil, fmt.Errorf("http.request_id.format: must be uuid or ulid, got %q", rid.Format)
+	}
+	httpCfg := HTTPConfig{
+		Headers: HeaderLimits{
+			Strict:        hl.Strict,
+			MaxCount:      cmp.Or(hl.MaxCount, DefaultMaxHeaderCount),
+			MaxBytes:      cmp.Or(hl.MaxBytes, DefaultMaxHeaderBytes),
+			MaxFieldBytes: cmp.Or(hl.MaxFieldBytes, DefaultMaxHeaderFieldBytes),
+		},
+		NormalizePath: PathNormalization{
+			MergeSlashes:     rc.HTTP.NormalizePath.MergeSlashes,
+			ResolveDots:      rc.HTTP.NormalizePath.ResolveDots,
+			DecodeUnreserved: rc.HTTP.NormalizePath.DecodeUnreserved,
+		},
+		TrustedProxies: trusted,
+		Forwarded:      rc.HTTP.Forwarded,
+		RequestID:      RequestIDConfig{Header: http.CanonicalHeaderKey(rid.Header), Format: rid.Format},
+	}
+
 	var refreshInterval time.Duration
 	if rc.RefreshInterval != "" {
 		d, err := time.ParseDuration(rc.RefreshInterval)
@@ -406,7 +909,258 @@ func Load(path string) (*Config, error) {
 		Timeouts:        timeouts,
 		TLS:             tlsConfig,
 		Metrics:         MetricsConfig{Address: rc.Metrics.Address},
+		Admin:           admin,
 		AccessLog:       accessLog,
+		Tracing:         tracing,
+		Cache:           cacheCfg,
 		Transport:       transport,
+		HTTP:            httpCfg,
 	}, nil
 }
+
+// Compression defaults.
+var (
+	DefaultCompressionAlgorithms   = []string{"zstd", "br", "gzip"}
+	DefaultCompressionContentTypes = []string{
+		"text/*", "application/json", "application/*+json", "application/javascript",
+		"application/xml", "application/*+xml", "image/svg+xml",
+	}
+)
+
+// DefaultCompressionMinSize is the smallest body of known length worth compressing.
+const DefaultCompressionMinSize = 1024
+
+func parseCompression(rc *rawCompression) (*Compression, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	c := &Compression{MinSize: DefaultCompressionMinSize}
+	seen := make(map[string]bool)
+	for _, a := range rc.Algorithms {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch a {
+		case "zstd", "br", "gzip":
+		default:
+			return nil, fmt.Errorf("algorithms: must be zstd, br or gzip, got %q", a)
+		}
+		if !seen[a] {
+			seen[a] = true
+			c.Algorithms = append(c.Algorithms, a)
+		}
+	}
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = DefaultCompressionAlgorithms
+	}
+	if rc.MinSize != nil {
+		if *rc.MinSize < 0 {
+			return nil, fmt.Errorf("min_size: must not be negative")
+		}
+		c.MinSize = *rc.MinSize
+	}
+	for _, ct := range rc.ContentTypes {
+		ct = strings.ToLower(strings.TrimSpace(ct))
+		if typ, sub, ok := strings.Cut(ct, "/"); !ok || typ == "" || sub == "" {
+			return nil, fmt.Errorf("content_types: invalid media type %q", ct)
+		}
+		c.ContentTypes = append(c.ContentTypes, ct)
+	}
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = DefaultCompressionContentTypes
+	}
+	return c, nil
+}
+
+func parseCoalesce(rc *rawCoalesce) (*Coalesce, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	c := &Coalesce{MaxBodySize: DefaultCoalesceMaxBodySize, Timeout: DefaultCoalesceTimeout}
+	for _, h := range rc.Headers {
+		h = strings.TrimSpace(h)
+		if !httpguts.ValidHeaderFieldName(h) {
+			return nil, fmt.Errorf("headers: invalid header name %q", h)
+		}
+		c.Headers = append(c.Headers, http.CanonicalHeaderKey(h))
+	}
+	if rc.MaxBodySize != nil {
+		if *rc.MaxBodySize <= 0 {
+			return nil, fmt.Errorf("max_body_size: must be positive")
+		}
+		c.MaxBodySize = *rc.MaxBodySize
+	}
+	if rc.Timeout != "" {
+		d, err := time.ParseDuration(rc.Timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("timeout: must be a positive duration")
+		}
+		c.Timeout = d
+	}
+	return c, nil
+}
+
+// parsePrefix accepts a CIDR or a bare address (a single-host prefix).
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// speaksHTTP2 reports whether calls to the service can go over HTTP/2, as
+// gRPC requires.
+func speaksHTTP2(svc Service) bool {
+	return svc.Proto == "h2c" || svc.Proto == "auto"
+}
+
+// SetLocalZone records the gateway's own zone on every service so balancers can
+// prefer same-zone peers.
+func (c *Config) SetLocalZone(zone string) {
+	for name, svc := range c.Services {
+		svc.LB.LocalZone = zone
+		c.Services[name] = svc
+	}
+}
+
+// DefaultConsulWait is the blocking query wait used when consul.wait is unset.
+const DefaultConsulWait = 5 * time.Minute
+
+func parseConsul(svcName, proto string, tls bool, rc rawConsul) (*ConsulDiscovery, error) {
+	cd := &ConsulDiscovery{
+		Address:    strings.TrimRight(strings.TrimSpace(rc.Address), "/"),
+		Service:    strings.TrimSpace(rc.Service),
+		Tag:        strings.TrimSpace(rc.Tag),
+		Datacenter: strings.TrimSpace(rc.Datacenter),
+		Token:      strings.TrimSpace(rc.Token),
+		Scheme:     strings.ToLower(strings.TrimSpace(rc.Scheme)),
+		Wait:       DefaultConsulWait,
+	}
+	if cd.Address == "" {
+		cd.Address = "http://127.0.0.1:8500"
+	}
+	if u, err := url.Parse(cd.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("address must be http(s) URL with host")
+	}
+	if cd.Service == "" {
+		cd.Service = svcName
+	}
+	if cd.Scheme == "" {
+		switch {
+		case proto == "tcp":
+			cd.Scheme = "tcp"
+		case tls:
+			cd.Scheme = "https"
+		default:
+			cd.Scheme = "http"
+		}
+	}
+	switch cd.Scheme {
+	case "http", "https", "tcp":
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", cd.Scheme)
+	}
+	if rc.Wait != "" {
+		d, err := time.ParseDuration(rc.Wait)
+		if err != nil {
+			return nil, fmt.Errorf("wait: %v", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("wait must be positive")
+		}
+		cd.Wait = d
+	}
+	return cd, nil
+}
+
+// parseEndpoint accepts either a bare URL string or a {url, weight, priority, zone, labels} mapping.
+func parseEndpoint(raw any) (Endpoint, error) {
+	var rawURL string
+	weight := 1
+	priority := 0
+	zone := ""
+	var labels map[string]string
+
+	switch v := raw.(type) {
+	case string:
+		rawURL = v
+	case map[string]any:
+		if u, ok := v["url"].(string); ok {
+			rawURL = u
+		}
+		if w, ok := v["weight"].(int); ok {
+			weight = w
+		}
+		if p, ok := v["priority"].(int); ok {
+			if p < 0 {
+				return Endpoint{}, fmt.Errorf("priority must not be negative")
+			}
+			priority = p
+		}
+		if z, ok := v["zone"].(string); ok {
+			zone = strings.TrimSpace(z)
+		}
+		if ls, ok := v["labels"].(map[string]any); ok {
+			labels = make(map[string]string, len(ls))
+			for k, lv := range ls {
+				labels[k] = fmt.Sprint(lv)
+			}
+		}
+	default:
+		return Endpoint{}, fmt.Errorf("invalid format")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("parse: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tcp") || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("must be http(s) or tcp URL with host")
+	}
+	return Endpoint{URL: u, Weight: weight, Priority: priority, Zone: zone, Labels: labels}, nil
+}
+
+// LoadEndpoints reads a standalone endpoints file (YAML or JSON). The file holds
+// either a bare list of endpoints or a mapping with an "endpoints" key; each entry
+// uses the same format as inline service endpoints.
+func LoadEndpoints(path string) ([]Endpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read endpoints_file: %w", err)
+	}
+	var doc any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("endpoints_file %s: yaml: %w", path, err)
+	}
+	var raws []any
+	switch v := doc.(type) {
+	case []any:
+		raws = v
+	case map[string]any:
+		list, ok := v["endpoints"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("endpoints_file %s: missing endpoints list", path)
+		}
+		raws = list
+	case nil:
+	default:
+		return nil, fmt.Errorf("endpoints_file %s: invalid format", path)
+	}
+	if len(raws) == 0 {
+		return nil, fmt.Errorf("endpoints_file %s: endpoints is empty", path)
+	}
+	eps := make([]Endpoint, 0, len(raws))
+	for j, raw := range raws {
+		ep, err := parseEndpoint(raw)
+		if err != nil {
+			return nil, fmt.Errorf("endpoints_file %s: endpoints[%d]: %v", path, j, err)
+		}
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func writeTmp(t *testing.T, content string) string {
@@ -312,3 +314,785 @@ routes:
 		t.Errorf("Burst: got %v, want 20", rt.RateLimit.Burst)
 	}
 }
+
+func TestLoad_EndpointsFile(t *testing.T) {
+	dir := t.TempDir()
+	epFile := filepath.Join(dir, "endpoints.json")
+	if err := os.WriteFile(epFile, []byte(`{"endpoints": ["http://e1:80", {"url": "http://e2:80", "weight": 3}]}`), 0o644); err != nil {
+		t.Fatalf("write endpoints file: %v", err)
+	}
+	yml := `
+services:
+  - name: s1
+    endpoints_file: "` + epFile + `"
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	fp := writeTmp(t, yml)
+	cfg, err := Load(fp)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	svc := cfg.Services["s1"]
+	if svc.EndpointsFile != epFile {
+		t.Errorf("endpoints_file: got %q, want %q", svc.EndpointsFile, epFile)
+	}
+	if len(svc.Endpoints) != 2 {
+		t.Fatalf("want 2 endpoints, got %d", len(svc.Endpoints))
+	}
+	if svc.Endpoints[1].URL.Host != "e2:80" || svc.Endpoints[1].Weight != 3 {
+		t.Errorf("e2: got %+v", svc.Endpoints[1])
+	}
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		fp := filepath.Join(dir, name)
+		if err := os.WriteFile(fp, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return fp
+	}
+
+	// bare YAML list
+	eps, err := LoadEndpoints(write("list.yaml", "- http://e1:80\n- { url: http://e2:80, weight: 2 }\n"))
+	if err != nil {
+		t.Fatalf("LoadEndpoints: %v", err)
+	}
+	if len(eps) != 2 || eps[1].Weight != 2 {
+		t.Fatalf("unexpected endpoints: %+v", eps)
+	}
+
+	// invalid inputs
+	for name, content := range map[string]string{
+		"empty.yaml":  "endpoints: []\n",
+		"scheme.yaml": "- ftp://e1:21\n",
+		"broken.json": `{"endpoints": [`,
+	} {
+		if _, err := LoadEndpoints(write(name, content)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+	if _, err := LoadEndpoints(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("missing file: want error")
+	}
+}
+
+func TestLoad_ConsulDiscovery(t *testing.T) {
+	yml := `
+services:
+  - name: web
+    discovery: consul
+    consul:
+      address: "http://consul.local:8500/"
+      tag: v2
+      wait: 30s
+    tls:
+      insecure_skip_verify: true
+routes:
+  - match: { path_prefix: "/" }
+    service: web
+`
+	fp := writeTmp(t, yml)
+	cfg, err := Load(fp)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	svc := cfg.Services["web"]
+	if svc.Discovery != "consul" || svc.Consul == nil {
+		t.Fatalf("discovery not parsed: %+v", svc)
+	}
+	if len(svc.Endpoints) != 0 {
+		t.Errorf("discovered service should start without endpoints, got %d", len(svc.Endpoints))
+	}
+	c := svc.Consul
+	if c.Address != "http://consul.local:8500" || c.Service != "web" || c.Tag != "v2" {
+		t.Errorf("consul config: got %+v", c)
+	}
+	if c.Scheme != "https" {
+		t.Errorf("scheme: got %q, want https (service has tls)", c.Scheme)
+	}
+	if c.Wait.Seconds() != 30 {
+		t.Errorf("wait: got %v, want 30s", c.Wait)
+	}
+
+	bad := `
+services:
+  - name: web
+    discovery: zookeeper
+routes:
+  - match: { path_prefix: "/" }
+    service: web
+`
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for unknown discovery provider")
+	}
+}
+
+func TestLoad_PriorityEndpoints(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    lb:
+      failover_threshold: 0.5
+    endpoints:
+      - "http://primary:80"
+      - { url: "http://standby:80", priority: 1 }
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	svc := cfg.Services["s1"]
+	if svc.Endpoints[0].Priority != 0 || svc.Endpoints[1].Priority != 1 {
+		t.Errorf("priorities: got %d, %d", svc.Endpoints[0].Priority, svc.Endpoints[1].Priority)
+	}
+	if svc.LB.FailoverThreshold != 0.5 {
+		t.Errorf("failover_threshold: got %v, want 0.5", svc.LB.FailoverThreshold)
+	}
+
+	bad := strings.Replace(yml, "0.5", "1.5", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for failover_threshold > 1")
+	}
+}
+
+func TestLoad_ZoneEndpoints(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    lb:
+      zone_traffic_share: 0.33
+    endpoints:
+      - { url: "http://a:80", zone: "us-east-1a" }
+      - "http://b:80"
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.SetLocalZone("us-east-1a")
+	svc := cfg.Services["s1"]
+	if svc.Endpoints[0].Zone != "us-east-1a" || svc.Endpoints[1].Zone != "" {
+		t.Errorf("zones: got %q, %q", svc.Endpoints[0].Zone, svc.Endpoints[1].Zone)
+	}
+	if svc.LB.LocalZone != "us-east-1a" {
+		t.Errorf("local zone: got %q", svc.LB.LocalZone)
+	}
+	if svc.LB.ZoneTrafficShare != 0.33 {
+		t.Errorf("zone_traffic_share: got %v", svc.LB.ZoneTrafficShare)
+	}
+}
+
+func TestLoad_LBPolicy(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    lb:
+      policy: peak_ewma
+      ewma_decay: 5s
+    endpoints: ["http://a:80"]
+  - name: s2
+    endpoints: ["http://b:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if lb := cfg.Services["s1"].LB; lb.Policy != "peak_ewma" || lb.EWMADecay != 5*time.Second {
+		t.Errorf("s1 lb: got %q/%v, want peak_ewma/5s", lb.Policy, lb.EWMADecay)
+	}
+	if lb := cfg.Services["s2"].LB; lb.Policy != "wrr" || lb.EWMADecay != DefaultEWMADecay {
+		t.Errorf("s2 lb: got %q/%v, want wrr/default", lb.Policy, lb.EWMADecay)
+	}
+
+	bad := strings.Replace(yml, "peak_ewma", "random", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for unknown lb policy")
+	}
+}
+
+func TestLoad_H2C(t *testing.T) {
+	yml := `
+transport:
+  h2_ping_interval: 10s
+  h2_ping_timeout: 2s
+services:
+  - name: grpc
+    proto: h2c
+    endpoints: ["http://a:50051"]
+routes:
+  - match: { path_prefix: "/" }
+    service: grpc
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Services["grpc"].Proto; got != "h2c" {
+		t.Errorf("proto: got %q, want h2c", got)
+	}
+	if cfg.Transport.H2PingInterval != 10*time.Second || cfg.Transport.H2PingTimeout != 2*time.Second {
+		t.Errorf("h2 ping: got %v/%v, want 10s/2s", cfg.Transport.H2PingInterval, cfg.Transport.H2PingTimeout)
+	}
+
+	bad := strings.Replace(yml, "proto: h2c", "proto: h2c\n    tls: { insecure_skip_verify: true }", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for h2c with upstream tls")
+	}
+}
+
+func TestLoad_H2CListener(t *testing.T) {
+	yml := `
+entrypoint:
+  - name: web
+    address: ":8080"
+    h2c: true
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.Listeners[0].H2C {
+		t.Error("h2c: got false, want true")
+	}
+
+	l4 := strings.Replace(yml, "h2c: true", "h2c: true\n    service: s1", 1)
+	if _, err := Load(writeTmp(t, l4)); err == nil {
+		t.Fatal("want error for h2c on an L4 listener")
+	}
+}
+
+func TestLoad_HTTP3Listener(t *testing.T) {
+	yml := `
+entrypoint:
+  - name: web
+    address: ":8443"
+    http3: true
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	if _, err := Load(writeTmp(t, yml)); err == nil {
+		t.Fatal("want error for http3 without tls")
+	}
+}
+
+func TestLoad_RouteOptions(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    proto: h2c
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+    options:
+      timeout: 1500ms
+      grpc_web: true
+      expect_continue: local
+      client_disconnect: finish
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Routes[0].Timeout; got != 1500*time.Millisecond {
+		t.Errorf("timeout: got %v, want 1.5s", got)
+	}
+	if !cfg.Routes[0].GRPCWeb {
+		t.Error("grpc_web: got false, want true")
+	}
+	if got := cfg.Routes[0].Expect; got != "local" {
+		t.Errorf("expect_continue: got %q, want local", got)
+	}
+	if got := cfg.Routes[0].Disconnect; got != "finish" {
+		t.Errorf("client_disconnect: got %q, want finish", got)
+	}
+
+	bad := strings.Replace(yml, "1500ms", "soon", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for invalid route timeout")
+	}
+	bad = strings.Replace(yml, "expect_continue: local", "expect_continue: never", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for invalid expect_continue")
+	}
+	bad = strings.Replace(yml, "client_disconnect: finish", "client_disconnect: ignore", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for invalid client_disconnect")
+	}
+	bad = strings.Replace(yml, "proto: h2c", "proto: http1", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for grpc_web to an HTTP/1.1 service")
+	}
+}
+
+func TestLoad_GRPCTranscode(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    proto: h2c
+    endpoints: ["http://a:50051"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+    options:
+      grpc_transcode:
+        descriptor_set: " ./ping.pb "
+        services: ["ping.Ping"]
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// the descriptor set is only read when the proxy builds its routes
+	if tc := cfg.Routes[0].Transcode; tc == nil || tc.DescriptorSet != "./ping.pb" || len(tc.Services) != 1 {
+		t.Fatalf("grpc_transcode: got %+v", tc)
+	}
+
+	bad := strings.Replace(yml, `" ./ping.pb "`, `""`, 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for missing descriptor_set")
+	}
+	bad = strings.Replace(yml, "proto: h2c", "proto: http1", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for grpc_transcode to an HTTP/1.1 service")
+	}
+}
+
+func TestLoad_LabelsAndSubset(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    endpoints:
+      - { url: "http://a:80", labels: { version: v1 } }
+      - { url: "http://b:80", labels: { version: v2, gpu: true } }
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+    options:
+      subset:
+        selector: { version: v1 }
+        headers: { X-Version: version }
+        fallback: default
+        default: { version: v1 }
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	eps := cfg.Services["s1"].Endpoints
+	if eps[0].Labels["version"] != "v1" || eps[1].Labels["gpu"] != "true" {
+		t.Errorf("labels: got %v, %v", eps[0].Labels, eps[1].Labels)
+	}
+	ss := cfg.Routes[0].Subset
+	if ss == nil {
+		t.Fatal("subset is nil")
+	}
+	if ss.Selector["version"] != "v1" || ss.Headers["X-Version"] != "version" || ss.Fallback != "default" {
+		t.Errorf("subset: got %+v", ss)
+	}
+
+	bad := strings.Replace(yml, "fallback: default", "fallback: random", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil {
+		t.Fatal("want error for unknown subset fallback")
+	}
+}
+
+func TestLoad_HeaderPolicies(t *testing.T) {
+	yml := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+    response_headers:
+      remove: [Server]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+    options:
+      request_headers:
+        set: { X-Route: "${route}" }
+        add: { Via: "1.1 gateway" }
+        append_if_absent: { X-Tenant: default }
+`
+	cfg, err := Load(writeTmp(t, yml))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Services["s1"].ResponseHeaders == nil || cfg.Services["s1"].RequestHeaders != nil {
+		t.Errorf("service policies: got req=%v res=%v", cfg.Services["s1"].RequestHeaders, cfg.Services["s1"].ResponseHeaders)
+	}
+	if cfg.Routes[0].RequestHeaders == nil || cfg.Routes[0].ResponseHeaders != nil {
+		t.Errorf("route policies: got req=%v res=%v", cfg.Routes[0].RequestHeaders, cfg.Routes[0].ResponseHeaders)
+	}
+
+	bad := strings.Replace(yml, "${route}", "${routes}", 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil || !strings.Contains(err.Error(), "routes[0].options.request_headers") {
+		t.Fatalf("want request_headers error, got %v", err)
+	}
+	bad = strings.Replace(yml, "[Server]", `["Bad Header"]`, 1)
+	if _, err := Load(writeTmp(t, bad)); err == nil || !strings.Contains(err.Error(), "services[0].response_headers") {
+		t.Fatalf("want response_headers error, got %v", err)
+	}
+}
+
+func TestLoad_HTTPValidation(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, base))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := HeaderLimits{MaxCount: DefaultMaxHeaderCount, MaxBytes: DefaultMaxHeaderBytes, MaxFieldBytes: DefaultMaxHeaderFieldBytes}
+	if cfg.HTTP.Headers != want {
+		t.Errorf("default limits: got %+v, want %+v", cfg.HTTP.Headers, want)
+	}
+	if cfg.HTTP.NormalizePath != (PathNormalization{}) {
+		t.Errorf("path normalization: got %+v, want off by default", cfg.HTTP.NormalizePath)
+	}
+
+	cfg, err = Load(writeTmp(t, base+`
+http:
+  headers: { strict: true, max_count: 50 }
+  normalize_path: { merge_slashes: true, resolve_dots: true }
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h := cfg.HTTP
+	if !h.Headers.Strict || h.Headers.MaxCount != 50 || h.Headers.MaxBytes != DefaultMaxHeaderBytes {
+		t.Errorf("headers: got %+v", h.Headers)
+	}
+	if !h.NormalizePath.MergeSlashes || !h.NormalizePath.ResolveDots || h.NormalizePath.DecodeUnreserved {
+		t.Errorf("normalize_path: got %+v", h.NormalizePath)
+	}
+
+	if _, err := Load(writeTmp(t, base+"http:\n  headers: { max_bytes: -1 }\n")); err == nil {
+		t.Fatal("want error for negative limit")
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+    options:
+      rate_limit: { requests_per_second: 10, burst: 5, key: IP }
+`
+	cfg, err := Load(writeTmp(t, base+`
+http:
+  trusted_proxies: ["10.0.0.0/8", "192.0.2.1", "2001:db8::/32"]
+  forwarded: true
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var got []string
+	for _, p := range cfg.HTTP.TrustedProxies {
+		got = append(got, p.String())
+	}
+	if want := "10.0.0.0/8 192.0.2.1/32 2001:db8::/32"; strings.Join(got, " ") != want {
+		t.Errorf("trusted_proxies: got %v, want %s", got, want)
+	}
+	if !cfg.HTTP.Forwarded {
+		t.Error("forwarded: want true")
+	}
+	if k := cfg.Routes[0].RateLimit.Key; k != "ip" {
+		t.Errorf("rate_limit.key: got %q, want ip", k)
+	}
+
+	for name, yml := range map[string]string{
+		"bad_cidr": base + "http:\n  trusted_proxies: [\"10.0.0.0/33\"]\n",
+		"bad_ip":   base + "http:\n  trusted_proxies: [\"proxy.local\"]\n",
+		"bad_key":  strings.Replace(base, "key: IP", "key: user", 1),
+	} {
+		if _, err := Load(writeTmp(t, yml)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestLoad_RequestID(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, base))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := (RequestIDConfig{Header: DefaultRequestIDHeader, Format: "uuid"}); cfg.HTTP.RequestID != want {
+		t.Errorf("default: got %+v, want %+v", cfg.HTTP.RequestID, want)
+	}
+
+	cfg, err = Load(writeTmp(t, base+"http:\n  request_id: { header: x-correlation-id, format: ULID }\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := (RequestIDConfig{Header: "X-Correlation-Id", Format: "ulid"}); cfg.HTTP.RequestID != want {
+		t.Errorf("configured: got %+v, want %+v", cfg.HTTP.RequestID, want)
+	}
+
+	for _, bad := range []string{"{ header: \"bad header\" }", "{ format: snowflake }"} {
+		if _, err := Load(writeTmp(t, base+"http:\n  request_id: "+bad+"\n")); err == nil {
+			t.Errorf("%s: want error", bad)
+		}
+	}
+}
+
+func TestLoad_Tracing(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, base))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if tc := cfg.Tracing; tc.Endpoint != "" || tc.Sampling != 1 || tc.B3 {
+		t.Errorf("default: got %+v, want disabled", tc)
+	}
+
+	cfg, err = Load(writeTmp(t, base+`
+tracing:
+  endpoint: http://collector:4318/v1/traces
+  service_name: edge
+  sampling: 0.1
+  propagation: [w3c, B3]
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if tc := cfg.Tracing; tc.Endpoint != "http://collector:4318/v1/traces" || tc.ServiceName != "edge" || tc.Sampling != 0.1 || !tc.B3 {
+		t.Errorf("configured: got %+v", tc)
+	}
+
+	for name, yml := range map[string]string{
+		"endpoint":    "tracing: { endpoint: \"collector:4318\" }\n",
+		"sampling":    "tracing: { endpoint: \"http://c:4318/v1/traces\", sampling: 2 }\n",
+		"propagation": "tracing: { endpoint: \"http://c:4318/v1/traces\", propagation: [jaeger] }\n",
+	} {
+		if _, err := Load(writeTmp(t, base+yml)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestLoad_Compression(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/a" }
+    service: s1
+  - match: { path_prefix: "/b" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, base+`
+    options:
+      compression: {}
+  - match: { path_prefix: "/c" }
+    service: s1
+    options:
+      compression: { algorithms: [GZIP, br, gzip], min_size: 0, content_types: ["application/json"] }
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	byPrefix := make(map[string]*Compression)
+	for _, r := range cfg.Routes {
+		byPrefix[r.PathPrefix] = r.Compression
+	}
+	if byPrefix["/a"] != nil {
+		t.Errorf("/a: compression should be off without a block")
+	}
+	if c := byPrefix["/b"]; c == nil || c.MinSize != DefaultCompressionMinSize || len(c.Algorithms) != 3 || len(c.ContentTypes) != len(DefaultCompressionContentTypes) {
+		t.Errorf("/b defaults: got %+v", c)
+	}
+	if c := byPrefix["/c"]; c == nil || c.MinSize != 0 || strings.Join(c.Algorithms, ",") != "gzip,br" || c.ContentTypes[0] != "application/json" {
+		t.Errorf("/c: got %+v", c)
+	}
+
+	for name, opts := range map[string]string{
+		"algorithm":    "{ algorithms: [deflate] }",
+		"min_size":     "{ min_size: -1 }",
+		"content_type": "{ content_types: [json] }",
+	} {
+		if _, err := Load(writeTmp(t, base+"    options:\n      compression: "+opts+"\n")); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestLoad_Cache(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/a" }
+    service: s1
+    options:
+      cache: {}
+  - match: { path_prefix: "/b" }
+    service: s1
+    options:
+      cache: { max_object_size: 4096 }
+`
+	cfg, err := Load(writeTmp(t, base))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Cache.MaxBytes != DefaultCacheMaxBytes || cfg.Cache.DiskPath != "" {
+		t.Errorf("cache defaults: got %+v", cfg.Cache)
+	}
+	for _, r := range cfg.Routes {
+		want := int64(DefaultCacheMaxObjectSize)
+		if r.PathPrefix == "/b" {
+			want = 4096
+		}
+		if r.Cache == nil || r.Cache.MaxObjectSize != want {
+			t.Errorf("%s: got %+v, want max_object_size %d", r.PathPrefix, r.Cache, want)
+		}
+	}
+
+	cfg, err = Load(writeTmp(t, base+`
+cache:
+  max_bytes: 1048576
+  disk: { path: /var/cache/gw, max_bytes: 2097152 }
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c := cfg.Cache; c.MaxBytes != 1<<20 || c.DiskPath != "/var/cache/gw" || c.DiskMaxBytes != 2<<20 {
+		t.Errorf("cache: got %+v", c)
+	}
+
+	for name, extra := range map[string]string{
+		"max_bytes":       "cache: { max_bytes: 0 }\n",
+		"disk.max_bytes":  "cache: { disk: { path: /tmp/x, max_bytes: -1 } }\n",
+		"max_object_size": "  - match: { path_prefix: \"/c\" }\n    service: s1\n    options:\n      cache: { max_object_size: 0 }\n",
+		"grpc_web":        "  - match: { path_prefix: \"/c\" }\n    service: s1\n    options:\n      grpc_web: true\n      cache: {}\n",
+	} {
+		if _, err := Load(writeTmp(t, base+extra)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestLoad_Coalesce(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/a" }
+    service: s1
+    options:
+      coalesce: {}
+  - match: { path_prefix: "/b" }
+    service: s1
+    options:
+      coalesce: { headers: [accept-encoding], max_body_size: 4096, timeout: 500ms }
+`
+	cfg, err := Load(writeTmp(t, base))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	byPrefix := make(map[string]*Coalesce)
+	for _, r := range cfg.Routes {
+		byPrefix[r.PathPrefix] = r.Coalesce
+	}
+	if c := byPrefix["/a"]; c == nil || c.MaxBodySize != DefaultCoalesceMaxBodySize || c.Timeout != DefaultCoalesceTimeout || len(c.Headers) != 0 {
+		t.Errorf("/a defaults: got %+v", c)
+	}
+	if c := byPrefix["/b"]; c == nil || c.MaxBodySize != 4096 || c.Timeout != 500*time.Millisecond || strings.Join(c.Headers, ",") != "Accept-Encoding" {
+		t.Errorf("/b: got %+v", c)
+	}
+
+	for name, opts := range map[string]string{
+		"header":        "{ headers: [\"bad header\"] }",
+		"max_body_size": "{ max_body_size: 0 }",
+		"timeout":       "{ timeout: -1s }",
+	} {
+		yml := base + "  - match: { path_prefix: \"/c\" }\n    service: s1\n    options:\n      coalesce: " + opts + "\n"
+		if _, err := Load(writeTmp(t, yml)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestLoad_Admin(t *testing.T) {
+	base := `
+services:
+  - name: s1
+    endpoints: ["http://a:80"]
+routes:
+  - match: { path_prefix: "/" }
+    service: s1
+`
+	cfg, err := Load(writeTmp(t, base+"metrics: { address: \":9090\" }\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Admin != (AdminConfig{}) {
+		t.Errorf("default: got %+v, want admin on the metrics listener without token", cfg.Admin)
+	}
+
+	cfg, err = Load(writeTmp(t, base+"metrics: { address: \":9090\" }\nadmin: { address: \"127.0.0.1:9091\", token: \" s3cret \" }\n"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := (AdminConfig{Address: "127.0.0.1:9091", Token: "s3cret"}); cfg.Admin != want {
+		t.Errorf("configured: got %+v, want %+v", cfg.Admin, want)
+	}
+
+	for name, yml := range map[string]string{
+		"same address": "metrics: { address: \":9090\" }\nadmin: { address: \":9090\" }\n",
+		"no listener":  "admin: { token: s3cret }\n",
+	} {
+		if _, err := Load(writeTmp(t, base+yml)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
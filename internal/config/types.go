@@ -1,13 +1,28 @@
 package config
 
-import "net/url"
+import (
+	"net/url"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/headers"
+)
 
 // Service upstream pool with protocol and endpoints.
 type Service struct {
 	Name      string
-	Proto     string     // "http1" | "auto" | "h2c" (future: "h2","h3")
-	Endpoints []Endpoint // normalized, non-empty
+	Proto     string     // "http1" | "auto" | "h2c" | "tcp"
+	Endpoints []Endpoint // normalized; non-empty unless filled by Discovery
+	// EndpointsFile, if set, is the source of Endpoints and is watched for changes.
+	EndpointsFile string
+	// Discovery names a dynamic endpoint provider ("" = static, "consul").
+	Discovery string
+	Consul    *ConsulDiscovery // set when Discovery == "consul"
+	LB        LoadBalancing
 	TLS       *UpstreamTLS
+	// RequestHeaders/ResponseHeaders mutate headers of every call to the
+	// service, before the route's own policies.
+	RequestHeaders  *headers.Policy
+	ResponseHeaders *headers.Policy
 	// TODO: LB policy, healthcheck...
 }
 
@@ -18,32 +33,117 @@ type UpstreamTLS struct {
 	KeyFile            string
 }
 
+// ConsulDiscovery configures long-polling a Consul-compatible health API.
+type ConsulDiscovery struct {
+	Address    string        // catalog base URL, e.g. "http://127.0.0.1:8500"
+	Service    string        // catalog service name (defaults to Service.Name)
+	Tag        string        // optional tag filter
+	Datacenter string        // optional datacenter
+	Token      string        // optional ACL token
+	Scheme     string        // endpoint URL scheme (defaults from proto/tls)
+	Wait       time.Duration // blocking query wait time
+}
+
+// LoadBalancing tunes peer selection of a service.
+type LoadBalancing struct {
+	// Policy picks among available peers: "wrr" (smooth weighted round robin,
+	// default) or "peak_ewma" (latency-aware power of two choices).
+	Policy string
+	// EWMADecay is the peak_ewma latency decay time constant.
+	EWMADecay time.Duration
+	// FailoverThreshold is the healthy fraction of a priority group below which
+	// traffic starts spilling over to the next group, in (0, 1].
+	FailoverThreshold float64
+	// ZoneTrafficShare is the expected share of the service's traffic that
+	// originates in the gateway's own zone (e.g. 0.33 with gateways spread over
+	// three zones). When the local zone holds less than this share of healthy
+	// weight, the remainder spills to other zones. 0 assumes gateways are spread
+	// evenly over the zones of the service's peers.
+	ZoneTrafficShare float64
+	// LocalZone is the gateway's own zone, set at startup via SetLocalZone.
+	LocalZone string
+}
+
 type Endpoint struct {
-	URL    *url.URL
-	Weight int // 0 means default (1)
+	URL      *url.URL
+	Weight   int    // 0 means default (1)
+	Priority int    // 0 is the highest priority; higher values are failover groups
+	Zone     string // locality, e.g. "us-east-1a"; empty if unknown
+	// Labels are arbitrary key/values (e.g. version: v2) used for subset selection.
+	Labels map[string]string
+	// Tags carry instance tags reported by a discovery provider.
+	Tags []string
+}
+
+// Subset restricts a route to endpoints whose labels match a selector.
+type Subset struct {
+	Selector map[string]string // static label selector
+	Headers  map[string]string // request header -> label key; a present header overrides Selector
+	Fallback string            // "any" (default) | "none" | "default"
+	Default  map[string]string // selector used when Fallback == "default"
+}
+
+// Transcode exposes gRPC methods as JSON/REST using a FileDescriptorSet.
+type Transcode struct {
+	DescriptorSet string   // path to a binary FileDescriptorSet
+	Services      []string // fully-qualified services to expose; empty = all
 }
 
 // Route match + action.
 type Route struct {
-	Name         string
-	Host         string           // empty => wildcard
-	PathPrefix   string           // must start with "/"
-	Service      string           // Service.Name
-	PreserveHost bool             // optional (default false)
-	HostRewrite  string           // optional; if set, overrides PreserveHost
-	RateLimit    *RateLimitConfig // optional: rate limiting configuration for this route
+	Name            string
+	Host            string           // empty => wildcard
+	PathPrefix      string           // must start with "/"
+	Service         string           // Service.Name
+	PreserveHost    bool             // optional (default false)
+	HostRewrite     string           // optional; if set, overrides PreserveHost
+	Timeout         time.Duration    // optional; overrides timeouts.upstream for this route
+	GRPCWeb         bool             // optional: translate gRPC-Web calls to native gRPC
+	Expect          string           // "upstream" (default): relay 100-continue from upstream | "local": answer it here
+	Disconnect      string           // "cancel" (default) | "finish": idempotent upstream calls outlive the client
+	RequestHeaders  *headers.Policy  // optional: applied after the service's policy
+	ResponseHeaders *headers.Policy  // optional: applied after the service's policy
+	Transcode       *Transcode       // optional: JSON/REST to gRPC transcoding
+	RateLimit       *RateLimitConfig // optional: rate limiting configuration for this route
+	Subset          *Subset          // optional: endpoint subset selection
+	Compression     *Compression     // optional: compress responses for clients that accept it
+	Cache           *RouteCache      // optional: serve GET/HEAD from the shared response cache
+	Coalesce        *Coalesce        // optional: share one upstream call among identical concurrent requests
+}
+
+// Compression selects which upstream responses a route compresses.
+type Compression struct {
+	Algorithms   []string // "zstd", "br", "gzip", in server preference order
+	MinSize      int64    // bodies of known length below this stay uncompressed
+	ContentTypes []string // media types, "text/*" and "application/*+json" patterns
+}
+
+// RouteCache enables the response cache on a route.
+type RouteCache struct {
+	MaxObjectSize int64 // larger responses are relayed but not stored
+}
+
+// Coalesce lets identical concurrent GET/HEAD requests on a route wait for a
+// single upstream call and share its response.
+type Coalesce struct {
+	Headers     []string      // canonical names of request headers that are part of the key
+	MaxBodySize int64         // larger responses are not shared; waiters make their own call
+	Timeout     time.Duration // how long a request waits before making its own call
 }
 
-// Listener defines an entrypoint.
 type RateLimitConfig struct {
 	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
 	Burst             int     `yaml:"burst"`
-	// Key determines the scope of the rate limit, e.g., "ip", "route", or a combination.
-	// For now, let's keep it simple and assume per-route if configured.
+	// Key determines the scope of the rate limit: "route" (default) shares one
+	// bucket per route, "ip" gives each client IP its own bucket on the route.
+	Key string `yaml:"key"`
 }
 
+// Listener defines an entrypoint.
 type Listener struct {
 	Name    string
 	Address string
 	Service string // if non-empty, L4 TCP proxy to this service; else L7 HTTP
+	H2C     bool   // plaintext L7: also accept HTTP/2 (prior knowledge and Upgrade: h2c)
+	HTTP3   bool   // TLS L7: also serve HTTP/3 over QUIC on the same UDP port
 }
@@ -0,0 +1,177 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+const (
+	consulMinBackoff = 1 * time.Second
+	consulMaxBackoff = 30 * time.Second
+)
+
+// consulEntry is the subset of a /v1/health/service/<name> result we consume.
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Tags    []string          `json:"Tags"`
+		Meta    map[string]string `json:"Meta"`
+		Weights struct {
+			Passing int `json:"Passing"`
+		} `json:"Weights"`
+	} `json:"Service"`
+	Checks []struct {
+		Status string `json:"Status"`
+	} `json:"Checks"`
+}
+
+// consulClient issues blocking queries against a Consul-compatible health API.
+type consulClient struct {
+	cfg  config.ConsulDiscovery
+	http *http.Client
+}
+
+func newConsulClient(cfg config.ConsulDiscovery) *consulClient {
+	// The server may hold the request for wait plus up to wait/16 of jitter.
+	return &consulClient{cfg: cfg, http: &http.Client{Timeout: cfg.Wait + cfg.Wait/16 + 5*time.Second}}
+}
+
+// fetch performs one blocking query. It returns the passing endpoints and the
+// X-Consul-Index to use for the next call.
+func (c *consulClient) fetch(ctx context.Context, index uint64) ([]config.Endpoint, uint64, error) {
+	q := url.Values{}
+	q.Set("passing", "1")
+	q.Set("wait", fmt.Sprintf("%dms", c.cfg.Wait.Milliseconds()))
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+	}
+	if c.cfg.Tag != "" {
+		q.Set("tag", c.cfg.Tag)
+	}
+	if c.cfg.Datacenter != "" {
+		q.Set("dc", c.cfg.Datacenter)
+	}
+	u := c.cfg.Address + "/v1/health/service/" + url.PathEscape(c.cfg.Service) + "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul: unexpected status %d", res.StatusCode)
+	}
+	next, err := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul: invalid X-Consul-Index %q", res.Header.Get("X-Consul-Index"))
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul: decode: %w", err)
+	}
+	return c.endpoints(entries), next, nil
+}
+
+func (c *consulClient) endpoints(entries []consulEntry) []config.Endpoint {
+	eps := make([]config.Endpoint, 0, len(entries))
+	for _, e := range entries {
+		if !allPassing(e) {
+			continue
+		}
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if host == "" || e.Service.Port <= 0 {
+			continue
+		}
+		weight := e.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		eps = append(eps, config.Endpoint{
+			URL:    &url.URL{Scheme: c.cfg.Scheme, Host: net.JoinHostPort(host, strconv.Itoa(e.Service.Port))},
+			Weight: weight,
+			Zone:   e.Service.Meta["zone"],
+			Labels: e.Service.Meta,
+			Tags:   e.Service.Tags,
+		})
+	}
+	return eps
+}
+
+func allPassing(e consulEntry) bool {
+	for _, c := range e.Checks {
+		if c.Status != "passing" {
+			return false
+		}
+	}
+	return true
+}
+
+// watchConsul long-polls the catalog and applies every change of the passing
+// instance set. Errors back off exponentially; an empty result keeps the
+// previous peers.
+func watchConsul(ctx context.Context, service string, cfg config.ConsulDiscovery, apply func([]config.Endpoint) error) {
+	c := newConsulClient(cfg)
+	source := "consul " + cfg.Address + "/" + cfg.Service
+	var index uint64
+	backoff := consulMinBackoff
+
+	for ctx.Err() == nil {
+		eps, next, err := c.fetch(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("service %s: %s: %v (retry in %s)", service, source, err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, consulMaxBackoff)
+			continue
+		}
+		backoff = consulMinBackoff
+
+		switch {
+		case next < index:
+			// index went backwards (e.g. catalog restore): start over
+			index = 0
+			continue
+		case next == index:
+			// wait expired without changes
+			continue
+		}
+		index = next
+
+		if err := apply(eps); err != nil {
+			logApplyErr(service, source, err)
+			continue
+		}
+		log.Printf("service %s: endpoints updated from %s (%d peers)", service, source, len(eps))
+	}
+}
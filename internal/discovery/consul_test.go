@@ -0,0 +1,163 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+// fakeCatalog is a minimal in-process Consul health API with blocking queries.
+type fakeCatalog struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	index   uint64
+	entries []map[string]any
+	queries []string
+}
+
+func newFakeCatalog() *fakeCatalog {
+	return &fakeCatalog{changed: make(chan struct{}), index: 1}
+}
+
+func (c *fakeCatalog) set(entries ...map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = entries
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.queries = append(c.queries, r.URL.RequestURI())
+	index, changed := c.index, c.changed
+	c.mu.Unlock()
+
+	if want, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); want >= index {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	_ = json.NewEncoder(w).Encode(c.entries)
+}
+
+func instance(id, addr string, port int, status string) map[string]any {
+	return map[string]any{
+		"Node": map[string]any{"Address": "10.9.9.9"},
+		"Service": map[string]any{
+			"ID":      id,
+			"Address": addr,
+			"Port":    port,
+			"Tags":    []string{"v2"},
+			"Meta":    map[string]string{"zone": "a"},
+			"Weights": map[string]int{"Passing": 3},
+		},
+		"Checks": []map[string]string{{"Status": status}},
+	}
+}
+
+func TestManager_Consul(t *testing.T) {
+	catalog := newFakeCatalog()
+	catalog.set(
+		instance("web-1", "10.0.0.1", 8080, "passing"),
+		instance("web-2", "", 8081, "passing"),
+		instance("web-3", "10.0.0.3", 8082, "critical"),
+	)
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	u := &recordingUpdater{}
+	m := NewManager(u, 0)
+	defer m.Stop()
+	m.Reconcile(map[string]config.Service{
+		"web": {Name: "web", Discovery: "consul", Consul: &config.ConsulDiscovery{
+			Address: srv.URL,
+			Service: "web",
+			Tag:     "v2",
+			Token:   "secret",
+			Scheme:  "http",
+			Wait:    200 * time.Millisecond,
+		}},
+	})
+
+	waitFor(t, "initial consul update", func() bool { return u.count("web") == 1 })
+	eps := u.last("web")
+	if len(eps) != 2 {
+		t.Fatalf("want 2 passing endpoints, got %+v", eps)
+	}
+	if got := eps[0].URL.String(); got != "http://10.0.0.1:8080" {
+		t.Errorf("endpoint 0: got %s", got)
+	}
+	if got := eps[1].URL.Host; got != "10.9.9.9:8081" {
+		t.Errorf("endpoint 1 should fall back to node address, got %s", got)
+	}
+	if eps[0].Weight != 3 || len(eps[0].Tags) != 1 || eps[0].Labels["zone"] != "a" || eps[0].Zone != "a" {
+		t.Errorf("endpoint metadata not carried: %+v", eps[0])
+	}
+
+	// a catalog change wakes the blocking query
+	catalog.set(instance("web-4", "10.0.0.4", 9090, "passing"))
+	waitFor(t, "second consul update", func() bool { return u.count("web") == 2 })
+	if eps := u.last("web"); len(eps) != 1 || eps[0].URL.Host != "10.0.0.4:9090" {
+		t.Fatalf("unexpected endpoints after change: %+v", eps)
+	}
+
+	// wait expiry without changes must not re-apply
+	time.Sleep(300 * time.Millisecond)
+	if got := u.count("web"); got != 2 {
+		t.Fatalf("unchanged index re-applied: %d updates", got)
+	}
+
+	catalog.mu.Lock()
+	first := catalog.queries[0]
+	catalog.mu.Unlock()
+	if want := "/v1/health/service/web?passing=1&tag=v2&wait=200ms"; first != want {
+		t.Errorf("query: got %s, want %s", first, want)
+	}
+}
+
+func TestManager_ReconcileReappliesAfterReload(t *testing.T) {
+	catalog := newFakeCatalog()
+	catalog.set(instance("web-1", "10.0.0.1", 8080, "passing"))
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	// a discovered service starts empty in the config, also after every reload
+	svcs := map[string]config.Service{
+		"web": {Name: "web", Discovery: "consul", Consul: &config.ConsulDiscovery{
+			Address: srv.URL,
+			Service: "web",
+			Scheme:  "http",
+			Wait:    time.Minute,
+		}},
+	}
+	u := &recordingUpdater{}
+	m := NewManager(u, 0)
+	defer m.Stop()
+	m.Reconcile(svcs)
+	waitFor(t, "initial consul update", func() bool { return u.count("web") == 1 })
+
+	// the catalog index does not move, so only the re-apply can restore peers
+	m.Reconcile(svcs)
+	if got := u.count("web"); got != 2 {
+		t.Fatalf("reload must re-apply discovered endpoints, got %d updates", got)
+	}
+	if eps := u.last("web"); len(eps) != 1 || eps[0].URL.Host != "10.0.0.1:8080" {
+		t.Fatalf("unexpected endpoints after reload: %+v", eps)
+	}
+}
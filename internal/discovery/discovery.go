@@ -0,0 +1,182 @@
+// Package discovery keeps service endpoints in sync with sources that live
+// outside the main config file.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+// Updater applies a new endpoint set to a single running service.
+type Updater interface {
+	UpdateEndpoints(service string, eps []config.Endpoint) error
+}
+
+// DefaultFileInterval is the poll interval of endpoints files when none is
+// configured.
+const DefaultFileInterval = 5 * time.Second
+
+// Manager runs one watch per service that has a dynamic endpoint source.
+type Manager struct {
+	updater  Updater
+	interval time.Duration // file poll interval; guarded by mu
+
+	mu      sync.Mutex
+	watches map[string]*watch // service name -> running watch
+}
+
+type watch struct {
+	source   string
+	interval time.Duration // file poll interval; 0 for sources that are not polled
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu   sync.Mutex        // serializes updates of this service
+	last []config.Endpoint // endpoints last applied from the source
+}
+
+// NewManager creates a Manager that pushes endpoint changes into u.
+// File sources are polled every interval, or DefaultFileInterval if
+// interval <= 0.
+func NewManager(u Updater, interval time.Duration) *Manager {
+	return &Manager{
+		updater:  u,
+		interval: fileInterval(interval),
+		watches:  make(map[string]*watch),
+	}
+}
+
+// SetInterval changes the poll interval of file sources; running file watches
+// pick it up on the next Reconcile.
+func (m *Manager) SetInterval(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interval = fileInterval(interval)
+}
+
+func fileInterval(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultFileInterval
+	}
+	return d
+}
+
+// Reconcile starts watches for new services, restarts watches whose source
+// or poll interval changed and stops watches for services that are gone. It
+// is called once at startup and again after every config hot reload. A
+// reload re-reads endpoints files itself; discovered services that kept
+// their source get the endpoints last applied again if the reload lost them.
+func (m *Manager) Reconcile(svcs map[string]config.Service) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name, w := range m.watches {
+		svc, ok := svcs[name]
+		if ok && sourceOf(svc) == w.source && (w.interval == 0 || w.interval == m.interval) {
+			if w.interval == 0 {
+				w.reapply(name, svc.Endpoints, m.updater)
+			}
+			continue
+		}
+		w.stop()
+		delete(m.watches, name)
+	}
+
+	for name, svc := range svcs {
+		if _, ok := m.watches[name]; ok {
+			continue
+		}
+		if w := m.start(name, svc); w != nil {
+			m.watches[name] = w
+		}
+	}
+}
+
+// Stop terminates all running watches.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, w := range m.watches {
+		w.stop()
+		delete(m.watches, name)
+	}
+}
+
+// sourceOf identifies the dynamic endpoint source of a service, or "" if its
+// endpoints are static.
+func sourceOf(svc config.Service) string {
+	switch {
+	case svc.EndpointsFile != "":
+		return "file:" + svc.EndpointsFile
+	case svc.Discovery == "consul" && svc.Consul != nil:
+		return fmt.Sprintf("consul:%+v", *svc.Consul)
+	}
+	return ""
+}
+
+func (m *Manager) start(name string, svc config.Service) *watch {
+	source := sourceOf(svc)
+	if source == "" {
+		return nil
+	}
+
+	w := &watch{source: source, done: make(chan struct{})}
+	apply := func(eps []config.Endpoint) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err := m.updater.UpdateEndpoints(name, eps); err != nil {
+			return err
+		}
+		w.last = eps
+		return nil
+	}
+
+	var run func(ctx context.Context)
+	switch {
+	case svc.EndpointsFile != "":
+		path, loaded, interval := svc.EndpointsFile, svc.Endpoints, m.interval
+		w.interval = interval
+		run = func(ctx context.Context) { watchFile(ctx, name, path, loaded, interval, apply) }
+	case svc.Discovery == "consul" && svc.Consul != nil:
+		cfg := *svc.Consul
+		run = func(ctx context.Context) { watchConsul(ctx, name, cfg, apply) }
+	default:
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go func() {
+		defer close(w.done)
+		run(ctx)
+	}()
+	return w
+}
+
+// reapply pushes the last applied endpoints of a discovered service again
+// when a reload reset the service to different endpoints from the config.
+func (w *watch) reapply(service string, reloaded []config.Endpoint, u Updater) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.last) == 0 || reflect.DeepEqual(w.last, reloaded) {
+		return
+	}
+	if err := u.UpdateEndpoints(service, w.last); err != nil {
+		logApplyErr(service, w.source, err)
+	}
+}
+
+func (w *watch) stop() {
+	w.cancel()
+	<-w.done
+}
+
+func logApplyErr(service, source string, err error) {
+	log.Printf("service %s: endpoints update from %s rejected, keeping previous: %v", service, source, err)
+}
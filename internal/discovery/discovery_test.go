@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+type recordingUpdater struct {
+	mu      sync.Mutex
+	updates map[string][][]config.Endpoint
+}
+
+func (u *recordingUpdater) UpdateEndpoints(service string, eps []config.Endpoint) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.updates == nil {
+		u.updates = make(map[string][][]config.Endpoint)
+	}
+	u.updates[service] = append(u.updates[service], eps)
+	return nil
+}
+
+func (u *recordingUpdater) count(service string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.updates[service])
+}
+
+func (u *recordingUpdater) last(service string) []config.Endpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	all := u.updates[service]
+	if len(all) == 0 {
+		return nil
+	}
+	return all[len(all)-1]
+}
+
+// touch rewrites a file and bumps its mtime so the poller notices the change
+// regardless of filesystem timestamp resolution.
+func touch(t *testing.T, path, content string, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("chtimes %s: %v", path, err)
+	}
+}
+
+func loadEndpoints(t *testing.T, path string) []config.Endpoint {
+	t.Helper()
+	eps, err := config.LoadEndpoints(path)
+	if err != nil {
+		t.Fatalf("load %s: %v", path, err)
+	}
+	return eps
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestManager_FileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	base := time.Now().Add(-time.Hour)
+	touch(t, path, "- http://e1:80\n", base)
+
+	u := &recordingUpdater{}
+	m := NewManager(u, 10*time.Millisecond)
+	defer m.Stop()
+	m.Reconcile(map[string]config.Service{
+		"s1":     {Name: "s1", EndpointsFile: path, Endpoints: loadEndpoints(t, path)},
+		"static": {Name: "static"},
+	})
+
+	// valid change is applied
+	touch(t, path, "- http://e1:80\n- http://e2:80\n", base.Add(time.Second))
+	waitFor(t, "first update", func() bool { return u.count("s1") == 1 })
+	if eps := u.last("s1"); len(eps) != 2 || eps[1].URL.Host != "e2:80" {
+		t.Fatalf("unexpected endpoints: %+v", eps)
+	}
+
+	// invalid change is rejected; the following valid change still applies
+	touch(t, path, "endpoints: [", base.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if got := u.count("s1"); got != 1 {
+		t.Fatalf("bad file applied: got %d updates, want 1", got)
+	}
+	touch(t, path, "- http://e3:80\n", base.Add(3*time.Second))
+	waitFor(t, "second update", func() bool { return u.count("s1") == 2 })
+	if eps := u.last("s1"); len(eps) != 1 || eps[0].URL.Host != "e3:80" {
+		t.Fatalf("unexpected endpoints: %+v", eps)
+	}
+	if u.count("static") != 0 {
+		t.Fatalf("static service must not be updated")
+	}
+}
+
+func TestManager_ReconcileStopsRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	base := time.Now().Add(-time.Hour)
+	touch(t, path, "- http://e1:80\n", base)
+
+	u := &recordingUpdater{}
+	m := NewManager(u, 10*time.Millisecond)
+	defer m.Stop()
+	m.Reconcile(map[string]config.Service{"s1": {Name: "s1", EndpointsFile: path, Endpoints: loadEndpoints(t, path)}})
+	if len(m.watches) != 1 {
+		t.Fatalf("want 1 watch, got %d", len(m.watches))
+	}
+
+	m.Reconcile(map[string]config.Service{"s1": {Name: "s1"}})
+	if len(m.watches) != 0 {
+		t.Fatalf("want 0 watches after reconcile, got %d", len(m.watches))
+	}
+
+	touch(t, path, "- http://e2:80\n", base.Add(time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if u.count("s1") != 0 {
+		t.Fatalf("stopped watch must not apply updates")
+	}
+}
+
+func TestManager_FileWatchCatchesWriteAfterLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	base := time.Now().Add(-time.Hour)
+	touch(t, path, "- http://e1:80\n", base)
+	loaded := loadEndpoints(t, path)
+
+	// the file changes after config.Load read it but before the watch starts
+	touch(t, path, "- http://e2:80\n", base.Add(time.Second))
+
+	u := &recordingUpdater{}
+	m := NewManager(u, time.Hour)
+	defer m.Stop()
+	m.Reconcile(map[string]config.Service{"s1": {Name: "s1", EndpointsFile: path, Endpoints: loaded}})
+
+	waitFor(t, "catch-up update", func() bool { return u.count("s1") == 1 })
+	if eps := u.last("s1"); len(eps) != 1 || eps[0].URL.Host != "e2:80" {
+		t.Fatalf("unexpected endpoints: %+v", eps)
+	}
+}
+
+func TestManager_ReconcileKeepsReloadedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	base := time.Now().Add(-time.Hour)
+	touch(t, path, "- http://e1:80\n", base)
+
+	u := &recordingUpdater{}
+	m := NewManager(u, time.Hour)
+	defer m.Stop()
+	m.Reconcile(map[string]config.Service{"s1": {Name: "s1", EndpointsFile: path, Endpoints: loadEndpoints(t, path)}})
+
+	// the reload read a newer file than the watch has seen: it must stand
+	touch(t, path, "- http://e3:80\n", base.Add(time.Second))
+	m.Reconcile(map[string]config.Service{"s1": {Name: "s1", EndpointsFile: path, Endpoints: loadEndpoints(t, path)}})
+	if got := u.count("s1"); got != 0 {
+		t.Fatalf("reload of a file source pushed %d updates, last %+v", got, u.last("s1"))
+	}
+}
+
+func TestManager_FileInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	touch(t, path, "- http://e1:80\n", time.Now().Add(-time.Hour))
+	svcs := map[string]config.Service{"s1": {Name: "s1", EndpointsFile: path, Endpoints: loadEndpoints(t, path)}}
+
+	// no refresh interval still watches the file, at the default rate
+	m := NewManager(&recordingUpdater{}, 0)
+	defer m.Stop()
+	m.Reconcile(svcs)
+	first := m.watches["s1"]
+	if first == nil || first.interval != DefaultFileInterval {
+		t.Fatalf("want a file watch at %v, got %+v", DefaultFileInterval, first)
+	}
+
+	// an unchanged reload keeps the watch; a new interval restarts it
+	m.Reconcile(svcs)
+	if m.watches["s1"] != first {
+		t.Fatal("unchanged reload restarted the watch")
+	}
+	m.SetInterval(time.Second)
+	m.Reconcile(svcs)
+	if w := m.watches["s1"]; w == first || w.interval != time.Second {
+		t.Fatalf("interval change not applied: %+v", w)
+	}
+}
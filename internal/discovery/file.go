@@ -0,0 +1,55 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+// watchFile polls an endpoints file and applies its contents whenever its
+// modification time changes. loaded holds the endpoints the service runs with,
+// read by config.Load before the watch started; the file is checked once right
+// away so a write that raced with that read is not missed. The mtime is always
+// taken before the file is read, so a write during a read shows up on the next
+// poll. An invalid file leaves the current peers in place.
+func watchFile(ctx context.Context, service, path string, loaded []config.Endpoint, interval time.Duration, apply func([]config.Endpoint) error) {
+	var lastMod time.Time
+	poll := func() {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(lastMod) {
+			return
+		}
+		lastMod = info.ModTime()
+
+		eps, err := config.LoadEndpoints(path)
+		if err != nil {
+			logApplyErr(service, path, err)
+			return
+		}
+		if reflect.DeepEqual(eps, loaded) {
+			return
+		}
+		if err := apply(eps); err != nil {
+			logApplyErr(service, path, err)
+			return
+		}
+		loaded = eps
+		log.Printf("service %s: endpoints reloaded from %s (%d peers)", service, path, len(eps))
+	}
+
+	poll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package headers implements the request and response header policies that
+// routes and services apply to proxied traffic.
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Vars is the request context available to header value templates.
+type Vars struct {
+	ClientIP     string // client address, without port
+	ForwardedFor string // X-Forwarded-For with this hop's peer appended
+	Forwarded    string // RFC 7239 Forwarded with this hop's element appended
+	Scheme       string // "http" or "https"
+	Host         string // request host the route was matched against
+	Route        string
+	Service      string
+	SNI          string // TLS server name, empty on plaintext listeners
+	RequestID    string
+}
+
+// variables maps template names to their values.
+var variables = map[string]func(*Vars) string{
+	"client_ip":     func(v *Vars) string { return v.ClientIP },
+	"forwarded_for": func(v *Vars) string { return v.ForwardedFor },
+	"forwarded":     func(v *Vars) string { return v.Forwarded },
+	"scheme":        func(v *Vars) string { return v.Scheme },
+	"host":          func(v *Vars) string { return v.Host },
+	"route":         func(v *Vars) string { return v.Route },
+	"service":       func(v *Vars) string { return v.Service },
+	"sni":           func(v *Vars) string { return v.SNI },
+	"request_id":    func(v *Vars) string { return v.RequestID },
+}
+
+// Policy is a compiled set of header operations. Within a policy, headers are
+// removed first, then set, added and appended if absent, each in name order.
+type Policy struct {
+	remove         []string
+	set            []op
+	add            []op
+	appendIfAbsent []op
+}
+
+type op struct {
+	name  string
+	value template
+}
+
+// Spec is the configured form of a Policy.
+type Spec struct {
+	Set            map[string]string
+	Add            map[string]string
+	Remove         []string
+	AppendIfAbsent map[string]string
+}
+
+// Compile validates header names and value templates.
+func Compile(s Spec) (*Policy, error) {
+	p := &Policy{}
+	for _, name := range s.Remove {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("remove: invalid header name %q", name)
+		}
+		p.remove = append(p.remove, http.CanonicalHeaderKey(name))
+	}
+	var err error
+	if p.set, err = compileOps("set", s.Set); err != nil {
+		return nil, err
+	}
+	if p.add, err = compileOps("add", s.Add); err != nil {
+		return nil, err
+	}
+	if p.appendIfAbsent, err = compileOps("append_if_absent", s.AppendIfAbsent); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func compileOps(kind string, m map[string]string) ([]op, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ops := make([]op, 0, len(m))
+	for _, name := range names {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("%s: invalid header name %q", kind, name)
+		}
+		t, err := parseTemplate(m[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", kind, name, err)
+		}
+		ops = append(ops, op{name: http.CanonicalHeaderKey(name), value: t})
+	}
+	return ops, nil
+}
+
+// Apply mutates h. Operations whose value expands to an empty string are
+// skipped, so e.g. ${sni} on a plaintext listener leaves the header alone.
+func (p *Policy) Apply(h http.Header, v *Vars) {
+	if p == nil {
+		return
+	}
+	for _, name := range p.remove {
+		h.Del(name)
+	}
+	for _, o := range p.set {
+		if val := o.value.expand(v); val != "" {
+			h.Set(o.name, val)
+		}
+	}
+	for _, o := range p.add {
+		if val := o.value.expand(v); val != "" {
+			h.Add(o.name, val)
+		}
+	}
+	for _, o := range p.appendIfAbsent {
+		if _, ok := h[o.name]; ok {
+			continue
+		}
+		if val := o.value.expand(v); val != "" {
+			h.Set(o.name, val)
+		}
+	}
+}
+
+// DefaultRequest is the policy applied to every upstream request before the
+// service and route policies: the de-facto X-Forwarded-* headers.
+var DefaultRequest = mustCompile(Spec{Set: map[string]string{
+	"X-Forwarded-For":   "${forwarded_for}",
+	"X-Forwarded-Proto": "${scheme}",
+	"X-Forwarded-Host":  "${host}",
+}})
+
+// Forwarded adds the standardized RFC 7239 header; it runs right after
+// DefaultRequest when enabled.
+var Forwarded = mustCompile(Spec{Set: map[string]string{"Forwarded": "${forwarded}"}})
+
+func mustCompile(s Spec) *Policy {
+	p, err := Compile(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// template is a header value with ${name} variables; "$$" is a literal '$'.
+type template struct {
+	lits []string // len(lits) == len(vars)+1
+	vars []func(*Vars) string
+}
+
+func parseTemplate(s string) (template, error) {
+	var t template
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '$' {
+			lit.WriteByte(c)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(s[i:], "$$"):
+			lit.WriteByte('$')
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return t, fmt.Errorf("unterminated variable in %q", s)
+			}
+			name := s[i+2 : i+end]
+			fn, ok := variables[name]
+			if !ok {
+				return t, fmt.Errorf("unknown variable ${%s}", name)
+			}
+			t.lits = append(t.lits, lit.String())
+			t.vars = append(t.vars, fn)
+			lit.Reset()
+			i += end
+		default:
+			return t, fmt.Errorf("stray '$' in %q (use $$ for a literal '$')", s)
+		}
+	}
+	t.lits = append(t.lits, lit.String())
+	for _, l := range t.lits {
+		if !httpguts.ValidHeaderFieldValue(l) {
+			return t, fmt.Errorf("invalid characters in %q", s)
+		}
+	}
+	return t, nil
+}
+
+func (t template) expand(v *Vars) string {
+	if len(t.vars) == 0 {
+		return t.lits[0]
+	}
+	var b strings.Builder
+	for i, fn := range t.vars {
+		b.WriteString(t.lits[i])
+		b.WriteString(fn(v))
+	}
+	b.WriteString(t.lits[len(t.lits)-1])
+	return b.String()
+}
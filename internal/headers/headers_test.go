@@ -0,0 +1,93 @@
+package headers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	v := &Vars{ClientIP: "203.0.113.7", Route: "api", SNI: "app.example.com", RequestID: "abc"}
+	cases := []struct {
+		in, want string
+	}{
+		{"static", "static"},
+		{"${client_ip}", "203.0.113.7"},
+		{"route=${route};sni=${sni}", "route=api;sni=app.example.com"},
+		{"cost $$5 ${request_id}", "cost $5 abc"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		tp, err := parseTemplate(c.in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.in, err)
+		}
+		if got := tp.expand(v); got != c.want {
+			t.Errorf("expand %q: got %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"${nope}", "${client_ip", "5$", "a\r\nb"} {
+		if _, err := parseTemplate(bad); err == nil {
+			t.Errorf("parse %q: want error", bad)
+		}
+	}
+}
+
+func TestPolicy_Apply(t *testing.T) {
+	p, err := Compile(Spec{
+		Set:            map[string]string{"x-route": "${route}", "X-Sni": "${sni}"},
+		Add:            map[string]string{"Via": "1.1 gateway"},
+		Remove:         []string{"x-debug", "X-Route"},
+		AppendIfAbsent: map[string]string{"X-Tenant": "default", "X-Env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	h := http.Header{
+		"X-Debug":  {"1"},
+		"X-Route":  {"client-supplied"},
+		"Via":      {"1.0 edge"},
+		"X-Tenant": {"acme"},
+	}
+	p.Apply(h, &Vars{Route: "api"})
+
+	want := http.Header{
+		"X-Route":  {"api"},
+		"Via":      {"1.0 edge", "1.1 gateway"},
+		"X-Tenant": {"acme"},
+		"X-Env":    {"prod"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("headers:\n got %v\nwant %v", h, want)
+	}
+}
+
+func TestDefaultRequest(t *testing.T) {
+	h := http.Header{"X-Forwarded-For": {"198.51.100.1"}}
+	DefaultRequest.Apply(h, &Vars{ForwardedFor: "198.51.100.1, 203.0.113.7", Scheme: "https", Host: "app.example.com"})
+	if got := h.Get("X-Forwarded-For"); got != "198.51.100.1, 203.0.113.7" {
+		t.Errorf("X-Forwarded-For: got %q", got)
+	}
+	if got := h.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("X-Forwarded-Proto: got %q", got)
+	}
+	if got := h.Get("X-Forwarded-Host"); got != "app.example.com" {
+		t.Errorf("X-Forwarded-Host: got %q", got)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, s := range []Spec{
+		{Set: map[string]string{"Bad Name": "x"}},
+		{Remove: []string{"bad:name"}},
+		{Add: map[string]string{"X-A": "${unknown}"}},
+	} {
+		if _, err := Compile(s); err == nil {
+			t.Errorf("Compile(%+v): want error", s)
+		} else if !strings.Contains(err.Error(), ":") {
+			t.Errorf("error lacks context: %v", err)
+		}
+	}
+}
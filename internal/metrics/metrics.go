@@ -40,6 +40,23 @@ func (r *Registry) IncRequest(service, route, method, status string) {
 	r.counters[key]++
 }
 
+// IncCache counts a request on a caching route by how the cache handled it.
+func (r *Registry) IncCache(route, result string) {
+	key := fmt.Sprintf("cache_requests_total|route=\"%s\",result=\"%s\"", route, result)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[key]++
+}
+
+// IncCoalesced counts a request answered with the response of an identical
+// concurrent request.
+func (r *Registry) IncCoalesced(route string) {
+	key := fmt.Sprintf("coalesced_requests_total|route=\"%s\"", route)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[key]++
+}
+
 func (r *Registry) IncActiveConns(listener, service string) {
 	key := fmt.Sprintf("active_connections|listener=\"%s\",service=\"%s\"", listener, service)
 	r.mu.Lock()
@@ -82,6 +99,12 @@ func (r *Registry) ObserveLatency(service, route string, duration time.Duration)
 	}
 }
 
+var counterHelp = map[string]string{
+	"requests_total":           "Total number of requests",
+	"cache_requests_total":     "Requests on caching routes by cache result",
+	"coalesced_requests_total": "Requests served with the response of an identical concurrent request",
+}
+
 func (r *Registry) WritePrometheus(w io.Writer) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -93,15 +116,17 @@ func (r *Registry) WritePrometheus(w io.Writer) {
 	}
 	sort.Strings(keys)
 
-	if len(keys) > 0 {
-		_, _ = fmt.Fprintln(w, "# HELP requests_total Total number of requests")
-		_, _ = fmt.Fprintln(w, "# TYPE requests_total counter")
-		for _, k := range keys {
-			parts := strings.Split(k, "|")
-			if len(parts) == 2 {
-				name, labels := parts[0], parts[1]
-				_, _ = fmt.Fprintf(w, "%s{%s} %d\n", name, labels, r.counters[k])
+	var last string
+	for _, k := range keys {
+		parts := strings.Split(k, "|")
+		if len(parts) == 2 {
+			name, labels := parts[0], parts[1]
+			if name != last {
+				_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, counterHelp[name])
+				_, _ = fmt.Fprintf(w, "# TYPE %s counter\n", name)
+				last = name
 			}
+			_, _ = fmt.Fprintf(w, "%s{%s} %d\n", name, labels, r.counters[k])
 		}
 	}
 
@@ -66,3 +66,26 @@ func TestRegistry_ObserveLatency(t *testing.T) {
 		t.Errorf("count should be 1:\n%s", out)
 	}
 }
+
+func TestRegistry_IncCache(t *testing.T) {
+	r := NewRegistry()
+	r.IncRequest("s1", "r1", "GET", "200")
+	r.IncCache("r1", "hit")
+	r.IncCache("r1", "hit")
+	r.IncCache("r1", "miss")
+
+	var buf bytes.Buffer
+	r.WritePrometheus(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE cache_requests_total counter",
+		`cache_requests_total{route="r1",result="hit"} 2`,
+		`cache_requests_total{route="r1",result="miss"} 1`,
+		"# TYPE requests_total counter",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+}
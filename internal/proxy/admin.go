@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+var (
+	errServiceNotFound = errors.New("service not found")
+	errPeerNotFound    = errors.New("peer not found")
+	errCacheDisabled   = errors.New("response cache is disabled")
+)
+
+// Peers returns the current peers of every service, keyed by service name.
+func (g *Gateway) Peers() map[string][]PeerStatus {
+	g.stateMu.RLock()
+	defer g.stateMu.RUnlock()
+
+	out := make(map[string][]PeerStatus, len(g.state.balancers))
+	for name, lb := range g.state.balancers {
+		if pa, ok := lb.(peerAdmin); ok {
+			out[name] = pa.Peers()
+		}
+	}
+	return out
+}
+
+// UpdatePeerOverride drains, undrains or reweights a single peer, keeping the
+// fields u leaves out. The override is kept across hot reloads until cleared
+// with ClearPeerOverride.
+func (g *Gateway) UpdatePeerOverride(service, peerURL string, u PeerUpdate) error {
+	if u.State != nil {
+		switch *u.State {
+		case PeerActive, PeerDraining:
+		default:
+			return fmt.Errorf("unknown state %q", *u.State)
+		}
+	}
+	if u.Weight != nil && *u.Weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+
+	g.stateMu.Lock()
+	defer g.stateMu.Unlock()
+
+	pa, err := g.peerAdminLocked(service)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, p := range pa.Peers() {
+		if p.URL == peerURL {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errPeerNotFound
+	}
+
+	byURL := g.overrides[service]
+	if byURL == nil {
+		byURL = make(map[string]PeerOverride)
+		g.overrides[service] = byURL
+	}
+	o := byURL[peerURL]
+	if u.State != nil {
+		o.State = *u.State
+		if o.State == PeerActive {
+			o.State = ""
+		}
+	}
+	if u.Weight != nil {
+		o.Weight = *u.Weight
+	}
+	if o == (PeerOverride{}) {
+		delete(byURL, peerURL)
+	} else {
+		byURL[peerURL] = o
+	}
+	pa.SetOverrides(byURL)
+	return nil
+}
+
+// ClearPeerOverride removes the override of a peer, or of all peers of the
+// service if peerURL is empty.
+func (g *Gateway) ClearPeerOverride(service, peerURL string) error {
+	g.stateMu.Lock()
+	defer g.stateMu.Unlock()
+
+	pa, err := g.peerAdminLocked(service)
+	if err != nil {
+		return err
+	}
+	if peerURL == "" {
+		delete(g.overrides, service)
+	} else {
+		delete(g.overrides[service], peerURL)
+	}
+	pa.SetOverrides(g.overrides[service])
+	return nil
+}
+
+func (g *Gateway) peerAdminLocked(service string) (peerAdmin, error) {
+	lb, ok := g.state.balancers[service]
+	if !ok {
+		return nil, errServiceNotFound
+	}
+	pa, ok := lb.(peerAdmin)
+	if !ok {
+		return nil, fmt.Errorf("service %q: balancer does not support overrides", service)
+	}
+	return pa, nil
+}
+
+// NewAdminHandler exposes runtime peer and cache control:
+//
+//	GET    /admin/peers                    list peers of all services
+//	GET    /admin/peers/{service}          list peers of one service
+//	POST   /admin/peers/{service}          {"url": "...", "state": "draining", "weight": 5}; omitted fields are kept
+//	DELETE /admin/peers/{service}?url=...  clear one override (all if url is omitted)
+//	GET    /admin/cache                    response cache usage
+//	DELETE /admin/cache?route=...          purge entries by route, host and path prefix
+//
+// With a non-empty token every request must carry "Authorization: Bearer <token>";
+// without one, only loopback clients are served.
+func NewAdminHandler(g *Gateway, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, g.Peers())
+	})
+	mux.HandleFunc("GET /admin/peers/{service}", func(w http.ResponseWriter, r *http.Request) {
+		peers, ok := g.Peers()[r.PathValue("service")]
+		if !ok {
+			writeAdminError(w, errServiceNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, peers)
+	})
+	mux.HandleFunc("POST /admin/peers/{service}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			URL string `json:"url"`
+			PeerUpdate
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be JSON with a url"})
+			return
+		}
+		if err := g.UpdatePeerOverride(r.PathValue("service"), body.URL, body.PeerUpdate); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /admin/peers/{service}", func(w http.ResponseWriter, r *http.Request) {
+		if err := g.ClearPeerOverride(r.PathValue("service"), r.URL.Query().Get("url")); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/cache", func(w http.ResponseWriter, r *http.Request) {
+		if g.Cache == nil {
+			writeAdminError(w, errCacheDisabled)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.Cache.Stats())
+	})
+	mux.HandleFunc("DELETE /admin/cache", func(w http.ResponseWriter, r *http.Request) {
+		if g.Cache == nil {
+			writeAdminError(w, errCacheDisabled)
+			return
+		}
+		q := r.URL.Query()
+		n := g.Cache.Purge(q.Get("route"), q.Get("host"), q.Get("prefix"))
+		writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+	})
+	if token == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isLoopback(r.RemoteAddr) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin.token is not set: admin API is only served to localhost"})
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid bearer token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether a RemoteAddr is a loopback address.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap().IsLoopback()
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errServiceNotFound) || errors.Is(err, errPeerNotFound) || errors.Is(err, errCacheDisabled) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
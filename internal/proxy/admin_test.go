@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+// localRequest is an admin request from a loopback client.
+func localRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:40000"
+	return req
+}
+
+func TestAdmin_DrainSurvivesReload(t *testing.T) {
+	up1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Peer", "p1")
+	}))
+	defer up1.Close()
+	up2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Peer", "p2")
+	}))
+	defer up2.Close()
+	u1, u2 := mustURL(t, up1.URL), mustURL(t, up2.URL)
+
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: u1}, {URL: u2}}},
+	}
+	routes := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1"}}
+	gw := NewGateway(mustRouter(t, routes), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	admin := NewAdminHandler(gw, "")
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, localRequest(method, target, body))
+		return rr
+	}
+	peersSeen := func(n int) map[string]int {
+		seen := map[string]int{}
+		for i := 0; i < n; i++ {
+			rr := httptest.NewRecorder()
+			gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/", nil))
+			seen[rr.Header().Get("X-Peer")]++
+		}
+		return seen
+	}
+
+	// list
+	rr := do("GET", "/admin/peers", "")
+	var all map[string][]PeerStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &all); err != nil {
+		t.Fatalf("decode peers: %v", err)
+	}
+	if len(all["s1"]) != 2 || all["s1"][0].State != PeerActive {
+		t.Fatalf("unexpected peers: %+v", all)
+	}
+
+	// drain p1
+	if rr := do("POST", "/admin/peers/s1", `{"url": "`+u1.String()+`", "state": "draining"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("drain: status %d: %s", rr.Code, rr.Body.String())
+	}
+	if seen := peersSeen(4); seen["p1"] != 0 {
+		t.Fatalf("drained peer still selected: %v", seen)
+	}
+
+	// hot reload keeps the override
+	gw.UpdateState(mustRouter(t, routes), svcs, 0, config.AccessLogConfig{Sampling: 1.0})
+	if seen := peersSeen(4); seen["p1"] != 0 {
+		t.Fatalf("override lost on reload: %v", seen)
+	}
+
+	// a weight-only update keeps the drain
+	if rr := do("POST", "/admin/peers/s1", `{"url": "`+u1.String()+`", "weight": 3}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("reweight: status %d: %s", rr.Code, rr.Body.String())
+	}
+	if st := gw.Peers()["s1"][0]; st.State != PeerDraining || st.Weight != 3 {
+		t.Fatalf("partial update replaced the override: %+v", st)
+	}
+	if seen := peersSeen(4); seen["p1"] != 0 {
+		t.Fatalf("reweighted drained peer selected: %v", seen)
+	}
+
+	// clearing restores rotation
+	if rr := do("DELETE", "/admin/peers/s1?url="+u1.String(), ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("clear: status %d", rr.Code)
+	}
+	if seen := peersSeen(4); seen["p1"] != 2 || seen["p2"] != 2 {
+		t.Fatalf("after clear: %v", seen)
+	}
+
+	// errors
+	if rr := do("POST", "/admin/peers/nope", `{"url": "http://x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown service: status %d", rr.Code)
+	}
+	if rr := do("POST", "/admin/peers/s1", `{"url": "http://x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown peer: status %d", rr.Code)
+	}
+	for _, state := range []string{"sleeping", "disabled"} {
+		if rr := do("POST", "/admin/peers/s1", `{"url": "`+u1.String()+`", "state": "`+state+`"}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("state %s: status %d", state, rr.Code)
+		}
+	}
+}
+
+func TestAdmin_NoTokenLocalOnly(t *testing.T) {
+	gw := NewGateway(mustRouter(t, nil), map[string]config.Service{}, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	admin := NewAdminHandler(gw, "")
+	for addr, want := range map[string]int{
+		"127.0.0.1:40000":    http.StatusOK,
+		"[::1]:40000":        http.StatusOK,
+		"10.0.0.7:40000":     http.StatusForbidden,
+		"[2001:db8::1]:4000": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/admin/peers", nil)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: status %d, want %d", addr, rr.Code, want)
+		}
+	}
+}
+
+func TestAdmin_BearerToken(t *testing.T) {
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, "http://10.0.0.1:80")}}},
+	}
+	gw := NewGateway(mustRouter(t, nil), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	admin := NewAdminHandler(gw, "s3cret")
+
+	for name, auth := range map[string]string{"missing": "", "wrong": "Bearer nope", "scheme": "Basic s3cret"} {
+		req := httptest.NewRequest("POST", "/admin/peers/s1", strings.NewReader(`{"url": "http://10.0.0.1:80", "state": "draining"}`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: status %d, want 401 with challenge", name, rr.Code)
+		}
+	}
+	if st := gw.Peers()["s1"][0].State; st != PeerActive {
+		t.Fatalf("rejected request changed peer state to %s", st)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/peers", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid token: status %d", rr.Code)
+	}
+}
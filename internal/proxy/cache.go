@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/cache"
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/headers"
+)
+
+// Cache results, as logged in the access log and counted in
+// cache_requests_total.
+const (
+	cacheHit         = "hit"         // served from the cache
+	cacheStale       = "stale"       // served stale: stale-while-revalidate or stale-if-error
+	cacheRevalidated = "revalidated" // the upstream confirmed the stored response with a 304
+	cacheMiss        = "miss"        // fetched from the upstream
+	cacheBypass      = "bypass"      // method the cache does not serve
+)
+
+// cacheStatusName identifies the gateway in Cache-Status (RFC 9211).
+const cacheStatusName = "gateway-homebrew-go"
+
+// cachedRequest is the cache state of a request on a caching route.
+type cachedRequest struct {
+	key        string
+	stored     *cache.Response // the variant matching the request, if any
+	hit        bool            // stored may be served without asking the upstream
+	refresh    bool            // and revalidated in the background afterwards
+	validating bool            // the upstream request carries stored's validators
+	invalidate bool            // unsafe method: a successful response drops the entry
+}
+
+// lookupCache consults the cache for r and records the outcome on lw. It
+// returns nil if the route does not cache.
+func (g *Gateway) lookupCache(lw *loggingResponseWriter, r *http.Request, route *config.Route) *cachedRequest {
+	if g.Cache == nil || route.Cache == nil {
+		return nil
+	}
+	cr := &cachedRequest{key: cache.Key(route.Name, r, subsetHeaders(route.Subset)...)}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions, http.MethodTrace:
+		lw.setCache(cacheBypass, "fwd=method")
+		return cr
+	default:
+		cr.invalidate = true
+		lw.setCache(cacheBypass, "fwd=method")
+		return cr
+	}
+
+	stored, vary := g.Cache.Lookup(cr.key, r)
+	cr.stored = stored
+	now := time.Now()
+	switch {
+	case stored == nil && vary:
+		lw.setCache(cacheMiss, "fwd=vary-miss")
+	case stored == nil:
+		lw.setCache(cacheMiss, "fwd=uri-miss")
+	default:
+		ttl := "; ttl=" + strconv.FormatInt(int64((stored.Lifetime()-stored.Age(now))/time.Second), 10)
+		switch stored.Evaluate(r, now) {
+		case cache.Fresh:
+			cr.hit = true
+			lw.setCache(cacheHit, "hit"+ttl)
+		case cache.StaleRefresh:
+			cr.hit, cr.refresh = true, true
+			lw.setCache(cacheStale, "hit"+ttl)
+		default:
+			lw.setCache(cacheMiss, "fwd=stale")
+		}
+	}
+	return cr
+}
+
+// addValidators replaces the client's preconditions with the validators of
+// the stored response, so that a 304 from the upstream refreshes it.
+func (cr *cachedRequest) addValidators(h http.Header) {
+	if cr == nil || cr.stored == nil {
+		return
+	}
+	etag, lastModified := cr.stored.Header.Get("Etag"), cr.stored.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	h.Del("If-None-Match")
+	h.Del("If-Modified-Since")
+	if etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		h.Set("If-Modified-Since", lastModified)
+	}
+	cr.validating = true
+}
+
+// serveStale answers r with the stored response after the upstream failed,
+// if stale-if-error allows it.
+func (cr *cachedRequest) serveStale(lw *loggingResponseWriter, r *http.Request, route *config.Route) bool {
+	if cr == nil || cr.stored == nil || !cr.stored.StaleOnError(r, time.Now()) {
+		return false
+	}
+	lw.setCache(cacheStale, lw.cacheStatus+"; detail=stale-if-error")
+	serveStored(lw, r, route, cr.stored)
+	return true
+}
+
+// serveStored answers r from a stored response, or with 304 Not Modified if
+// the client's preconditions match it.
+func serveStored(lw *loggingResponseWriter, r *http.Request, route *config.Route, s *cache.Response) {
+	h := s.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(s.Age(time.Now())/time.Second), 10))
+	if s.Status == http.StatusOK && notModified(r, s.Header) {
+		h.Del("Content-Length")
+		copyHeaders(lw.Header(), h)
+		lw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body := s.Body
+	if r.Method == http.MethodHead {
+		body = nil
+	}
+	if s.Status != http.StatusNoContent {
+		h.Set("Content-Length", strconv.Itoa(len(s.Body)))
+	}
+	res := &http.Response{
+		StatusCode:    s.Status,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(s.Body)),
+	}
+	if err := relayResponse(lw, r, res, route); err != nil {
+		lw.clientGone = true // reading from memory does not fail
+	}
+}
+
+// notModified evaluates If-None-Match, or else If-Modified-Since, against a
+// stored response (RFC 9110, 13.2.2).
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		etag := h.Get("Etag")
+		if etag == "" {
+			return false
+		}
+		for _, v := range inm {
+			for _, tag := range strings.Split(v, ",") {
+				if tag = strings.TrimSpace(tag); tag == "*" || weakMatch(tag, etag) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// weakMatch is the weak entity-tag comparison.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// refreshInBackground revalidates the stale entry of cr after it was served
+// to r. The conditional request goes straight to a peer of the route's
+// service: it takes no rate-limit tokens, joins no coalesced call and is not
+// logged, counted or traced as a client request. At most one revalidation per
+// entry runs at a time.
+func (g *Gateway) refreshInBackground(state *GatewayState, route *config.Route, cr *cachedRequest, r *http.Request, client clientAddr, vars *headers.Vars) {
+	if !g.Cache.BeginRefresh(cr.key) {
+		return
+	}
+	rr := r.Clone(context.Background())
+	rr.Method, rr.Body, rr.ContentLength = http.MethodGet, http.NoBody, 0
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+		rr.Header.Del(name)
+	}
+	go func() {
+		defer g.Cache.EndRefresh(cr.key)
+		if err := g.revalidate(state, route, cr, rr, client, vars); err != nil {
+			log.Printf("cache refresh %s: %v", route.Name, err)
+		}
+	}()
+}
+
+// revalidate sends the conditional request for cr's stored response and
+// refreshes or replaces the entry with the upstream's answer.
+func (g *Gateway) revalidate(state *GatewayState, route *config.Route, cr *cachedRequest, r *http.Request, client clientAddr, vars *headers.Vars) error {
+	svc, ok := state.Services[route.Service]
+	lb := state.balancers[route.Service]
+	if !ok || lb == nil {
+		return errors.New("no service")
+	}
+	if route.Subset != nil {
+		if lb = subsetBalancer(lb, route.Subset, r); lb == nil {
+			return errors.New("no peers in subset")
+		}
+	}
+	ep := lb.Next()
+	if ep == nil {
+		return errors.New("no peers")
+	}
+	success := false
+	var rtt time.Duration
+	defer func() { ep.Feedback(success, rtt) }()
+	base := ep.URL()
+
+	hdr := upstreamHeader(r, state, svc, route, client, vars)
+	hdr.Del("Expect")
+	cr.addValidators(hdr)
+	ctx := r.Context()
+	timeout := state.UpstreamTimeout
+	if route.Timeout > 0 {
+		timeout = route.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	reqUp, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL(base, r).String(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	reqUp.Header = hdr
+	reqUp.Host = upstreamHost(r, route, base)
+
+	rtStart := time.Now()
+	resUp, err := g.Transports.Get(svc.Name).RoundTrip(reqUp)
+	rtt = time.Since(rtStart)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resUp.Body.Close() }()
+	success = resUp.StatusCode < 500
+	dropHopByHop(resUp.Header)
+
+	if resUp.StatusCode == http.StatusNotModified && cr.validating {
+		g.Cache.Refreshed(cr.key, cr.stored, resUp.Header, rtStart, rtStart.Add(rtt))
+		return nil
+	}
+	if !cache.Storable(r, resUp.StatusCode, resUp.Header) || resUp.ContentLength > route.Cache.MaxObjectSize {
+		_, err = io.Copy(io.Discard, resUp.Body)
+		return err
+	}
+	body, err := io.ReadAll(io.LimitReader(resUp.Body, route.Cache.MaxObjectSize+1))
+	if err != nil {
+		success = false
+		return err
+	}
+	if int64(len(body)) <= route.Cache.MaxObjectSize {
+		g.Cache.Store(cr.key, route.Name, r, resUp.StatusCode, resUp.Header, body, rtStart, rtStart.Add(rtt))
+	}
+	return nil
+}
+
+// bodyCapture keeps a copy of a response body, up to limit bytes, for the
+// cache or for coalesced requests.
+type bodyCapture struct {
+	buf   []byte
+	limit int64
+	over  bool
+}
+
+func (c *bodyCapture) Write(b []byte) (int, error) {
+	if !c.over {
+		if int64(len(c.buf)+len(b)) > c.limit {
+			c.over, c.buf = true, nil
+		} else {
+			c.buf = append(c.buf, b...)
+		}
+	}
+	return len(b), nil
+}
+
+// captureBody tees res's body into a bodyCapture if the response may be
+// stored; it returns nil otherwise.
+func (cr *cachedRequest) captureBody(r *http.Request, res *http.Response, route *config.Route) *bodyCapture {
+	if cr == nil || !cache.Storable(r, res.StatusCode, res.Header) || res.ContentLength > route.Cache.MaxObjectSize {
+		return nil
+	}
+	return teeBody(res, route.Cache.MaxObjectSize)
+}
+
+// teeBody copies res's body into a bodyCapture of the given limit as it is
+// read.
+func teeBody(res *http.Response, limit int64) *bodyCapture {
+	c := &bodyCapture{limit: limit}
+	if res.ContentLength > 0 {
+		c.buf = make([]byte, 0, res.ContentLength)
+	}
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(res.Body, c), res.Body}
+	return c
+}
@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/cache"
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func newCacheGateway(t *testing.T, handler http.HandlerFunc) (*Gateway, logChan, *metrics.Registry) {
+	t.Helper()
+	up := httptest.NewServer(handler)
+	t.Cleanup(up.Close)
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Cache: &config.RouteCache{MaxObjectSize: 64}}}
+	logs := make(logChan, 16)
+	m := metrics.NewRegistry()
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, logs, config.AccessLogConfig{Sampling: 1.0}, m)
+	gw.Cache = cache.New(cache.NewMemory(1 << 20))
+	return gw, logs, m
+}
+
+// serveCached sends one request through gw and returns the response and its
+// access log entry.
+func serveCached(t *testing.T, gw *Gateway, logs logChan, req *http.Request) (*httptest.ResponseRecorder, AccessLog) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	return rr, logs.next(t)
+}
+
+func TestGateway_CacheHit(t *testing.T) {
+	var calls atomic.Int32
+	gw, logs, m := newCacheGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Etag", `"v1"`)
+		_, _ = fmt.Fprint(w, "hello")
+	})
+	get := func() *http.Request { return httptest.NewRequest("GET", "http://gw.local/a?x=1", nil) }
+
+	rr, entry := serveCached(t, gw, logs, get())
+	if rr.Body.String() != "hello" || entry.Cache != cacheMiss {
+		t.Fatalf("first: body %q cache %q", rr.Body.String(), entry.Cache)
+	}
+	if got := rr.Header().Get("Cache-Status"); got != "gateway-homebrew-go; fwd=uri-miss; fwd-status=200" {
+		t.Errorf("first Cache-Status: got %q", got)
+	}
+
+	rr, entry = serveCached(t, gw, logs, get())
+	if rr.Body.String() != "hello" || entry.Cache != cacheHit || entry.Upstream != "" {
+		t.Errorf("second: body %q cache %q upstream %q", rr.Body.String(), entry.Cache, entry.Upstream)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Cache-Status"), "gateway-homebrew-go; hit; ttl=") {
+		t.Errorf("second Cache-Status: got %q", rr.Header().Get("Cache-Status"))
+	}
+	if rr.Header().Get("Age") == "" || rr.Header().Get("Content-Length") != "5" {
+		t.Errorf("second headers: %v", rr.Header())
+	}
+
+	rr, entry = serveCached(t, gw, logs, httptest.NewRequest("HEAD", "http://gw.local/a?x=1", nil))
+	if rr.Body.Len() != 0 || entry.Cache != cacheHit {
+		t.Errorf("head: body %q cache %q", rr.Body.String(), entry.Cache)
+	}
+
+	req := get()
+	req.Header.Set("If-None-Match", `W/"v1"`)
+	rr, entry = serveCached(t, gw, logs, req)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 || entry.Cache != cacheHit {
+		t.Errorf("conditional: status %d body %q cache %q", rr.Code, rr.Body.String(), entry.Cache)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("upstream calls: got %d, want 1", calls.Load())
+	}
+
+	// a successful unsafe request invalidates the entry
+	_, entry = serveCached(t, gw, logs, httptest.NewRequest("POST", "http://gw.local/a?x=1", nil))
+	if entry.Cache != cacheBypass {
+		t.Errorf("post: cache %q", entry.Cache)
+	}
+	if _, entry = serveCached(t, gw, logs, get()); entry.Cache != cacheMiss {
+		t.Errorf("after post: cache %q, want miss", entry.Cache)
+	}
+
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `cache_requests_total{route="r1",result="hit"} 3`) {
+		t.Errorf("metrics:\n%s", buf.String())
+	}
+}
+
+func TestGateway_CacheRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	gw, logs, _ := newCacheGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Etag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = fmt.Fprint(w, "hello")
+	})
+	serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/a", nil))
+
+	// the client's own validator does not reach the upstream
+	req := httptest.NewRequest("GET", "http://gw.local/a", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	rr, entry := serveCached(t, gw, logs, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" || entry.Cache != cacheRevalidated {
+		t.Errorf("revalidated: status %d body %q cache %q", rr.Code, rr.Body.String(), entry.Cache)
+	}
+	if got := rr.Header().Get("Cache-Status"); got != "gateway-homebrew-go; fwd=stale; fwd-status=304" {
+		t.Errorf("Cache-Status: got %q", got)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("upstream calls: got %d, want 2", calls.Load())
+	}
+}
+
+func TestGateway_CacheStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		_, _ = fmt.Fprintf(w, "v%d", version.Load())
+	}))
+	defer up.Close()
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	// three tokens: the background refresh must not take the third
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Cache: &config.RouteCache{MaxObjectSize: 64},
+		RateLimit: &config.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 3}}}
+	logs := make(logChan, 16)
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, logs, config.AccessLogConfig{Sampling: 1.0}, metrics.NewRegistry())
+	gw.Cache = cache.New(cache.NewMemory(1 << 20))
+
+	get := func() *http.Request { return httptest.NewRequest("GET", "http://gw.local/a", nil) }
+	serveCached(t, gw, logs, get())
+	version.Store(2)
+
+	rr, entry := serveCached(t, gw, logs, get())
+	if rr.Body.String() != "v1" || entry.Cache != cacheStale {
+		t.Errorf("stale: body %q cache %q", rr.Body.String(), entry.Cache)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s, _ := gw.Cache.Lookup(cache.Key("r1", get()), get()); s != nil && string(s.Body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry not refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case b := <-logs:
+		t.Errorf("background refresh was logged as a request: %s", b)
+	default:
+	}
+	if rr, _ = serveCached(t, gw, logs, get()); rr.Code != http.StatusOK || rr.Body.String() != "v2" {
+		t.Errorf("after refresh: status %d body %q, want v2", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGateway_CacheSubsetHeaders(t *testing.T) {
+	newUp := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = fmt.Fprint(w, name)
+		}))
+	}
+	v1, v2 := newUp("v1"), newUp("v2")
+	defer v1.Close()
+	defer v2.Close()
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{
+			{URL: mustURL(t, v1.URL), Labels: map[string]string{"version": "v1"}},
+			{URL: mustURL(t, v2.URL), Labels: map[string]string{"version": "v2"}},
+		}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Cache: &config.RouteCache{MaxObjectSize: 64},
+		Subset: &config.Subset{Headers: map[string]string{"X-Version": "version"}, Fallback: "none"}}}
+	logs := make(logChan, 16)
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, logs, config.AccessLogConfig{Sampling: 1.0}, nil)
+	gw.Cache = cache.New(cache.NewMemory(1 << 20))
+
+	get := func(version string) *http.Request {
+		req := httptest.NewRequest("GET", "http://gw.local/a", nil)
+		req.Header.Set("X-Version", version)
+		return req
+	}
+	serveCached(t, gw, logs, get("v1"))
+	// the other subset must not be answered from v1's entry
+	if rr, entry := serveCached(t, gw, logs, get("v2")); rr.Body.String() != "v2" || entry.Cache != cacheMiss {
+		t.Errorf("v2: body %q cache %q", rr.Body.String(), entry.Cache)
+	}
+	for _, v := range []string{"v1", "v2"} {
+		if rr, entry := serveCached(t, gw, logs, get(v)); rr.Body.String() != v || entry.Cache != cacheHit {
+			t.Errorf("%s again: body %q cache %q", v, rr.Body.String(), entry.Cache)
+		}
+	}
+}
+
+func TestGateway_CacheStaleIfError(t *testing.T) {
+	var failing atomic.Bool
+	gw, logs, _ := newCacheGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		w.Header().Set("Etag", `"v1"`)
+		_, _ = fmt.Fprint(w, "hello")
+	})
+	serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/a", nil))
+	failing.Store(true)
+
+	rr, entry := serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/a", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" || entry.Cache != cacheStale {
+		t.Errorf("stale-if-error: status %d body %q cache %q", rr.Code, rr.Body.String(), entry.Cache)
+	}
+	if got := rr.Header().Get("Cache-Status"); got != "gateway-homebrew-go; fwd=stale; fwd-status=502; detail=stale-if-error" {
+		t.Errorf("Cache-Status: got %q", got)
+	}
+
+	// without a stored response the error goes through
+	rr, _ = serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/b", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("uncached: status %d, want 502", rr.Code)
+	}
+}
+
+func TestGateway_CacheVaryAndLimits(t *testing.T) {
+	gw, logs, _ := newCacheGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		if r.URL.Path == "/big" {
+			_, _ = fmt.Fprint(w, strings.Repeat("x", 65)) // above max_object_size
+			return
+		}
+		_, _ = fmt.Fprint(w, r.Header.Get("Accept-Language"))
+	})
+	get := func(path, lang string) *http.Request {
+		req := httptest.NewRequest("GET", "http://gw.local"+path, nil)
+		req.Header.Set("Accept-Language", lang)
+		return req
+	}
+	serveCached(t, gw, logs, get("/a", "en"))
+	if rr, entry := serveCached(t, gw, logs, get("/a", "en")); rr.Body.String() != "en" || entry.Cache != cacheHit {
+		t.Errorf("same variant: body %q cache %q", rr.Body.String(), entry.Cache)
+	}
+	rr, entry := serveCached(t, gw, logs, get("/a", "fr"))
+	if rr.Body.String() != "fr" || entry.Cache != cacheMiss || !strings.Contains(rr.Header().Get("Cache-Status"), "fwd=vary-miss") {
+		t.Errorf("other variant: body %q cache %q status %q", rr.Body.String(), entry.Cache, rr.Header().Get("Cache-Status"))
+	}
+
+	serveCached(t, gw, logs, get("/big", "en"))
+	if _, entry := serveCached(t, gw, logs, get("/big", "en")); entry.Cache != cacheMiss {
+		t.Errorf("oversized: cache %q, want miss", entry.Cache)
+	}
+}
+
+func TestAdmin_CachePurge(t *testing.T) {
+	gw, logs, _ := newCacheGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprint(w, r.URL.Path)
+	})
+	for _, p := range []string{"/a/1", "/a/2", "/b"} {
+		serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local"+p, nil))
+	}
+	admin := NewAdminHandler(gw, "")
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, localRequest("GET", "/admin/cache", ""))
+	var stats cache.Stats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil || stats.Entries != 3 || stats.Backend != "memory" {
+		t.Fatalf("stats: %s (%v)", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, localRequest("DELETE", "/admin/cache?route=r1&host=GW.local&prefix=/a/", ""))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"purged":2}` {
+		t.Errorf("purge: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, entry := serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/a/1", nil)); entry.Cache != cacheMiss {
+		t.Errorf("purged entry: cache %q, want miss", entry.Cache)
+	}
+	if _, entry := serveCached(t, gw, logs, httptest.NewRequest("GET", "http://gw.local/b", nil)); entry.Cache != cacheHit {
+		t.Errorf("kept entry: cache %q, want hit", entry.Cache)
+	}
+
+	gw.Cache = nil
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, localRequest("GET", "/admin/cache", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("disabled: status %d, want 404", rr.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientAddr is the resolved origin of a request.
+type clientAddr struct {
+	IP           string // client IP: the peer, or the first untrusted forwarding hop
+	Peer         string // address of the connection's remote end
+	ForwardedFor string // X-Forwarded-For to send upstream
+	Forwarded    string // Forwarded elements received from trusted peers
+	Untrusted    bool   // forwarding headers came from a peer that is not trusted
+}
+
+// resolveClient determines the client IP of r. When the peer is a trusted
+// proxy, the hops it reports (Forwarded "for=" if present, else
+// X-Forwarded-For) are walked right to left; the first hop that is not itself
+// a trusted proxy is the client. Headers from untrusted peers are discarded.
+// Without trusted proxies every header is kept and the peer is the client.
+func resolveClient(r *http.Request, trusted []netip.Prefix) clientAddr {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	c := clientAddr{IP: peer, Peer: peer, ForwardedFor: peer}
+	xff := r.Header.Values("X-Forwarded-For")
+	fwd := r.Header.Values("Forwarded")
+
+	if len(trusted) > 0 && !isTrusted(peer, trusted) {
+		c.Untrusted = true // a forged chain must not reach the upstream either
+		return c
+	}
+	if len(xff) > 0 {
+		c.ForwardedFor = strings.Join(append(xff, peer), ", ")
+	}
+	c.Forwarded = strings.Join(fwd, ", ")
+	if len(trusted) == 0 {
+		return c
+	}
+
+	var hops []string
+	if len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	} else {
+		for _, v := range xff {
+			for _, h := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(h))
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseHop(hops[i])
+		if !ok {
+			break // "unknown" or an obfuscated identifier: keep the last known hop
+		}
+		c.IP = ip.String()
+		if !isTrusted(c.IP, trusted) {
+			break
+		}
+	}
+	return c
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHop parses an X-Forwarded-For entry or Forwarded "for=" value:
+// "192.0.2.1", "192.0.2.1:4711", "[2001:db8::1]:4711" or "2001:db8::1".
+func parseHop(s string) (netip.Addr, bool) {
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a.Unmap(), true
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		if a, err := netip.ParseAddr(s[1 : len(s)-1]); err == nil {
+			return a.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// forwardedFor extracts the "for" parameter of each Forwarded element.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			out = append(out, hop)
+		}
+	}
+	return out
+}
+
+// forwardedElement renders this hop's RFC 7239 Forwarded element.
+func forwardedElement(peer, host, proto string) string {
+	forNode := peer
+	if strings.Contains(peer, ":") {
+		forNode = "[" + peer + "]"
+	}
+	if peer == "" {
+		forNode = "unknown"
+	}
+	return "for=" + quoteForwarded(forNode) + ";host=" + quoteForwarded(host) + ";proto=" + proto
+}
+
+// quoteForwarded quotes a Forwarded value unless it is a token.
+func quoteForwarded(s string) string {
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return s
+}
+
+func isTokenChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func TestResolveClient(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8:ffff::/48")}
+	cases := []struct {
+		name      string
+		trusted   []netip.Prefix
+		peer      string
+		xff       string
+		fwd       string
+		want      string
+		untrusted bool
+	}{
+		{name: "no_trusted_proxies", peer: "203.0.113.10:1234", xff: "198.51.100.1", want: "203.0.113.10"},
+		{name: "untrusted_peer", trusted: trusted, peer: "203.0.113.10:1234", xff: "198.51.100.1", want: "203.0.113.10", untrusted: true},
+		{name: "xff_right_to_left", trusted: trusted, peer: "10.0.0.1:1234", xff: "198.51.100.1, 192.0.2.7, 10.0.0.2", want: "192.0.2.7"},
+		{name: "all_hops_trusted", trusted: trusted, peer: "10.0.0.1:1234", xff: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		{name: "no_header", trusted: trusted, peer: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "xff_with_port", trusted: trusted, peer: "10.0.0.1:1234", xff: "192.0.2.7:4711", want: "192.0.2.7"},
+		{name: "forwarded_preferred", trusted: trusted, peer: "10.0.0.1:1234", xff: "192.0.2.99", fwd: `for=192.0.2.60;proto=http, for="[2001:db8:ffff::5]:80"`, want: "192.0.2.60"},
+		{name: "forwarded_ipv6", trusted: trusted, peer: "10.0.0.1:1234", fwd: `for="[2001:db8::1]:4711"`, want: "2001:db8::1"},
+		{name: "unknown_hop_stops", trusted: trusted, peer: "10.0.0.1:1234", fwd: "for=192.0.2.60, for=unknown, for=10.0.0.2", want: "10.0.0.2"},
+		{name: "ipv6_peer", trusted: trusted, peer: "[2001:db8:ffff::1]:443", xff: "192.0.2.7", want: "192.0.2.7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.peer
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if tc.fwd != "" {
+				r.Header.Set("Forwarded", tc.fwd)
+			}
+			c := resolveClient(r, tc.trusted)
+			if c.IP != tc.want || c.Untrusted != tc.untrusted {
+				t.Errorf("got IP %q untrusted=%v, want %q untrusted=%v", c.IP, c.Untrusted, tc.want, tc.untrusted)
+			}
+		})
+	}
+}
+
+func TestForwardedElement(t *testing.T) {
+	cases := map[string]string{
+		forwardedElement("192.0.2.1", "app.example.com", "http"): "for=192.0.2.1;host=app.example.com;proto=http",
+		forwardedElement("2001:db8::1", "app:8443", "https"):     `for="[2001:db8::1]";host="app:8443";proto=https`,
+		forwardedElement("", "app.example.com", "http"):          "for=unknown;host=app.example.com;proto=http",
+	}
+	for got, want := range cases {
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestGateway_TrustedProxies(t *testing.T) {
+	var seen http.Header
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+	defer up.Close()
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{
+		Name: "r1", PathPrefix: "/", Service: "s1",
+		RateLimit: &config.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1, Key: "ip"},
+	}}
+	var logs bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, &logs, config.AccessLogConfig{Sampling: 1.0}, nil)
+	gw.UpdateHTTP(config.HTTPConfig{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, Forwarded: true})
+
+	do := func(peer, xff, fwd string) int {
+		req := httptest.NewRequest("GET", "http://app.local/x", nil)
+		req.RemoteAddr = peer
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		if fwd != "" {
+			req.Header.Set("Forwarded", fwd)
+		}
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	lastLog := func() AccessLog {
+		t.Helper()
+		lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+		var e AccessLog
+		if err := json.Unmarshal(lines[len(lines)-1], &e); err != nil {
+			t.Fatalf("unmarshal log: %v", err)
+		}
+		return e
+	}
+
+	// via a trusted load balancer: the client is the hop it reports
+	if code := do("10.0.0.1:1234", "192.0.2.7", ""); code != 200 {
+		t.Fatalf("status: got %d, want 200", code)
+	}
+	if e := lastLog(); e.RemoteIP != "192.0.2.7" {
+		t.Errorf("log remote_ip: got %q, want 192.0.2.7", e.RemoteIP)
+	}
+	if got, want := seen.Get("X-Forwarded-For"), "192.0.2.7, 10.0.0.1"; got != want {
+		t.Errorf("upstream X-Forwarded-For: got %q, want %q", got, want)
+	}
+	if got, want := seen.Get("Forwarded"), "for=10.0.0.1;host=app.local;proto=http"; got != want {
+		t.Errorf("upstream Forwarded: got %q, want %q", got, want)
+	}
+
+	// the rate limit bucket belongs to the resolved client, not the proxy
+	if code := do("10.0.0.1:1234", "192.0.2.7", ""); code != http.StatusTooManyRequests {
+		t.Errorf("same client: got %d, want 429", code)
+	}
+	if code := do("10.0.0.1:1234", "192.0.2.8", ""); code != 200 {
+		t.Errorf("other client behind the same proxy: got %d, want 200", code)
+	}
+
+	// a direct client cannot forge its origin
+	if code := do("203.0.113.10:5555", "192.0.2.8", "for=192.0.2.8"); code != 200 {
+		t.Fatalf("status: got %d, want 200", code)
+	}
+	if e := lastLog(); e.RemoteIP != "203.0.113.10" {
+		t.Errorf("log remote_ip: got %q, want 203.0.113.10", e.RemoteIP)
+	}
+	if got := seen.Get("X-Forwarded-For"); got != "203.0.113.10" {
+		t.Errorf("upstream X-Forwarded-For: got %q, want forged hops dropped", got)
+	}
+	if got, want := seen.Values("Forwarded"), "for=203.0.113.10;host=app.local;proto=http"; len(got) != 1 || got[0] != want {
+		t.Errorf("upstream Forwarded: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/cache"
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+// coalescer runs one upstream call per key at a time for routes with
+// options.coalesce; identical requests arriving meanwhile wait for it.
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is an upstream call other requests are waiting for.
+type flight struct {
+	done    chan struct{}
+	res     *sharedResponse // set by the leader before done is closed; nil if not shareable
+	waiters int             // guarded by coalescer.mu
+}
+
+// sharedResponse is a complete upstream response handed to waiters.
+type sharedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: make(map[string]*flight)}
+}
+
+// join returns the flight of key and whether the caller leads it. A leader
+// must call finish when its response is complete.
+func (c *coalescer) join(key string) (f *flight, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		f.waiters++
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+// finish publishes the leader's response, if any, and releases the waiters.
+func (c *coalescer) finish(key string, f *flight) {
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// wait blocks until the leader finishes, r's client goes away or timeout
+// passes. It returns nil unless there is a response to share.
+func (f *flight) wait(r *http.Request, timeout time.Duration) *sharedResponse {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-f.done:
+		return f.res
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+	return nil
+}
+
+// uniqueRequestHeaders make the response fit only the request that sent
+// them: conditional and range requests are never coalesced.
+var uniqueRequestHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Range", "If-Range"}
+
+// coalesceKey identifies identical requests on a route, or returns "" if r
+// must not share a response: only unconditional, full GET and HEAD requests
+// are coalesced, and credentials must be part of the key. Headers that select
+// a subset always are, since they pick the upstream.
+func coalesceKey(r *http.Request, route *config.Route) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	for _, name := range uniqueRequestHeaders {
+		if r.Header.Get(name) != "" {
+			return ""
+		}
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if r.Header.Get(name) != "" && !slices.Contains(route.Coalesce.Headers, name) {
+			return ""
+		}
+	}
+	var b strings.Builder
+	b.WriteString(route.Name + "\x00" + r.Method + "\x00" + strings.ToLower(r.Host) + "\x00" + r.URL.RequestURI())
+	for _, name := range route.Coalesce.Headers {
+		b.WriteString("\x00" + strings.Join(r.Header.Values(name), ","))
+	}
+	for _, name := range subsetHeaders(route.Subset) {
+		b.WriteString("\x00" + r.Header.Get(name))
+	}
+	return b.String()
+}
+
+// shareable reports whether an upstream response may be handed to other
+// clients: a complete response with a default-cacheable status, no cookies,
+// no per-user or no-store directives, no trailers, and varying only on
+// headers that are part of the key.
+func shareable(res *http.Response, route *config.Route) bool {
+	cc := cache.ParseCacheControl(res.Header)
+	if !cache.CacheableStatus(res.StatusCode) || res.ContentLength > route.Coalesce.MaxBodySize ||
+		res.Header.Get("Set-Cookie") != "" || cc.Has("private") || cc.Has("no-store") ||
+		len(res.Trailer) > 0 || len(res.Header.Values("Trailer")) > 0 {
+		return false
+	}
+	for _, name := range cache.VaryNames(res.Header) {
+		if !slices.Contains(route.Coalesce.Headers, name) && !slices.Contains(subsetHeaders(route.Subset), name) {
+			return false
+		}
+	}
+	return true
+}
+
+// serveShared answers a waiter with the leader's response.
+func serveShared(lw *loggingResponseWriter, r *http.Request, route *config.Route, s *sharedResponse) {
+	h := s.header.Clone()
+	if r.Method != http.MethodHead && s.status != http.StatusNoContent && s.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(len(s.body)))
+	}
+	res := &http.Response{
+		StatusCode:    s.status,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(s.body)),
+		ContentLength: int64(len(s.body)),
+	}
+	if err := relayResponse(lw, r, res, route); err != nil {
+		lw.clientGone = true
+	}
+}
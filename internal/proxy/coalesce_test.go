@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func newCoalesceGateway(t *testing.T, c *config.Coalesce, handler http.HandlerFunc) (*Gateway, *metrics.Registry) {
+	t.Helper()
+	up := httptest.NewServer(handler)
+	t.Cleanup(up.Close)
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Coalesce: c}}
+	m := metrics.NewRegistry()
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, m)
+	return gw, m
+}
+
+// waitForWaiters blocks until n requests wait on the flight of key.
+func waitForWaiters(t *testing.T, gw *Gateway, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		gw.coalescer.mu.Lock()
+		f := gw.coalescer.flights[key]
+		got := 0
+		if f != nil {
+			got = f.waiters
+		}
+		gw.coalescer.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiters: got %d, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stampede sends n identical requests while the leader's upstream call is
+// held back, lets it go with release, and returns the response bodies.
+func stampede(t *testing.T, gw *Gateway, n int, started <-chan struct{}, release func()) []string {
+	t.Helper()
+	bodies := make([]string, n)
+	var wg sync.WaitGroup
+	serve := func(i int) {
+		defer wg.Done()
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/a?x=1", nil))
+		bodies[i] = rr.Body.String()
+	}
+	wg.Add(n)
+	go serve(0)
+	<-started // the leader's call is in flight
+	for i := 1; i < n; i++ {
+		go serve(i)
+	}
+	waitForWaiters(t, gw, "r1\x00GET\x00gw.local\x00/a?x=1", n-1)
+	release()
+	wg.Wait()
+	return bodies
+}
+
+func TestGateway_Coalesce(t *testing.T) {
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	gw, m := newCoalesceGateway(t, &config.Coalesce{MaxBodySize: 64, Timeout: 5 * time.Second}, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		_, _ = fmt.Fprint(w, "shared")
+	})
+
+	bodies := stampede(t, gw, 5, started, func() { close(release) })
+	for i, b := range bodies {
+		if b != "shared" {
+			t.Errorf("request %d: body %q", i, b)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("upstream calls: got %d, want 1", calls.Load())
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `coalesced_requests_total{route="r1"} 4`) {
+		t.Errorf("metrics:\n%s", buf.String())
+	}
+}
+
+func TestGateway_CoalesceNotShared(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body    string
+		cookie  bool
+		status  int
+		vary    string
+		timeout time.Duration
+	}{
+		"too_large":    {body: strings.Repeat("x", 65), timeout: 5 * time.Second},
+		"set_cookie":   {body: "x", cookie: true, timeout: 5 * time.Second},
+		"timeout":      {body: "x", timeout: 10 * time.Millisecond},
+		"partial":      {body: "x", status: http.StatusPartialContent, timeout: 5 * time.Second},
+		"server_error": {body: "x", status: http.StatusServiceUnavailable, timeout: 5 * time.Second},
+		"vary":         {body: "x", vary: "Accept-Encoding", timeout: 5 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			started, release := make(chan struct{}), make(chan struct{})
+			gw, _ := newCoalesceGateway(t, &config.Coalesce{MaxBodySize: 64, Timeout: tc.timeout}, func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					close(started)
+					<-release // only the leader is slow
+				}
+				if tc.cookie {
+					w.Header().Set("Set-Cookie", "session=1")
+				}
+				if tc.vary != "" {
+					w.Header().Set("Vary", tc.vary)
+				}
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				_, _ = fmt.Fprint(w, tc.body)
+			})
+
+			bodies := stampede(t, gw, 3, started, func() {
+				if name == "timeout" {
+					// the waiters give up and call the upstream themselves
+					for calls.Load() < 3 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				close(release)
+			})
+			for i, b := range bodies {
+				if b != tc.body {
+					t.Errorf("request %d: body %q", i, b)
+				}
+			}
+			if calls.Load() != 3 {
+				t.Errorf("upstream calls: got %d, want 3", calls.Load())
+			}
+		})
+	}
+}
+
+func TestGateway_CoalesceConditionalLeader(t *testing.T) {
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	gw, _ := newCoalesceGateway(t, &config.Coalesce{MaxBodySize: 64, Timeout: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = fmt.Fprint(w, "hello")
+	})
+
+	conditional := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest("GET", "http://gw.local/a?x=1", nil)
+		req.Header.Set("If-None-Match", `"v1"`)
+		gw.ServeHTTP(conditional, req)
+	}()
+	<-started
+	// the leader's 304 only fits its own request: a plain one must not wait for it
+	plain := httptest.NewRecorder()
+	plainDone := make(chan struct{})
+	go func() {
+		defer close(plainDone)
+		gw.ServeHTTP(plain, httptest.NewRequest("GET", "http://gw.local/a?x=1", nil))
+	}()
+	select {
+	case <-plainDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plain request waited for the conditional leader")
+	}
+	close(release)
+	<-done
+
+	if conditional.Code != http.StatusNotModified {
+		t.Errorf("conditional: status %d, want 304", conditional.Code)
+	}
+	if plain.Code != http.StatusOK || plain.Body.String() != "hello" {
+		t.Errorf("plain: status %d body %q", plain.Code, plain.Body.String())
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	route := &config.Route{Name: "r1", Coalesce: &config.Coalesce{Headers: []string{"Accept-Language"}}}
+	req := func(method, lang, auth string) *http.Request {
+		r := httptest.NewRequest(method, "http://GW.local/a?x=1", nil)
+		if lang != "" {
+			r.Header.Set("Accept-Language", lang)
+		}
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		return r
+	}
+	if coalesceKey(req("GET", "en", ""), route) != coalesceKey(req("GET", "en", ""), route) {
+		t.Error("identical requests: keys differ")
+	}
+	if coalesceKey(req("GET", "en", ""), route) == coalesceKey(req("GET", "fr", ""), route) {
+		t.Error("selected header: keys match")
+	}
+	if coalesceKey(req("GET", "", ""), route) == coalesceKey(req("HEAD", "", ""), route) {
+		t.Error("GET and HEAD: keys match")
+	}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+		r := req("GET", "", "")
+		r.Header.Set(name, "x")
+		if k := coalesceKey(r, route); k != "" {
+			t.Errorf("%s: got key %q", name, k)
+		}
+	}
+	if k := coalesceKey(req("POST", "", ""), route); k != "" {
+		t.Errorf("POST: got key %q", k)
+	}
+	if k := coalesceKey(req("GET", "", "Bearer a"), route); k != "" {
+		t.Errorf("unlisted Authorization: got key %q", k)
+	}
+	route.Coalesce.Headers = append(route.Coalesce.Headers, "Authorization")
+	if coalesceKey(req("GET", "", "Bearer a"), route) == coalesceKey(req("GET", "", "Bearer b"), route) {
+		t.Error("listed Authorization: keys match")
+	}
+
+	// subset headers pick the upstream, so they are part of the key unlisted
+	route.Subset = &config.Subset{Headers: map[string]string{"X-Version": "version"}}
+	v1, v2 := req("GET", "", ""), req("GET", "", "")
+	v1.Header.Set("X-Version", "v1")
+	v2.Header.Set("X-Version", "v2")
+	if coalesceKey(v1, route) == coalesceKey(v2, route) {
+		t.Error("subset header: keys match")
+	}
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is a pooled streaming compressor.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	"br": {New: func() any { return brotli.NewWriterLevel(nil, 5) }},
+	"zstd": {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return w
+	}},
+}
+
+// compressWriter compresses the response body on its way to the client.
+// Flush pushes everything written so far through the encoder, so streamed
+// responses are not held back.
+type compressWriter struct {
+	*loggingResponseWriter
+	enc  encoder
+	pool *sync.Pool
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) { return c.enc.Write(b) }
+
+func (c *compressWriter) Flush() {
+	if err := c.enc.Flush(); err == nil {
+		c.loggingResponseWriter.Flush()
+	}
+}
+
+// Close writes the encoder's trailer and returns it to the pool.
+func (c *compressWriter) Close() error {
+	err := c.enc.Close()
+	c.enc.Reset(nil)
+	c.pool.Put(c.enc)
+	return err
+}
+
+// compressResponse decides whether the upstream response res is compressed
+// for the client. It adjusts the response headers in w accordingly and
+// returns the writer for the body, or nil to copy the body unchanged.
+func compressResponse(w *loggingResponseWriter, r *http.Request, res *http.Response, c *config.Compression) *compressWriter {
+	if c == nil || r.Method == http.MethodHead {
+		return nil
+	}
+	h := w.Header()
+	switch {
+	case res.StatusCode < 200, res.StatusCode == http.StatusNoContent,
+		res.StatusCode == http.StatusNotModified, res.StatusCode == http.StatusPartialContent:
+		return nil
+	case h.Get("Content-Encoding") != "" && !strings.EqualFold(h.Get("Content-Encoding"), "identity"):
+		return nil // already encoded upstream
+	case h.Get("Content-Range") != "" || hasToken(h.Values("Cache-Control"), "no-transform"):
+		return nil
+	case !matchContentType(h.Get("Content-Type"), c.ContentTypes):
+		return nil
+	}
+	// from here on the representation depends on Accept-Encoding
+	if !hasToken(h.Values("Vary"), "Accept-Encoding") && !hasToken(h.Values("Vary"), "*") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if res.ContentLength >= 0 && res.ContentLength < c.MinSize {
+		return nil
+	}
+	alg := negotiateEncoding(r.Header.Values("Accept-Encoding"), c.Algorithms)
+	if alg == "" {
+		return nil
+	}
+
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges") // byte ranges of the compressed form are not offered
+	h.Set("Content-Encoding", alg)
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// the compressed bytes differ, so the validator is only weakly equal
+		h.Set("Etag", "W/"+etag)
+	}
+	pool := encoderPools[alg]
+	enc := pool.Get().(encoder)
+	enc.Reset(w)
+	return &compressWriter{loggingResponseWriter: w, enc: enc, pool: pool}
+}
+
+// negotiateEncoding picks the algorithm the client weighs highest (RFC 9110,
+// 12.5.3); ties go to the earlier entry of algs. It returns "" when the client
+// accepts none of them.
+func negotiateEncoding(accept []string, algs []string) string {
+	best, bestQ := "", 0.0
+	for _, alg := range algs {
+		q, wildcard := -1.0, -1.0
+		for _, v := range accept {
+			for _, part := range strings.Split(v, ",") {
+				name, params, _ := strings.Cut(part, ";")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name != alg && name != "*" && !(alg == "gzip" && name == "x-gzip") {
+					continue
+				}
+				pq := 1.0
+				if k, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+					if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+						pq = f
+					}
+				}
+				if name == "*" {
+					wildcard = pq
+				} else {
+					q = pq
+				}
+			}
+		}
+		if q < 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = alg, q
+		}
+	}
+	return best
+}
+
+// matchContentType matches the media type of ct against patterns such as
+// "application/json", "text/*" or "application/*+json".
+func matchContentType(ct string, patterns []string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	typ, sub, _ := strings.Cut(mt, "/")
+	for _, p := range patterns {
+		pt, ps, _ := strings.Cut(p, "/")
+		if pt != typ && pt != "*" {
+			continue
+		}
+		switch {
+		case ps == "*", ps == sub:
+			return true
+		case strings.HasPrefix(ps, "*+") && strings.HasSuffix(sub, ps[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// hasToken reports whether a comma-separated header contains tok.
+func hasToken(values []string, tok string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t, _, _ = strings.Cut(t, "="); strings.EqualFold(strings.TrimSpace(t), tok) {
+				return true
+			}
+		}
+	}
+	return false
+}
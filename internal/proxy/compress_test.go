@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	algs := []string{"zstd", "br", "gzip"}
+	cases := map[string]string{
+		"":                           "",
+		"identity":                   "",
+		"gzip":                       "gzip",
+		"x-gzip":                     "gzip",
+		"gzip, deflate, br, zstd":    "zstd",
+		"gzip;q=1.0, br;q=0.8":       "gzip",
+		"br;q=0.5, zstd;q=0":         "br",
+		"*":                          "zstd",
+		"*;q=0.1, gzip":              "gzip",
+		"zstd;q=0, *":                "br",
+		"GZIP ; q=0.3, deflate":      "gzip",
+		"gzip;q=0, br;q=0, zstd;q=0": "",
+	}
+	for accept, want := range cases {
+		if got := negotiateEncoding([]string{accept}, algs); got != want {
+			t.Errorf("%q: got %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestMatchContentType(t *testing.T) {
+	pats := config.DefaultCompressionContentTypes
+	for ct, want := range map[string]bool{
+		"application/json; charset=utf-8": true,
+		"text/html":                       true,
+		"application/problem+json":        true,
+		"image/svg+xml":                   true,
+		"image/png":                       false,
+		"application/octet-stream":        false,
+		"":                                false,
+	} {
+		if got := matchContentType(ct, pats); got != want {
+			t.Errorf("%q: got %v, want %v", ct, got, want)
+		}
+	}
+}
+
+func newCompressionGateway(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	up := httptest.NewServer(handler)
+	t.Cleanup(up.Close)
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Compression: &config.Compression{
+		Algorithms:   config.DefaultCompressionAlgorithms,
+		MinSize:      config.DefaultCompressionMinSize,
+		ContentTypes: config.DefaultCompressionContentTypes,
+	}}}
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	front := httptest.NewServer(gw)
+	t.Cleanup(front.Close)
+	return front
+}
+
+func TestGateway_Compression(t *testing.T) {
+	payload := strings.Repeat(`{"id":1,"name":"gateway"},`, 200)
+	front := newCompressionGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{}`)
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, payload)
+		case "/encoded":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = io.WriteString(w, payload) // opaque to the gateway
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Etag", `"v1"`)
+			w.Header().Set("Content-Length", "5200")
+			_, _ = io.WriteString(w, payload)
+		}
+	})
+	get := func(t *testing.T, path, accept string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", front.URL+path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		res, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer func() { _ = res.Body.Close() }()
+		b, _ := io.ReadAll(res.Body)
+		return res, b
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for alg, decode := range decoders {
+		t.Run(alg, func(t *testing.T) {
+			res, b := get(t, "/data", alg+", identity;q=0.5")
+			if got := res.Header.Get("Content-Encoding"); got != alg {
+				t.Fatalf("Content-Encoding: got %q, want %s", got, alg)
+			}
+			if res.ContentLength != -1 && res.ContentLength != int64(len(b)) {
+				t.Errorf("Content-Length %d does not match the %d compressed bytes", res.ContentLength, len(b))
+			}
+			if len(b) >= len(payload) {
+				t.Errorf("body not compressed: %d bytes", len(b))
+			}
+			if got := res.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary: got %q", got)
+			}
+			if got := res.Header.Get("Etag"); got != `W/"v1"` {
+				t.Errorf("ETag: got %q, want weakened", got)
+			}
+			r, err := decode(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("decoder: %v", err)
+			}
+			if plain, err := io.ReadAll(r); err != nil || string(plain) != payload {
+				t.Errorf("decoded body mismatch (err %v)", err)
+			}
+		})
+	}
+
+	t.Run("uncompressed", func(t *testing.T) {
+		for _, tc := range []struct{ path, accept, vary, encoding string }{
+			{"/data", "", "Accept-Encoding", ""},
+			{"/small", "gzip", "Accept-Encoding", ""},
+			{"/png", "gzip", "", ""},
+			{"/encoded", "br", "", "gzip"},
+		} {
+			res, b := get(t, tc.path, tc.accept)
+			if res.Header.Get("Content-Encoding") != tc.encoding || res.Header.Get("Vary") != tc.vary {
+				t.Errorf("%s (%q): Content-Encoding %q Vary %q", tc.path, tc.accept, res.Header.Get("Content-Encoding"), res.Header.Get("Vary"))
+			}
+			if tc.path != "/small" && len(b) != len(payload) {
+				t.Errorf("%s: body changed, %d bytes", tc.path, len(b))
+			}
+		}
+	})
+}
+
+func TestGateway_CompressionStreaming(t *testing.T) {
+	release := make(chan struct{})
+	front := newCompressionGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "first chunk\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second chunk\n")
+	})
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", front.URL+"/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding: got %q", res.Header.Get("Content-Encoding"))
+	}
+
+	got := make(chan string, 1)
+	go func() {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		buf := make([]byte, 12)
+		_, err = io.ReadFull(zr, buf)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(buf)
+	}()
+	select {
+	case s := <-got:
+		if s != "first chunk\n" {
+			t.Errorf("first chunk: got %q", s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("flushed chunk was held back by the encoder")
+	}
+}
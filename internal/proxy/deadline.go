@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deadline headers sent by clients.
+const (
+	headerGRPCTimeout    = "Grpc-Timeout"
+	headerRequestTimeout = "X-Request-Timeout"
+)
+
+// clientTimeout returns the smallest timeout requested by the client through
+// grpc-timeout or x-request-timeout. Malformed values are ignored.
+func clientTimeout(h http.Header) (time.Duration, bool) {
+	var out time.Duration
+	found := false
+	if d, ok := parseGRPCTimeout(h.Get(headerGRPCTimeout)); ok {
+		out, found = d, true
+	}
+	if d, ok := parseRequestTimeout(h.Get(headerRequestTimeout)); ok && (!found || d < out) {
+		out, found = d, true
+	}
+	return out, found
+}
+
+// parseGRPCTimeout parses the gRPC "TimeoutValue TimeoutUnit" form: at most
+// 8 digits followed by one of H, M, S, m, u, n.
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch v[len(v)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return mulDuration(n, unit), true
+}
+
+// mulDuration is n*unit, clamped to the largest Duration instead of
+// overflowing, as grpc-go does.
+func mulDuration(n int64, unit time.Duration) time.Duration {
+	if n > math.MaxInt64/int64(unit) {
+		return math.MaxInt64
+	}
+	return time.Duration(n) * unit
+}
+
+// formatGRPCTimeout encodes d in the finest unit that fits in 8 digits,
+// truncating so the upstream never sees more budget than is left.
+func formatGRPCTimeout(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	const maxValue = 1e8 - 1
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+	} {
+		if v := d / u.unit; v <= maxValue {
+			return strconv.FormatInt(int64(v), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(min(d/time.Hour, maxValue)), 10) + "H"
+}
+
+// parseRequestTimeout accepts integer milliseconds or a Go duration ("1.5s").
+func parseRequestTimeout(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return mulDuration(ms, time.Millisecond), ms >= 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d >= 0
+}
+
+// propagateDeadline rewrites the deadline headers of an upstream request to
+// the budget remaining until deadline. gRPC calls always carry grpc-timeout;
+// x-request-timeout is only forwarded if the client sent it.
+func propagateDeadline(h http.Header, grpc bool, deadline time.Time) {
+	remaining := time.Until(deadline)
+	if grpc || h.Get(headerGRPCTimeout) != "" {
+		h.Set(headerGRPCTimeout, formatGRPCTimeout(remaining))
+	}
+	if h.Get(headerRequestTimeout) != "" {
+		h.Set(headerRequestTimeout, strconv.FormatInt(max(remaining.Milliseconds(), 0), 10))
+	}
+}
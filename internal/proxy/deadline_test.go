@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"1H":        time.Hour,
+		"2M":        2 * time.Minute,
+		"3S":        3 * time.Second,
+		"250m":      250 * time.Millisecond,
+		"10u":       10 * time.Microsecond,
+		"99999999n": 99999999,
+		"3000000H":  math.MaxInt64, // clamped instead of overflowing
+		"99999999H": math.MaxInt64,
+	} {
+		got, ok := parseGRPCTimeout(v)
+		if !ok || got != want {
+			t.Errorf("parseGRPCTimeout(%q): got %v/%v, want %v", v, got, ok, want)
+		}
+	}
+	for _, v := range []string{"", "S", "10", "10s", "-1S", "123456789S"} {
+		if _, ok := parseGRPCTimeout(v); ok {
+			t.Errorf("parseGRPCTimeout(%q): want error", v)
+		}
+	}
+}
+
+func TestFormatGRPCTimeout(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                       "0n",
+		1500 * time.Microsecond: "1500000n",
+		250 * time.Millisecond:  "250000u",
+		3 * time.Minute:         "180000m",
+		48 * time.Hour:          "172800S",
+		-time.Second:            "0n",
+	} {
+		if got := formatGRPCTimeout(d); got != want {
+			t.Errorf("formatGRPCTimeout(%v): got %q, want %q", d, got, want)
+		}
+		if got, _ := parseGRPCTimeout(formatGRPCTimeout(d)); d >= 0 && got != d {
+			t.Errorf("round trip %v: got %v", d, got)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	h := http.Header{}
+	if _, ok := clientTimeout(h); ok {
+		t.Fatal("no headers: want no timeout")
+	}
+	h.Set("X-Request-Timeout", "1500")
+	if d, _ := clientTimeout(h); d != 1500*time.Millisecond {
+		t.Errorf("ms: got %v, want 1.5s", d)
+	}
+	h.Set("X-Request-Timeout", "99999999999999999")
+	if d, _ := clientTimeout(h); d != math.MaxInt64 {
+		t.Errorf("huge ms: got %v, want clamped", d)
+	}
+	h.Set("X-Request-Timeout", "2s")
+	h.Set("Grpc-Timeout", "500m")
+	if d, _ := clientTimeout(h); d != 500*time.Millisecond {
+		t.Errorf("smallest: got %v, want 500ms", d)
+	}
+}
+
+func TestGateway_DeadlinePropagation(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+		}
+	}))
+	defer up.Close()
+
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{
+		{Name: "capped", PathPrefix: "/slow", Service: "s1", Timeout: 50 * time.Millisecond},
+		{Name: "default", PathPrefix: "/", Service: "s1"},
+	}
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 10*time.Second, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	// client budget is smaller than the global timeout and forwarded, minus time spent
+	req := grpcRequest("/pkg.Svc/Get")
+	req.Header.Set("Grpc-Timeout", "2S")
+	gw.ServeHTTP(httptest.NewRecorder(), req)
+	got, ok := parseGRPCTimeout((<-seen).Get("Grpc-Timeout"))
+	if !ok || got > 2*time.Second || got < time.Second {
+		t.Errorf("forwarded grpc-timeout: got %v, want just under 2s", got)
+	}
+
+	// gRPC without a client deadline learns the gateway's
+	gw.ServeHTTP(httptest.NewRecorder(), grpcRequest("/pkg.Svc/Get"))
+	got, ok = parseGRPCTimeout((<-seen).Get("Grpc-Timeout"))
+	if !ok || got > 10*time.Second || got < 9*time.Second {
+		t.Errorf("gateway grpc-timeout: got %v, want just under 10s", got)
+	}
+
+	// an overflowing client budget leaves the gateway's in place
+	req = grpcRequest("/pkg.Svc/Get")
+	req.Header.Set("Grpc-Timeout", "3000000H")
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	got, ok = parseGRPCTimeout((<-seen).Get("Grpc-Timeout"))
+	if !ok || got > 10*time.Second || got < 9*time.Second || rr.Code != http.StatusOK {
+		t.Errorf("huge grpc-timeout: status %d forwarded %v, want just under 10s", rr.Code, got)
+	}
+
+	// x-request-timeout is forwarded in milliseconds
+	req = httptest.NewRequest("GET", "http://gw.local/plain", nil)
+	req.Header.Set("X-Request-Timeout", "3s")
+	gw.ServeHTTP(httptest.NewRecorder(), req)
+	h := <-seen
+	if ms, err := strconv.Atoi(h.Get("X-Request-Timeout")); err != nil || ms > 3000 || ms < 2000 {
+		t.Errorf("forwarded x-request-timeout: got %q, want just under 3000", h.Get("X-Request-Timeout"))
+	}
+	if h.Get("Grpc-Timeout") != "" {
+		t.Errorf("plain HTTP got grpc-timeout %q", h.Get("Grpc-Timeout"))
+	}
+
+	// the route timeout caps a larger client budget
+	req = grpcRequest("/slow")
+	req.Header.Set("Grpc-Timeout", "10S")
+	rr = httptest.NewRecorder()
+	begin := time.Now()
+	gw.ServeHTTP(rr, req)
+	<-seen
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("route timeout not applied: took %v", elapsed)
+	}
+	if got := rr.Result().Trailer.Get("Grpc-Status"); got != "4" {
+		t.Errorf("grpc-status: got %q, want 4 (DEADLINE_EXCEEDED)", got)
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+)
+
+// statusClientClosedRequest is logged when the client goes away before the
+// response is complete (nginx's non-standard 499).
+const statusClientClosedRequest = 499
+
+var errClientWrite = errors.New("write to client failed")
+
+// clientGone reports whether err, from the upstream round trip or the body
+// copy, is the result of the client disconnecting.
+func clientGone(r *http.Request, err error) bool {
+	return errors.Is(err, errClientWrite) || r.Context().Err() != nil
+}
+
+// isIdempotent reports whether a request may be completed upstream after the
+// client has gone (RFC 9110, section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
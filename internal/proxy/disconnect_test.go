@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+// logChan delivers access log lines written after the handler returns.
+type logChan chan []byte
+
+func (c logChan) Write(p []byte) (int, error) {
+	c <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func (c logChan) next(t *testing.T) AccessLog {
+	t.Helper()
+	select {
+	case b := <-c:
+		var e AccessLog
+		if err := json.Unmarshal(b, &e); err != nil {
+			t.Fatalf("unmarshal log: %v\nraw: %s", err, b)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no access log entry")
+	}
+	return AccessLog{}
+}
+
+func newDisconnectGateway(t *testing.T, disconnect string, handler http.HandlerFunc) (*httptest.Server, logChan, *metrics.Registry) {
+	t.Helper()
+	up := httptest.NewServer(handler)
+	t.Cleanup(up.Close)
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1", Disconnect: disconnect}}
+	logs := make(logChan, 4)
+	m := metrics.NewRegistry()
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 5*time.Second, logs, config.AccessLogConfig{Sampling: 1.0}, m)
+	front := httptest.NewServer(gw)
+	t.Cleanup(front.Close)
+	return front, logs, m
+}
+
+func TestGateway_ClientDisconnectBeforeResponse(t *testing.T) {
+	canceled := make(chan bool, 1)
+	front, logs, m := newDisconnectGateway(t, "", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(3 * time.Second):
+			canceled <- false
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", front.URL+"/slow", nil)
+	if res, err := front.Client().Do(req); err == nil {
+		_ = res.Body.Close()
+		t.Fatal("request: want client timeout")
+	}
+
+	if !<-canceled {
+		t.Error("upstream request was not canceled")
+	}
+	if e := logs.next(t); e.Status != statusClientClosedRequest {
+		t.Errorf("log status: got %d, want 499", e.Status)
+	}
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `requests_total{service="s1",route="r1",method="GET",status="499"} 1`) {
+		t.Errorf("metrics missing 499:\n%s", buf.String())
+	}
+}
+
+func TestGateway_ClientDisconnectMidBody(t *testing.T) {
+	canceled := make(chan bool, 1)
+	front, logs, _ := newDisconnectGateway(t, "", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 1000))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(3 * time.Second):
+			canceled <- false
+		}
+	})
+
+	res, err := front.Client().Get(front.URL + "/stream")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if _, err := io.ReadFull(res.Body, make([]byte, 1000)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = res.Body.Close()
+	front.Client().CloseIdleConnections()
+
+	if !<-canceled {
+		t.Error("upstream request was not canceled")
+	}
+	e := logs.next(t)
+	if e.Status != statusClientClosedRequest {
+		t.Errorf("log status: got %d, want 499", e.Status)
+	}
+	if e.BytesWritten != 1000 {
+		t.Errorf("log bytes: got %d, want 1000", e.BytesWritten)
+	}
+}
+
+func TestGateway_ClientDisconnectFinish(t *testing.T) {
+	outcome := make(chan string, 1)
+	front, logs, _ := newDisconnectGateway(t, "finish", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			outcome <- r.Method + " canceled"
+		case <-time.After(300 * time.Millisecond):
+			_, _ = w.Write([]byte("done"))
+			outcome <- r.Method + " finished"
+		}
+	})
+
+	for method, want := range map[string]string{"GET": "GET finished", "POST": "POST canceled"} {
+		t.Run(method, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, method, front.URL+"/job", nil)
+			if res, err := front.Client().Do(req); err == nil {
+				_ = res.Body.Close()
+				t.Fatal("request: want client timeout")
+			}
+			if got := <-outcome; got != want {
+				t.Errorf("upstream: got %q, want %q", got, want)
+			}
+			if e := logs.next(t); e.Status != statusClientClosedRequest {
+				t.Errorf("log status: got %d, want 499", e.Status)
+			}
+		})
+	}
+}
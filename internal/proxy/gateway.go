@@ -1,13 +1,16 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
-	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/textproto"
 	"net/url"
 	"strconv"
@@ -16,9 +19,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/fabian4/gateway-homebrew-go/internal/cache"
 	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/headers"
 	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
 	"github.com/fabian4/gateway-homebrew-go/internal/ratelimit"
+	"github.com/fabian4/gateway-homebrew-go/internal/tracing"
+	"github.com/fabian4/gateway-homebrew-go/internal/transcode"
 	"github.com/fabian4/gateway-homebrew-go/internal/transport"
 )
 
@@ -29,50 +36,157 @@ type GatewayState struct {
 	UpstreamTimeout time.Duration
 	AccessLogConfig config.AccessLogConfig
 	RateLimitConfig ratelimit.Config
+	HTTP            config.HTTPConfig
+	Tracer          *tracing.Tracer // nil disables tracing
 }
 
 type Gateway struct {
 	stateMu     sync.RWMutex
 	state       *GatewayState
+	overrides   map[string]map[string]PeerOverride // service -> endpoint URL; guarded by stateMu
 	Transports  *transport.Registry
 	AccessLog   io.Writer
 	Metrics     *metrics.Registry
+	Cache       *cache.Cache // nil disables response caching
 	rateLimiter *ratelimit.Limiter
+	coalescer   *coalescer
 }
 
 func NewGateway(rt *Table, svcs map[string]config.Service, f *transport.Registry, upstreamTimeout time.Duration, accessLog io.Writer, alc config.AccessLogConfig, m *metrics.Registry) *Gateway {
-	lbs := make(map[string]Balancer)
-	for name, svc := range svcs {
-		lbs[name] = NewSmoothWRR(svc.Endpoints)
-	}
 	if accessLog == nil {
 		accessLog = io.Discard
 	}
-	state := &GatewayState{
-		Routes:          rt,
-		Services:        svcs,
-		balancers:       lbs,
-		UpstreamTimeout: upstreamTimeout,
-		AccessLogConfig: alc,
-	}
-	return &Gateway{state: state, Transports: f, AccessLog: accessLog, Metrics: m, rateLimiter: ratelimit.NewLimiter()}
+	g := &Gateway{Transports: f, AccessLog: accessLog, Metrics: m, rateLimiter: ratelimit.NewLimiter(), coalescer: newCoalescer(), overrides: make(map[string]map[string]PeerOverride)}
+	g.state = g.buildState(rt, svcs, upstreamTimeout, alc)
+	return g
 }
 
+// UpdateState swaps in a reloaded config. Services discovered from the same
+// source as before keep their current endpoints, which the reloaded config
+// does not have, so their routes never see an empty pool; svcs is updated
+// in place to match.
 func (g *Gateway) UpdateState(rt *Table, svcs map[string]config.Service, upstreamTimeout time.Duration, alc config.AccessLogConfig) {
+	g.stateMu.Lock()
+	for name, svc := range svcs {
+		if cur, ok := g.state.Services[name]; ok && len(svc.Endpoints) == 0 && sameDiscovery(cur, svc) {
+			svc.Endpoints = cur.Endpoints
+			svcs[name] = svc
+		}
+	}
+	next := g.buildState(rt, svcs, upstreamTimeout, alc)
+	next.HTTP = g.state.HTTP
+	next.Tracer = g.state.Tracer
+	g.state = next
+	g.stateMu.Unlock()
+}
+
+// sameDiscovery reports whether a and b take their endpoints from the same
+// discovery source.
+func sameDiscovery(a, b config.Service) bool {
+	return a.Discovery != "" && a.Discovery == b.Discovery &&
+		a.Consul != nil && b.Consul != nil && *a.Consul == *b.Consul
+}
+
+// UpdateHTTP replaces the request handling policy (header limits, path
+// normalization) without touching routes or balancers.
+func (g *Gateway) UpdateHTTP(h config.HTTPConfig) {
+	g.stateMu.Lock()
+	next := *g.state
+	next.HTTP = h
+	g.state = &next
+	g.stateMu.Unlock()
+}
+
+// UpdateTracer swaps the tracer used by new requests and returns the previous
+// one, which the caller shuts down.
+func (g *Gateway) UpdateTracer(t *tracing.Tracer) *tracing.Tracer {
+	g.stateMu.Lock()
+	defer g.stateMu.Unlock()
+	next := *g.state
+	old := next.Tracer
+	next.Tracer = t
+	g.state = &next
+	return old
+}
+
+// UpdateEndpoints replaces the endpoints of a single service and rebuilds only
+// its balancer; routes and all other services are carried over unchanged.
+func (g *Gateway) UpdateEndpoints(service string, eps []config.Endpoint) error {
+	if len(eps) == 0 {
+		return fmt.Errorf("service %q: endpoints is empty", service)
+	}
+	g.stateMu.Lock()
+	defer g.stateMu.Unlock()
+
+	cur := g.state
+	svc, ok := cur.Services[service]
+	if !ok {
+		return fmt.Errorf("service %q not found", service)
+	}
+	svc.Endpoints = eps
+
+	svcs := make(map[string]config.Service, len(cur.Services))
+	for name, s := range cur.Services {
+		svcs[name] = s
+	}
+	svcs[service] = svc
+	lbs := make(map[string]Balancer, len(cur.balancers))
+	for name, lb := range cur.balancers {
+		lbs[name] = lb
+	}
+	lbs[service] = g.newBalancer(svc)
+
+	next := *cur
+	next.Services = svcs
+	next.balancers = lbs
+	g.state = &next
+	return nil
+}
+
+func (g *Gateway) buildState(rt *Table, svcs map[string]config.Service, upstreamTimeout time.Duration, alc config.AccessLogConfig) *GatewayState {
 	lbs := make(map[string]Balancer)
 	for name, svc := range svcs {
-		lbs[name] = NewSmoothWRR(svc.Endpoints)
+		lbs[name] = g.newBalancer(svc)
 	}
-	newState := &GatewayState{
+	return &GatewayState{
 		Routes:          rt,
 		Services:        svcs,
 		balancers:       lbs,
 		UpstreamTimeout: upstreamTimeout,
 		AccessLogConfig: alc,
 	}
-	g.stateMu.Lock()
-	g.state = newState
-	g.stateMu.Unlock()
+}
+
+// newBalancer builds the balancer of a service with any admin overrides applied,
+// so overrides survive hot reload and endpoint updates.
+func (g *Gateway) newBalancer(svc config.Service) Balancer {
+	lb := NewServiceBalancer(svc)
+	if pa, ok := lb.(peerAdmin); ok {
+		pa.SetOverrides(g.overrides[svc.Name])
+	}
+	return lb
+}
+
+// ServiceBalancer returns a Balancer that always picks from the current
+// balancer of service, so L4 listeners, which are not rebuilt on reload, follow
+// hot reloads, discovery updates and admin overrides like L7 routes do.
+func (g *Gateway) ServiceBalancer(service string) Balancer {
+	return liveBalancer{g: g, service: service}
+}
+
+type liveBalancer struct {
+	g       *Gateway
+	service string
+}
+
+func (b liveBalancer) Next() Endpoint {
+	b.g.stateMu.RLock()
+	lb := b.g.state.balancers[b.service]
+	b.g.stateMu.RUnlock()
+	if lb == nil {
+		return nil
+	}
+	return lb.Next()
 }
 
 var _ http.Handler = (*Gateway)(nil)
@@ -84,13 +198,21 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 	lw := &loggingResponseWriter{ResponseWriter: w}
+	client := resolveClient(r, state.HTTP.TrustedProxies)
+	rid, ridHeader := requestID(r, state.HTTP.RequestID)
+	lw.requestID, lw.requestIDHeader = rid, ridHeader
+	span := startServerSpan(state.Tracer, r, client, rid, start)
 	var serviceName, upstreamAddr, routeName string
 	defer func() {
 		status := lw.statusCode
 		if status == 0 {
 			status = http.StatusOK
 		}
+		if lw.clientGone {
+			status = statusClientClosedRequest
+		}
 		duration := time.Since(start)
+		endServerSpan(span, status, upstreamAddr)
 
 		// Sampling
 		if state.AccessLogConfig.Sampling < 1.0 && rand.Float64() > state.AccessLogConfig.Sampling {
@@ -103,12 +225,20 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				Protocol:     r.Proto,
 				Status:       status,
 				Duration:     duration.Milliseconds(),
-				RemoteIP:     r.RemoteAddr,
+				RemoteIP:     client.IP,
 				UserAgent:    r.UserAgent(),
 				Referer:      r.Referer(),
 				Service:      serviceName,
 				Upstream:     upstreamAddr,
 				BytesWritten: lw.bytes,
+				GRPCStatus:   lw.grpcStatus,
+				Reason:       lw.reason,
+				RequestID:    rid,
+				Cache:        lw.cache,
+				Coalesced:    lw.coalesced,
+			}
+			if sc := span.Context(); sc.IsValid() {
+				entry.TraceID = sc.TraceID.String()
 			}
 
 			var logOutput any = entry
@@ -160,6 +290,24 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				if allowed["bytes_written"] {
 					m["bytes_written"] = entry.BytesWritten
 				}
+				if allowed["grpc_status"] {
+					m["grpc_status"] = entry.GRPCStatus
+				}
+				if allowed["reason"] {
+					m["reason"] = entry.Reason
+				}
+				if allowed["request_id"] {
+					m["request_id"] = entry.RequestID
+				}
+				if allowed["trace_id"] {
+					m["trace_id"] = entry.TraceID
+				}
+				if allowed["cache"] {
+					m["cache"] = entry.Cache
+				}
+				if allowed["coalesced"] {
+					m["coalesced"] = entry.Coalesced
+				}
 
 				logOutput = m
 			}
@@ -172,24 +320,60 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if g.Metrics != nil {
 			g.Metrics.IncRequest(serviceName, routeName, r.Method, strconv.Itoa(status))
 			g.Metrics.ObserveLatency(serviceName, routeName, duration)
+			if lw.cache != "" {
+				g.Metrics.IncCache(routeName, lw.cache)
+			}
+			if lw.coalesced {
+				g.Metrics.IncCoalesced(routeName)
+			}
 		}
 	}()
 
+	if reason := validateRequest(r, state.HTTP); reason != "" {
+		lw.reason = reason
+		replyError(lw, r, http.StatusBadRequest, grpcInvalidArgument, "400 Bad Request: "+reason)
+		return
+	}
+	r.Header.Set(ridHeader, rid) // forwarded upstream with the other request headers
+
 	route := state.Routes.Match(r.Host, r.URL.Path)
 	if route == nil {
-		http.NotFound(lw, r)
+		replyError(lw, r, http.StatusNotFound, grpcUnimplemented, "404 page not found")
 		return
 	}
 
+	routeSpan(span, r, route)
+	vars := requestVars(r, route, client, rid)
+	var svcResHeaders *headers.Policy
+	lw.onHeader = func(h http.Header) {
+		svcResHeaders.Apply(h, vars)
+		route.ResponseHeaders.Apply(h, vars)
+	}
+
+	var web *grpcWeb
+	if route.GRPCWeb {
+		if isGRPCWebPreflight(r) {
+			routeName, serviceName = route.Name, route.Service
+			replyGRPCWebPreflight(lw, r)
+			return
+		}
+		if isGRPCWeb(r) {
+			web = newGRPCWeb(r)
+		}
+	}
+
 	// Apply rate limiting if configured for the route.
 	if route.RateLimit != nil {
 		rps := route.RateLimit.RequestsPerSecond
 		burst := route.RateLimit.Burst
 		if rps > 0 && burst > 0 {
-			// For simplicity, using route name as the key. Can be extended to client IP, user ID, etc.
-			if !g.rateLimiter.Allow(route.Name, rps, burst) {
+			key := route.Name
+			if route.RateLimit.Key == "ip" {
+				key += "|" + client.IP
+			}
+			if !g.rateLimiter.Allow(key, rps, burst) {
 				log.Printf("Rate limit exceeded for route %q", route.Name)
-				http.Error(lw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				replyError(lw, r, http.StatusTooManyRequests, grpcResourceExhausted, http.StatusText(http.StatusTooManyRequests))
 				return
 			}
 		}
@@ -197,62 +381,178 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	serviceName = route.Service
 	routeName = route.Name
+
+	cr := g.lookupCache(lw, r, route)
+	if cr != nil && cr.hit {
+		svcResHeaders = state.Services[route.Service].ResponseHeaders
+		serveStored(lw, r, route, cr.stored)
+		if cr.refresh {
+			g.refreshInBackground(state, route, cr, r, client, vars)
+		}
+		return
+	}
+
+	// identical requests in flight share the leader's response
+	var lead *flight
+	if route.Coalesce != nil {
+		if key := coalesceKey(r, route); key != "" {
+			f, leader := g.coalescer.join(key)
+			if leader {
+				lead = f
+				defer g.coalescer.finish(key, f)
+			} else if res := f.wait(r, route.Coalesce.Timeout); res != nil {
+				lw.coalesced = true
+				svcResHeaders = state.Services[route.Service].ResponseHeaders
+				serveShared(lw, r, route, res)
+				return
+			} else if r.Context().Err() != nil {
+				lw.clientGone = true
+				return
+			}
+			// otherwise the wait timed out or there is nothing to share: call the upstream ourselves
+		}
+	}
+
+	var call *transcode.Call
+	if route.Transcode != nil {
+		var err error
+		if call, err = state.Routes.transcoder(route).Request(r); err != nil {
+			if errors.Is(err, transcode.ErrNoBinding) {
+				transcode.WriteError(lw, grpcNotFound, err.Error())
+			} else {
+				transcode.WriteError(lw, grpcInvalidArgument, err.Error())
+			}
+			return
+		}
+	}
 	svc, ok := state.Services[route.Service]
 	if !ok || len(svc.Endpoints) == 0 {
-		http.Error(lw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		if cr.serveStale(lw, r, route) {
+			return
+		}
+		replyError(lw, r, http.StatusBadGateway, grpcUnavailable, http.StatusText(http.StatusBadGateway))
 		return
 	}
-	// minimal choice: first endpoint (TODO: plug LB)
-	ep := state.balancers[route.Service].Next()
+	svcResHeaders = svc.ResponseHeaders
+	lb := state.balancers[route.Service]
+	if route.Subset != nil {
+		if lb = subsetBalancer(lb, route.Subset, r); lb == nil {
+			if cr.serveStale(lw, r, route) {
+				return
+			}
+			replyError(lw, r, http.StatusServiceUnavailable, grpcUnavailable, http.StatusText(http.StatusServiceUnavailable))
+			return
+		}
+	}
+	ep := lb.Next()
 	if ep == nil {
-		http.Error(lw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		if cr.serveStale(lw, r, route) {
+			return
+		}
+		replyError(lw, r, http.StatusBadGateway, grpcUnavailable, http.StatusText(http.StatusBadGateway))
 		return
 	}
+	// Feedback is deferred so the peer counts as in-flight until the body is copied.
+	success := false
+	var rtt time.Duration
+	defer func() { ep.Feedback(success, rtt) }()
 	base := ep.URL()
 	tr := g.Transports.Get(svc.Name)
 
-	// upstream URL = base + path
-	u := new(url.URL)
-	*u = *base
-	u.Path = joinSlash(base.Path, r.URL.Path)
-	u.RawQuery = r.URL.RawQuery
+	u := upstreamURL(base, r)
+	if call != nil {
+		u.Path, u.RawPath = joinSlash(base.Path, call.Path), ""
+		u.RawQuery = ""
+	}
 	upstreamAddr = u.String()
 
-	hdr := cloneHeader(r.Header)
-	dropHopByHop(hdr)
-	addXFF(hdr, r.RemoteAddr)
-	setXFProto(hdr, r)
-	setXFHost(hdr, r.Host)
+	hdr := upstreamHeader(r, state, svc, route, client, vars)
+	cr.addValidators(hdr)
+	if web != nil {
+		web.upstreamHeaders(hdr)
+	}
+	if call != nil {
+		call.UpstreamHeaders(hdr)
+	}
+	// the transcoder has already read the body, so 100-continue is settled
+	localContinue := route.Expect == "local" || call != nil
+	if localContinue {
+		hdr.Del("Expect")
+	}
+	info := newInformational(lw, r, localContinue)
 
+	// Deadline: the route (or global) upstream timeout, shortened by the client's
+	// grpc-timeout / x-request-timeout, counted from when the request arrived.
+	timeout, bounded := state.UpstreamTimeout, state.UpstreamTimeout > 0
+	if route.Timeout > 0 {
+		timeout, bounded = route.Timeout, true
+	}
+	if d, ok := clientTimeout(r.Header); ok && (!bounded || d < timeout) {
+		timeout, bounded = d, true
+	}
 	ctx := r.Context()
-	if state.UpstreamTimeout > 0 {
+	finish := route.Disconnect == "finish" && isIdempotent(r.Method)
+	if finish {
+		// the upstream call runs to completion (or its deadline) without the client
+		ctx = context.WithoutCancel(ctx)
+	}
+	if bounded {
+		deadline := start.Add(timeout)
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, state.UpstreamTimeout)
+		ctx, cancel = context.WithDeadline(ctx, deadline)
 		defer cancel()
+		propagateDeadline(hdr, isGRPC(r) || web != nil || call != nil, deadline)
 	}
 
-	reqUp, err := http.NewRequestWithContext(ctx, r.Method, u.String(), r.Body)
+	ctx = httptrace.WithClientTrace(ctx, info.trace())
+	method, body := r.Method, io.Reader(info.body(r.Body))
+	switch {
+	case web != nil:
+		body = web.upstreamBody(info.body(r.Body))
+	case call != nil:
+		method, body = http.MethodPost, bytes.NewReader(call.Body)
+	}
+	reqUp, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
-		http.Error(lw, "bad request", http.StatusBadRequest)
+		replyError(lw, r, http.StatusBadRequest, grpcInternal, "bad request")
 		return
 	}
 	reqUp.Header = hdr
-
-	// Host policy
 	switch {
-	case route.HostRewrite != "":
-		reqUp.Host = route.HostRewrite
-	case route.PreserveHost:
-		reqUp.Host = r.Host
-	default:
-		reqUp.Host = base.Host
+	case web != nil:
+		reqUp.ContentLength = -1
+	case call == nil:
+		reqUp.ContentLength = r.ContentLength
+		if reqUp.Trailer = requestTrailers(r); reqUp.Trailer != nil {
+			reqUp.ContentLength = -1 // trailers require a chunked body
+		}
 	}
 
+	reqUp.Host = upstreamHost(r, route, base)
+
+	attempt := startAttempt(state.Tracer, span, reqUp, route)
+	defer attempt.End()
+	rtStart := time.Now()
 	resUp, err := tr.RoundTrip(reqUp)
+	rtt = time.Since(rtStart)
+	endAttempt(attempt, resUp, err)
+	info.finish()
+	if err != nil && clientGone(r, err) {
+		// not the upstream's fault: no failure and no latency sample
+		success, rtt = true, 0
+		lw.clientGone = true
+		return
+	}
 	if err != nil {
 		log.Printf("upstream error: %v", err)
-		ep.Feedback(false)
-		http.Error(lw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		if cr.serveStale(lw, r, route) {
+			return
+		}
+		code := grpcUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = grpcDeadlineExceeded
+		}
+		replyError(lw, r, http.StatusBadGateway, code, http.StatusText(http.StatusBadGateway))
 		return
 	}
 	defer func(Body io.ReadCloser) {
@@ -261,39 +561,139 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}(resUp.Body)
 
-	if resUp.StatusCode >= 500 {
-		ep.Feedback(false)
-	} else {
-		ep.Feedback(true)
+	success = resUp.StatusCode < 500
+	if r.Context().Err() != nil {
+		// client left while a finish-mode call was running: complete it quietly
+		lw.clientGone = true
+		_, _ = io.Copy(io.Discard, resUp.Body)
+		return
 	}
 
 	dropHopByHop(resUp.Header)
-	copyHeaders(lw.Header(), resUp.Header)
-
-	// Announce trailers if any
-	if len(resUp.Trailer) > 0 {
-		trailerKeys := make([]string, 0, len(resUp.Trailer))
-		for k := range resUp.Trailer {
-			trailerKeys = append(trailerKeys, k)
+	if cr != nil {
+		lw.cacheStatus += "; fwd-status=" + strconv.Itoa(resUp.StatusCode)
+		if cr.invalidate && resUp.StatusCode < 400 {
+			g.Cache.Invalidate(cr.key)
+		}
+		switch resUp.StatusCode {
+		case http.StatusNotModified:
+			if cr.validating {
+				cr.stored = g.Cache.Refreshed(cr.key, cr.stored, resUp.Header, rtStart, rtStart.Add(rtt))
+				lw.cache = cacheRevalidated
+				serveStored(lw, r, route, cr.stored)
+				return
+			}
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			if cr.serveStale(lw, r, route) {
+				return
+			}
 		}
-		lw.Header().Set("Trailer", strings.Join(trailerKeys, ","))
 	}
+	if web != nil {
+		lw.grpcStatus = web.writeResponse(lw, resUp)
+		lw.clientGone = r.Context().Err() != nil
+		return
+	}
+	if call != nil {
+		lw.grpcStatus = call.WriteResponse(lw, resUp)
+		lw.clientGone = r.Context().Err() != nil
+		return
+	}
+	capture := cr.captureBody(r, resUp, route)
+	var share *bodyCapture
+	if lead != nil && shareable(resUp, route) {
+		share = teeBody(resUp, route.Coalesce.MaxBodySize)
+	}
+	if err := relayResponse(lw, r, resUp, route); err != nil {
+		if clientGone(r, err) {
+			lw.clientGone = true
+			if finish {
+				_, _ = io.Copy(io.Discard, resUp.Body)
+			}
+			return
+		}
+		log.Printf("upstream body error: %v", err)
+		success = false
+		return
+	}
+	if capture != nil && !capture.over {
+		g.Cache.Store(cr.key, route.Name, r, resUp.StatusCode, resUp.Header, capture.buf, rtStart, rtStart.Add(rtt))
+	}
+	if share != nil && !share.over {
+		lead.res = &sharedResponse{status: resUp.StatusCode, header: resUp.Header.Clone(), body: share.buf}
+	}
+}
 
-	lw.WriteHeader(resUp.StatusCode)
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
+// upstreamURL is base joined with r's path and query.
+func upstreamURL(base *url.URL, r *http.Request) *url.URL {
+	u := new(url.URL)
+	*u = *base
+	u.Path = joinSlash(base.Path, r.URL.Path)
+	u.RawPath = ""
+	if r.URL.RawPath != "" {
+		// keep escapes such as %2F so the upstream sees the path that was routed
+		u.RawPath = joinSlash(base.EscapedPath(), r.URL.EscapedPath())
 	}
+	u.RawQuery = r.URL.RawQuery
+	return u
+}
 
-	_, _ = io.Copy(lw, resUp.Body)
+// upstreamHeader builds the upstream request headers of r: hop-by-hop
+// headers dropped, forwarding headers added and the service's and route's
+// policies applied.
+func upstreamHeader(r *http.Request, state *GatewayState, svc config.Service, route *config.Route, client clientAddr, vars *headers.Vars) http.Header {
+	hdr := cloneHeader(r.Header)
+	dropHopByHop(hdr)
+	headers.DefaultRequest.Apply(hdr, vars)
+	if client.Untrusted {
+		hdr.Del("Forwarded")
+	}
+	if state.HTTP.Forwarded {
+		headers.Forwarded.Apply(hdr, vars)
+	}
+	svc.RequestHeaders.Apply(hdr, vars)
+	route.RequestHeaders.Apply(hdr, vars)
+	return hdr
+}
 
-	// Copy trailer values
-	if len(resUp.Trailer) > 0 {
-		for k, vv := range resUp.Trailer {
-			for _, v := range vv {
-				lw.Header().Add(k, v)
-			}
+// upstreamHost applies the route's host policy.
+func upstreamHost(r *http.Request, route *config.Route, base *url.URL) string {
+	switch {
+	case route.HostRewrite != "":
+		return route.HostRewrite
+	case route.PreserveHost:
+		return r.Host
+	default:
+		return base.Host
+	}
+}
+
+// relayResponse writes res to the client, compressing the body if the route
+// asks for it, and forwards its trailers.
+func relayResponse(lw *loggingResponseWriter, r *http.Request, res *http.Response, route *config.Route) error {
+	copyHeaders(lw.Header(), res.Header)
+	declared := announceTrailers(lw.Header(), res)
+	var out http.ResponseWriter = lw
+	cw := compressResponse(lw, r, res, route.Compression)
+	if cw != nil {
+		out = cw
+	}
+
+	lw.WriteHeader(res.StatusCode)
+	lw.Flush()
+
+	err := copyBody(out, res.Body, res.ContentLength < 0)
+	if cw != nil {
+		if cerr := cw.Close(); err == nil && cerr != nil {
+			err = errClientWrite
 		}
 	}
+	if err != nil {
+		return err
+	}
+	forwardTrailers(lw.Header(), res, declared)
+	lw.grpcStatus = grpcStatusOf(res)
+	return nil
 }
 
 // --- helpers ---
@@ -359,29 +759,26 @@ func dropHopByHop(h http.Header) {
 	}
 }
 
-func addXFF(h http.Header, remoteAddr string) {
-	ip, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil || ip == "" {
-		return
+// requestVars collects the header template variables of a routed request.
+func requestVars(r *http.Request, route *config.Route, client clientAddr, requestID string) *headers.Vars {
+	v := &headers.Vars{
+		ClientIP:     client.IP,
+		ForwardedFor: client.ForwardedFor,
+		Scheme:       "http",
+		Host:         r.Host,
+		Route:        route.Name,
+		Service:      route.Service,
+		RequestID:    requestID,
 	}
-	const key = "X-Forwarded-For"
-	if prior := h.Get(key); prior != "" {
-		h.Set(key, prior+", "+ip)
-	} else {
-		h.Set(key, ip)
-	}
-}
-
-func setXFHost(h http.Header, host string) {
-	h.Set("X-Forwarded-Host", host)
-}
-
-func setXFProto(h http.Header, r *http.Request) {
 	if r.TLS != nil {
-		h.Set("X-Forwarded-Proto", "https")
-	} else {
-		h.Set("X-Forwarded-Proto", "http")
+		v.Scheme = "https"
+		v.SNI = r.TLS.ServerName
 	}
+	v.Forwarded = forwardedElement(client.Peer, r.Host, v.Scheme)
+	if client.Forwarded != "" {
+		v.Forwarded = client.Forwarded + ", " + v.Forwarded
+	}
+	return v
 }
 
 type AccessLog struct {
@@ -397,21 +794,62 @@ type AccessLog struct {
 	Service      string    `json:"service,omitempty"`
 	Upstream     string    `json:"upstream,omitempty"`
 	BytesWritten int64     `json:"bytes_written"`
+	GRPCStatus   string    `json:"grpc_status,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+	TraceID      string    `json:"trace_id,omitempty"`
+	Cache        string    `json:"cache,omitempty"` // hit, stale, revalidated, miss or bypass
+	Coalesced    bool      `json:"coalesced,omitempty"`
 }
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	bytes      int64
+	grpcStatus string
+	reason     string              // why the gateway rejected the request, if it did
+	clientGone bool                // client disconnected before the response was complete
+	onHeader   func(h http.Header) // response header policy, run once before the status line
+
+	requestID       string // correlation ID, echoed as requestIDHeader and in error bodies
+	requestIDHeader string
+
+	cache       string // cache result on caching routes
+	cacheStatus string // Cache-Status parameters, added to the response header
+	coalesced   bool   // served the response of an identical request's upstream call
+}
+
+// setCache records how the cache handled the request.
+func (w *loggingResponseWriter) setCache(result, status string) {
+	w.cache, w.cacheStatus = result, status
+}
+
+// applyHeaderPolicy echoes the request ID and runs the response header policy.
+// It only acts on the first call.
+func (w *loggingResponseWriter) applyHeaderPolicy() {
+	if w.requestIDHeader != "" {
+		w.Header().Set(w.requestIDHeader, w.requestID)
+		w.requestIDHeader = ""
+	}
+	if w.cacheStatus != "" {
+		w.Header().Add("Cache-Status", cacheStatusName+"; "+w.cacheStatus)
+		w.cacheStatus = ""
+	}
+	if w.onHeader != nil {
+		w.onHeader(w.Header())
+		w.onHeader = nil
+	}
 }
 
 func (w *loggingResponseWriter) WriteHeader(code int) {
+	w.applyHeaderPolicy()
 	w.statusCode = code
 	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *loggingResponseWriter) Write(b []byte) (int, error) {
 	if w.statusCode == 0 {
+		w.applyHeaderPolicy()
 		w.statusCode = http.StatusOK
 	}
 	n, err := w.ResponseWriter.Write(b)
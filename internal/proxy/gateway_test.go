@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/headers"
 	"github.com/fabian4/gateway-homebrew-go/internal/metrics"
 	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func mustURL(t *testing.T, s string) *url.URL {
@@ -56,7 +62,7 @@ func TestGateway_BasicRouteAndHeaders(t *testing.T) {
 			// default: preserve_host=false, no host_rewrite
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
 
 	// downstream request
@@ -120,7 +126,7 @@ func TestGateway_PreserveHost(t *testing.T) {
 			PreserveHost: true,
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
 
 	req := httptest.NewRequest("GET", "http://gw.local/", nil)
@@ -157,7 +163,7 @@ func TestGateway_HostRewrite(t *testing.T) {
 			HostRewrite: "rewrite.local",
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
 
 	req := httptest.NewRequest("GET", "http://gw.local/", nil)
@@ -192,7 +198,7 @@ func TestGateway_AccessLog(t *testing.T) {
 			Service:    "s1",
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 
 	var buf bytes.Buffer
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, &buf, config.AccessLogConfig{Sampling: 1.0}, nil)
@@ -261,7 +267,7 @@ func TestGateway_GRPCTrailers(t *testing.T) {
 			Service:    "grpc",
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
 
 	req := httptest.NewRequest("POST", "http://gw.local/grpc.health.v1.Health/Check", nil)
@@ -309,7 +315,7 @@ func TestGateway_Metrics(t *testing.T) {
 			Service:    "s1",
 		},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 	m := metrics.NewRegistry()
 	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, m)
 
@@ -348,7 +354,7 @@ func TestGateway_AccessLog_SamplingAndFields(t *testing.T) {
 	rs := []config.Route{
 		{Name: "r1", Host: "log.local", PathPrefix: "/", Service: "s1"},
 	}
-	rt := NewRouter(rs)
+	rt := mustRouter(t, rs)
 
 	// 1. Test Sampling (0.0 -> no logs)
 	var buf bytes.Buffer
@@ -401,7 +407,7 @@ func TestGateway_UpdateState(t *testing.T) {
 	rs1 := []config.Route{
 		{Name: "r1", Host: "update.local", PathPrefix: "/v1", Service: "s1"},
 	}
-	rt1 := NewRouter(rs1)
+	rt1 := mustRouter(t, rs1)
 
 	gw := NewGateway(rt1, svcs1, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
 
@@ -428,7 +434,7 @@ func TestGateway_UpdateState(t *testing.T) {
 	rs2 := []config.Route{
 		{Name: "r1", Host: "update.local", PathPrefix: "/v1", Service: "s2"},
 	}
-	rt2 := NewRouter(rs2)
+	rt2 := mustRouter(t, rs2)
 
 	gw.UpdateState(rt2, svcs2, 0, config.AccessLogConfig{Sampling: 1.0})
 
@@ -439,3 +445,261 @@ func TestGateway_UpdateState(t *testing.T) {
 		t.Fatalf("updated state: want s2, got %q", rr2.Header().Get("X-Svc"))
 	}
 }
+
+func TestGateway_UpdateStateKeepsDiscoveredEndpoints(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	consul := &config.ConsulDiscovery{Address: "http://127.0.0.1:8500", Service: "web"}
+	discovered := func(c *config.ConsulDiscovery) map[string]config.Service {
+		return map[string]config.Service{"web": {Name: "web", Proto: "http1", Discovery: "consul", Consul: c}}
+	}
+	rt := mustRouter(t, []config.Route{{Name: "r1", PathPrefix: "/", Service: "web"}})
+	gw := NewGateway(rt, discovered(consul), transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	if err := gw.UpdateEndpoints("web", []config.Endpoint{{URL: mustURL(t, up.URL)}}); err != nil {
+		t.Fatal(err)
+	}
+	serve := func() int {
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/", nil))
+		return rr.Code
+	}
+
+	// a reload with the same source keeps serving without waiting for discovery
+	gw.UpdateState(rt, discovered(&config.ConsulDiscovery{Address: "http://127.0.0.1:8500", Service: "web"}), 0, config.AccessLogConfig{Sampling: 1.0})
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("after reload: status %d, want 200", code)
+	}
+
+	// a new source starts empty until its own discovery fills it
+	gw.UpdateState(rt, discovered(&config.ConsulDiscovery{Address: "http://127.0.0.1:8500", Service: "api"}), 0, config.AccessLogConfig{Sampling: 1.0})
+	if code := serve(); code != http.StatusBadGateway {
+		t.Fatalf("after source change: status %d, want 502", code)
+	}
+}
+
+func TestGateway_UpdateEndpoints(t *testing.T) {
+	up1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Peer", "p1")
+	}))
+	defer up1.Close()
+	up2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Peer", "p2")
+	}))
+	defer up2.Close()
+
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up1.URL)}}},
+	}
+	rt := mustRouter(t, []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1"}})
+	gw := NewGateway(rt, svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	if err := gw.UpdateEndpoints("s1", []config.Endpoint{{URL: mustURL(t, up2.URL)}}); err != nil {
+		t.Fatalf("UpdateEndpoints: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/", nil))
+	if got := rr.Header().Get("X-Peer"); got != "p2" {
+		t.Fatalf("after update: want p2, got %q", got)
+	}
+
+	if err := gw.UpdateEndpoints("s1", nil); err == nil {
+		t.Fatal("want error for empty endpoints")
+	}
+	if err := gw.UpdateEndpoints("missing", []config.Endpoint{{URL: mustURL(t, up1.URL)}}); err == nil {
+		t.Fatal("want error for unknown service")
+	}
+	// rejected updates leave the previous peers in place
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/", nil))
+	if got := rr.Header().Get("X-Peer"); got != "p2" {
+		t.Fatalf("after rejected update: want p2, got %q", got)
+	}
+}
+
+func TestGateway_Transcode(t *testing.T) {
+	// echo upstream: returns the request message with OK status
+	protos := new(http.Protocols)
+	protos.SetUnencryptedHTTP2(true)
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/echo.Echo/Say" || r.Header.Get("Content-Type") != "application/grpc" {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+		in, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = w.Write(in)
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	up.Config.Protocols = protos
+	up.Start()
+	defer up.Close()
+
+	// service Echo { rpc Say(Msg) returns (Msg); }, bound as POST /echo.Echo/Say
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("echo.proto"),
+		Package: proto.String("echo"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Msg"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("text"),
+				JsonName: proto.String("text"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Say"),
+				InputType:  proto.String(".echo.Msg"),
+				OutputType: proto.String(".echo.Msg"),
+			}},
+		}},
+	}}}
+	b, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := filepath.Join(t.TempDir(), "echo.pb")
+	if err := os.WriteFile(pb, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	svcs := map[string]config.Service{
+		"echo": {Name: "echo", Proto: "h2c", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	reg := transport.NewDefaultRegistry()
+	reg.Register("echo", reg.Get(transport.ProtoH2C))
+	rs := []config.Route{{Name: "rest", PathPrefix: "/", Service: "echo", Transcode: &config.Transcode{DescriptorSet: pb}}}
+	gw := NewGateway(mustRouter(t, rs), svcs, reg, 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	// descriptor problems surface when the routes are built
+	for _, bad := range []*config.Transcode{{DescriptorSet: pb, Services: []string{"echo.Missing"}}, {DescriptorSet: pb + ".missing"}} {
+		if _, err := NewRouter([]config.Route{{Name: "rest", PathPrefix: "/", Service: "echo", Transcode: bad}}); err == nil {
+			t.Errorf("%+v: want NewRouter error", *bad)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "http://gw.local/echo.Echo/Say", strings.NewReader(`{"text":"hi"}`))
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	if rr.Code != 200 || strings.TrimSpace(rr.Body.String()) != `{"text":"hi"}` {
+		t.Errorf("transcoded call: got %d %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content-type: got %q", ct)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://gw.local/nope", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unbound path: got %d, want 404", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("POST", "http://gw.local/echo.Echo/Say", strings.NewReader(`{"text":1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("bad body: got %d, want 400", rr.Code)
+	}
+}
+
+func TestGateway_HeaderPolicies(t *testing.T) {
+	var seen http.Header
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Server", "upstream/1.0")
+		w.Header().Set("X-Cache", "miss")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer up.Close()
+
+	policy := func(s headers.Spec) *headers.Policy {
+		p, err := headers.Compile(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	svcs := map[string]config.Service{
+		"s1": {
+			Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}},
+			RequestHeaders:  policy(headers.Spec{Set: map[string]string{"X-Service": "${service}", "X-Layer": "service"}}),
+			ResponseHeaders: policy(headers.Spec{Remove: []string{"Server"}}),
+		},
+	}
+	rs := []config.Route{{
+		Name: "r1", PathPrefix: "/", Service: "s1",
+		RequestHeaders: policy(headers.Spec{
+			Set:            map[string]string{"X-Layer": "route", "X-Client": "${client_ip} via ${route}"},
+			Remove:         []string{"X-Forwarded-Host"},
+			AppendIfAbsent: map[string]string{"X-Tenant": "none"},
+		}),
+		ResponseHeaders: policy(headers.Spec{Add: map[string]string{"X-Served-By": "${route}"}}),
+	}}
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	req := httptest.NewRequest("GET", "http://app.local/x", nil)
+	req.RemoteAddr = "203.0.113.10:54321"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+
+	for k, want := range map[string]string{
+		"X-Forwarded-For":   "198.51.100.1, 203.0.113.10",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "",
+		"X-Service":         "s1",
+		"X-Layer":           "route",
+		"X-Client":          "203.0.113.10 via r1",
+		"X-Tenant":          "none",
+	} {
+		if got := seen.Get(k); got != want {
+			t.Errorf("upstream %s: got %q, want %q", k, got, want)
+		}
+	}
+	if got := rr.Header().Get("Server"); got != "" {
+		t.Errorf("response Server: got %q, want removed", got)
+	}
+	if got := rr.Header().Get("X-Served-By"); got != "r1" {
+		t.Errorf("response X-Served-By: got %q, want r1", got)
+	}
+	if got := rr.Header().Get("X-Cache"); got != "miss" {
+		t.Errorf("response X-Cache: got %q, want miss", got)
+	}
+
+	// locally generated responses on the route get the response policy too
+	svcs["s1"] = config.Service{Name: "s1", Proto: "http1", ResponseHeaders: svcs["s1"].ResponseHeaders}
+	gw.UpdateState(mustRouter(t, rs), svcs, 0, config.AccessLogConfig{Sampling: 1.0})
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://app.local/x", nil))
+	if rr.Code != http.StatusBadGateway || rr.Header().Get("X-Served-By") != "r1" {
+		t.Errorf("error response: got %d X-Served-By=%q", rr.Code, rr.Header().Get("X-Served-By"))
+	}
+}
+
+func TestGateway_ServiceBalancerFollowsUpdates(t *testing.T) {
+	svcs := map[string]config.Service{
+		"tcp": {Name: "tcp", Proto: "tcp", Discovery: "consul"},
+	}
+	gw := NewGateway(mustRouter(t, nil), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	lb := gw.ServiceBalancer("tcp")
+	if ep := lb.Next(); ep != nil {
+		t.Fatalf("empty discovered service: want no endpoint, got %v", ep.URL())
+	}
+
+	if err := gw.UpdateEndpoints("tcp", []config.Endpoint{{URL: mustURL(t, "tcp://10.0.0.1:5432")}}); err != nil {
+		t.Fatalf("UpdateEndpoints: %v", err)
+	}
+	if ep := lb.Next(); ep == nil || ep.URL().Host != "10.0.0.1:5432" {
+		t.Fatalf("after discovery update: got %v", ep)
+	}
+
+	gw.UpdateState(mustRouter(t, nil), map[string]config.Service{}, 0, config.AccessLogConfig{Sampling: 1.0})
+	if ep := lb.Next(); ep != nil {
+		t.Fatalf("removed service: want no endpoint, got %v", ep.URL())
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes produced by the gateway itself.
+const (
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// isGRPC reports whether r is a native gRPC call (application/grpc or
+// application/grpc+<codec>); gRPC-Web is not included.
+func isGRPC(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/grpc") {
+		return false
+	}
+	rest := ct[len("application/grpc"):]
+	return rest == "" || rest[0] == '+' || rest[0] == ';'
+}
+
+// replyError answers a request the gateway could not proxy. gRPC clients get
+// HTTP 200 with grpc-status/grpc-message trailers (in the headers for
+// gRPC-Web), everyone else a plain-text HTTP error.
+func replyError(w *loggingResponseWriter, r *http.Request, status, code int, msg string) {
+	if isGRPCWeb(r) {
+		// Trailers-Only: gRPC-Web clients read the status from the headers
+		h := w.Header()
+		h.Set("Content-Type", contentTypeGRPCWeb)
+		h.Set("Grpc-Status", strconv.Itoa(code))
+		h.Set("Grpc-Message", encodeGRPCMessage(msg))
+		setGRPCWebCORS(h, r)
+		w.WriteHeader(http.StatusOK)
+		w.grpcStatus = strconv.Itoa(code)
+		return
+	}
+	if !isGRPC(r) {
+		if w.requestID != "" {
+			msg += "\nrequest_id: " + w.requestID
+		}
+		http.Error(w, msg, status)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", encodeGRPCMessage(msg))
+	w.grpcStatus = strconv.Itoa(code)
+}
+
+// grpcStatusOf returns the grpc-status of an upstream response, from its
+// trailers or, for Trailers-Only responses, its headers.
+func grpcStatusOf(res *http.Response) string {
+	if s := res.Trailer.Get("Grpc-Status"); s != "" {
+		return s
+	}
+	return res.Header.Get("Grpc-Status")
+}
+
+// encodeGRPCMessage percent-encodes s as required for grpc-message.
+func encodeGRPCMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func grpcRequest(path string) *http.Request {
+	req := httptest.NewRequest("POST", "http://grpc.local"+path, nil)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	return req
+}
+
+func TestGateway_GRPCErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := mustURL(t, down.URL)
+	down.Close()
+
+	svcs := map[string]config.Service{
+		"down": {Name: "down", Proto: "http1", Endpoints: []config.Endpoint{{URL: downURL}}},
+		"slow": {Name: "slow", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, slow.URL)}}},
+	}
+	rs := []config.Route{
+		{Name: "down", PathPrefix: "/down.Svc/", Service: "down"},
+		{Name: "slow", PathPrefix: "/slow.Svc/", Service: "slow"},
+		{Name: "limited", PathPrefix: "/limited.Svc/", Service: "slow",
+			RateLimit: &config.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1}},
+	}
+	var logBuf bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 50*time.Millisecond, &logBuf, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	// use up the single token
+	gw.ServeHTTP(httptest.NewRecorder(), grpcRequest("/limited.Svc/Call"))
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/nothing.Svc/Call", "12"}, // UNIMPLEMENTED
+		{"/down.Svc/Call", "14"},    // UNAVAILABLE
+		{"/slow.Svc/Call", "4"},     // DEADLINE_EXCEEDED
+		{"/limited.Svc/Call", "8"},  // RESOURCE_EXHAUSTED
+	}
+	for _, tc := range cases {
+		logBuf.Reset()
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, grpcRequest(tc.path))
+		res := rr.Result()
+		_ = res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status got %d, want 200", tc.path, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/grpc" {
+			t.Errorf("%s: content-type got %q, want application/grpc", tc.path, ct)
+		}
+		if got := res.Trailer.Get("Grpc-Status"); got != tc.want {
+			t.Errorf("%s: grpc-status got %q, want %s", tc.path, got, tc.want)
+		}
+		if res.Trailer.Get("Grpc-Message") == "" {
+			t.Errorf("%s: grpc-message is empty", tc.path)
+		}
+		var entry AccessLog
+		if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: unmarshal log: %v", tc.path, err)
+		}
+		if entry.GRPCStatus != tc.want {
+			t.Errorf("%s: log grpc_status got %q, want %s", tc.path, entry.GRPCStatus, tc.want)
+		}
+	}
+
+	// plain HTTP keeps the HTTP error
+	req := httptest.NewRequest("GET", "http://grpc.local/down.Svc/Call", nil)
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("http: status got %d, want 502", rr.Code)
+	}
+}
+
+func TestGateway_GRPCStatusLogged(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "5")
+	}))
+	defer up.Close()
+
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1"}}
+	var logBuf bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, &logBuf, config.AccessLogConfig{Sampling: 1.0, Fields: []string{"grpc_status"}}, nil)
+
+	gw.ServeHTTP(httptest.NewRecorder(), grpcRequest("/pkg.Svc/Get"))
+
+	var entry map[string]any
+	if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if entry["grpc_status"] != "5" {
+		t.Errorf("log grpc_status: got %v, want 5", entry["grpc_status"])
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"application/grpc":          true,
+		"application/grpc+proto":    true,
+		"application/grpc;charset":  true,
+		"application/grpc-web":      false,
+		"application/grpc-web+json": false,
+		"application/json":          false,
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Content-Type", ct)
+		if got := isGRPC(r); got != want {
+			t.Errorf("isGRPC(%q): got %v, want %v", ct, got, want)
+		}
+	}
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	if got := encodeGRPCMessage("50% off\n"); got != "50%25 off%0A" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// gRPC-Web content types; the -text variants carry base64 encoded frames.
+const (
+	contentTypeGRPCWeb     = "application/grpc-web"
+	contentTypeGRPCWebText = "application/grpc-web-text"
+)
+
+// grpcWebTrailerFlag marks the length-prefixed frame that carries trailers.
+const grpcWebTrailerFlag = 0x80
+
+// isGRPCWeb reports whether r is a gRPC-Web call (binary or text).
+func isGRPCWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeGRPCWeb)
+}
+
+// isGRPCWebPreflight reports whether r is a CORS preflight for a gRPC-Web call.
+func isGRPCWebPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// replyGRPCWebPreflight answers a CORS preflight for a gRPC-Web route. The
+// request origin and headers are reflected.
+func replyGRPCWebPreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	allow := r.Header.Get("Access-Control-Request-Headers")
+	if allow == "" {
+		allow = "content-type, x-grpc-web, x-user-agent, grpc-timeout"
+	}
+	h.Set("Access-Control-Allow-Headers", allow)
+	h.Set("Access-Control-Max-Age", "86400")
+	h.Add("Vary", "Origin")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setGRPCWebCORS adds the CORS headers of an actual gRPC-Web response.
+func setGRPCWebCORS(h http.Header, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "grpc-status, grpc-message")
+		h.Add("Vary", "Origin")
+	}
+}
+
+// grpcWeb translates one gRPC-Web call to native gRPC and back.
+type grpcWeb struct {
+	r    *http.Request
+	text bool   // base64 (grpc-web-text) framing
+	ct   string // content type of the client, echoed on the response
+}
+
+func newGRPCWeb(r *http.Request) *grpcWeb {
+	ct := r.Header.Get("Content-Type")
+	return &grpcWeb{r: r, text: strings.HasPrefix(ct, contentTypeGRPCWebText), ct: ct}
+}
+
+// upstreamBody returns the request body as native gRPC frames.
+func (g *grpcWeb) upstreamBody(body io.Reader) io.Reader {
+	if !g.text {
+		return body
+	}
+	return &base64QuantumReader{r: bufio.NewReader(body)}
+}
+
+// upstreamHeaders rewrites the request headers for a native gRPC upstream.
+func (g *grpcWeb) upstreamHeaders(h http.Header) {
+	suffix := strings.TrimPrefix(g.ct, contentTypeGRPCWebText)
+	if !g.text {
+		suffix = strings.TrimPrefix(g.ct, contentTypeGRPCWeb)
+	}
+	h.Set("Content-Type", "application/grpc"+suffix)
+	h.Set("TE", "trailers")
+	h.Del("Content-Length")
+	h.Del("X-Grpc-Web")
+}
+
+// writeResponse streams the upstream gRPC response as gRPC-Web: messages are
+// passed through (base64 encoded in text mode) and the trailers are appended
+// as a final frame. It returns the grpc-status seen.
+func (g *grpcWeb) writeResponse(lw *loggingResponseWriter, res *http.Response) string {
+	h := lw.Header()
+	copyHeaders(h, res.Header)
+	h.Set("Content-Type", g.responseContentType(res.Header.Get("Content-Type")))
+	h.Del("Content-Length")
+	setGRPCWebCORS(h, g.r)
+	lw.WriteHeader(res.StatusCode)
+	lw.Flush()
+
+	var out io.Writer = lw
+	if g.text {
+		out = base64ChunkWriter{lw}
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return ""
+			}
+			lw.Flush()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("grpc-web: read upstream body: %v", err)
+			return ""
+		}
+	}
+
+	if len(res.Trailer) > 0 {
+		if _, err := out.Write(grpcWebTrailerFrame(res.Trailer)); err != nil {
+			return ""
+		}
+		lw.Flush()
+	}
+	return grpcStatusOf(res)
+}
+
+func (g *grpcWeb) responseContentType(upstream string) string {
+	base := contentTypeGRPCWeb
+	if g.text {
+		base = contentTypeGRPCWebText
+	}
+	if suffix, ok := strings.CutPrefix(upstream, "application/grpc"); ok {
+		return base + suffix
+	}
+	return base
+}
+
+// grpcWebTrailerFrame encodes trailers as a gRPC-Web trailer frame with
+// lower-case "name: value" lines.
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var payload bytes.Buffer
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			payload.WriteString(strings.ToLower(k))
+			payload.WriteString(": ")
+			payload.WriteString(v)
+			payload.WriteString("\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	return append(frame, payload.Bytes()...)
+}
+
+// base64QuantumReader decodes grpc-web-text bodies. Clients may pad every
+// chunk they send, so each 4-byte quantum is decoded on its own.
+type base64QuantumReader struct {
+	r   *bufio.Reader
+	out []byte
+}
+
+func (b *base64QuantumReader) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		var q [4]byte
+		n := 0
+		for n < 4 {
+			c, err := b.r.ReadByte()
+			if err != nil {
+				if err == io.EOF && n > 0 {
+					return 0, io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			if c == '\r' || c == '\n' || c == ' ' {
+				continue
+			}
+			q[n] = c
+			n++
+		}
+		var dec [3]byte
+		m, err := base64.StdEncoding.Decode(dec[:], q[:])
+		if err != nil {
+			return 0, err
+		}
+		b.out = append(b.out, dec[:m]...)
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// base64ChunkWriter encodes every write as a self-contained padded base64
+// chunk, so a flushed chunk is decodable on its own.
+type base64ChunkWriter struct{ w io.Writer }
+
+func (b base64ChunkWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(b.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
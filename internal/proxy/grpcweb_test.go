@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func grpcFrame(flag byte, payload string) []byte {
+	f := make([]byte, 5, 5+len(payload))
+	f[0] = flag
+	binary.BigEndian.PutUint32(f[1:], uint32(len(payload)))
+	return append(f, payload...)
+}
+
+func newGRPCWebGateway(t *testing.T) *Gateway {
+	t.Helper()
+	protos := new(http.Protocols)
+	protos.SetUnencryptedHTTP2(true)
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc+proto" || r.Header.Get("TE") != "trailers" {
+			w.Header().Set("Grpc-Status", "13")
+			w.Header().Set("Grpc-Message", "bad upstream request: "+r.Proto+" "+r.Header.Get("Content-Type"))
+			return
+		}
+		in, _ := io.ReadAll(r.Body)
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Extra")
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		_, _ = w.Write(in) // echo the request message
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+		w.Header().Set("X-Extra", "1")
+	}))
+	up.Config.Protocols = protos
+	up.Start()
+	t.Cleanup(up.Close)
+
+	svcs := map[string]config.Service{
+		"echo": {Name: "echo", Proto: "h2c", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	reg := transport.NewDefaultRegistry()
+	reg.Register("echo", reg.Get(transport.ProtoH2C))
+	rs := []config.Route{{Name: "web", PathPrefix: "/", Service: "echo", GRPCWeb: true}}
+	return NewGateway(mustRouter(t, rs), svcs, reg, 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+}
+
+func TestGateway_GRPCWeb(t *testing.T) {
+	gw := newGRPCWebGateway(t)
+	msg := grpcFrame(0, "hello")
+	wantTrailer := string(grpcFrame(grpcWebTrailerFlag, "grpc-message: ok\r\ngrpc-status: 0\r\nx-extra: 1\r\n"))
+
+	t.Run("binary", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "http://gw.local/pkg.Echo/Say", bytes.NewReader(msg))
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		req.Header.Set("X-Grpc-Web", "1")
+		req.Header.Set("Origin", "https://app.example.com")
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, req)
+
+		if rr.Code != 200 {
+			t.Fatalf("status: got %d, want 200", rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/grpc-web+proto" {
+			t.Errorf("content-type: got %q", ct)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("allow-origin: got %q", got)
+		}
+		if got := rr.Header().Get("Trailer"); got != "" {
+			t.Errorf("HTTP trailers announced: %q", got)
+		}
+		if got, want := rr.Body.String(), string(msg)+wantTrailer; got != want {
+			t.Errorf("body: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		// clients may pad every chunk they send
+		body := base64.StdEncoding.EncodeToString(msg[:3]) + base64.StdEncoding.EncodeToString(msg[3:])
+		req := httptest.NewRequest("POST", "http://gw.local/pkg.Echo/Say", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/grpc-web-text+proto")
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/grpc-web-text+proto" {
+			t.Errorf("content-type: got %q", ct)
+		}
+		decoded, err := io.ReadAll(&base64QuantumReader{r: bufio.NewReader(rr.Body)})
+		if err != nil {
+			t.Fatalf("decode body %q: %v", rr.Body.String(), err)
+		}
+		if got, want := string(decoded), string(msg)+wantTrailer; got != want {
+			t.Errorf("body: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "http://gw.local/pkg.Echo/Say", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("status: got %d, want 204", rr.Code)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "content-type,x-grpc-web" {
+			t.Errorf("allow-headers: got %q", got)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+			t.Errorf("allow-methods: got %q", got)
+		}
+	})
+}
+
+func TestGateway_GRPCWebError(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := mustURL(t, down.URL)
+	down.Close()
+	svcs := map[string]config.Service{
+		"down": {Name: "down", Proto: "http1", Endpoints: []config.Endpoint{{URL: downURL}}},
+	}
+	rs := []config.Route{{Name: "web", PathPrefix: "/", Service: "down", GRPCWeb: true}}
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	req := httptest.NewRequest("POST", "http://gw.local/pkg.Echo/Say", bytes.NewReader(grpcFrame(0, "")))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+
+	if rr.Code != 200 || rr.Header().Get("Grpc-Status") != "14" {
+		t.Errorf("got %d grpc-status=%q, want 200 and 14", rr.Code, rr.Header().Get("Grpc-Status"))
+	}
+}
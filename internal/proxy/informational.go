@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// informational relays upstream 1xx responses (100 Continue, 103 Early Hints,
+// ...) to the client. It also owns the 100 Continue a client sending
+// "Expect: 100-continue" is waiting for, so that interim responses are written
+// one at a time and never after the final response.
+type informational struct {
+	w      *loggingResponseWriter
+	mu     sync.Mutex
+	allow  bool // client speaks HTTP/1.1 or later
+	expect bool // client still waits for 100 Continue
+	local  bool // answer 100-continue here instead of relaying the upstream's
+	done   bool // final response under way
+}
+
+func newInformational(w *loggingResponseWriter, r *http.Request, local bool) *informational {
+	allow := r.ProtoAtLeast(1, 1)
+	return &informational{
+		w:      w,
+		allow:  allow,
+		expect: allow && r.ContentLength != 0 && strings.EqualFold(r.Header.Get("Expect"), "100-continue"),
+		local:  local,
+	}
+}
+
+// trace hooks the upstream round trip.
+func (in *informational) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+			in.relay(code, http.Header(h))
+			return nil
+		},
+	}
+}
+
+// body wraps the client body so that 100 Continue is sent before the first
+// read: immediately in local mode, and in upstream mode once the transport
+// gives up waiting for the upstream's (ExpectContinueTimeout).
+func (in *informational) body(rc io.ReadCloser) io.ReadCloser {
+	if !in.expect {
+		return rc
+	}
+	return &continueReader{ReadCloser: rc, in: in}
+}
+
+// finish marks the start of the final response; later 1xx are dropped.
+func (in *informational) finish() {
+	in.mu.Lock()
+	in.done = true
+	in.mu.Unlock()
+}
+
+func (in *informational) relay(code int, h http.Header) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if !in.allow || in.done || code == http.StatusSwitchingProtocols {
+		return
+	}
+	if code == http.StatusContinue {
+		if in.local || !in.expect {
+			return
+		}
+		in.expect = false
+	}
+	in.write(code, h)
+}
+
+func (in *informational) sendContinue() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.expect && !in.done {
+		in.expect = false
+		in.write(http.StatusContinue, nil)
+	}
+}
+
+// write sends an interim response carrying only h; the header map is shared
+// with the final response, so it is restored afterwards.
+func (in *informational) write(code int, h http.Header) {
+	out := in.w.Header()
+	saved := out.Clone()
+	clear(out)
+	for k, vv := range h {
+		if !isHopByHop(k) {
+			out[k] = vv
+		}
+	}
+	in.w.ResponseWriter.WriteHeader(code)
+	clear(out)
+	maps.Copy(out, saved)
+}
+
+type continueReader struct {
+	io.ReadCloser
+	in   *informational
+	once sync.Once
+}
+
+func (c *continueReader) Read(p []byte) (int, error) {
+	c.once.Do(c.in.sendContinue)
+	return c.ReadCloser.Read(p)
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func newInformationalGateway(t *testing.T, expect string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	up := httptest.NewServer(handler)
+	t.Cleanup(up.Close)
+	svcs := map[string]config.Service{
+		"up": {Name: "up", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r", PathPrefix: "/", Service: "up", Expect: expect}}
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+	front := httptest.NewServer(gw)
+	t.Cleanup(front.Close)
+	return front
+}
+
+// interimRecorder collects the 1xx responses seen by a client.
+type interimRecorder struct {
+	mu    sync.Mutex
+	codes []int
+	hdrs  []textproto.MIMEHeader
+}
+
+func (ir *interimRecorder) do(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+		ir.mu.Lock()
+		defer ir.mu.Unlock()
+		ir.codes = append(ir.codes, code)
+		ir.hdrs = append(ir.hdrs, h)
+		return nil
+	}}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	tr := &http.Transport{ExpectContinueTimeout: 5 * time.Second}
+	defer tr.CloseIdleConnections()
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	return res
+}
+
+func TestGateway_EarlyHints(t *testing.T) {
+	front := newInformationalGateway(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.Header().Add("Link", "</app.js>; rel=preload; as=script")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html></html>")
+	})
+
+	var ir interimRecorder
+	req, _ := http.NewRequest("GET", front.URL+"/", nil)
+	res := ir.do(t, req)
+	defer func() { _ = res.Body.Close() }()
+	body, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != 200 || string(body) != "<html></html>" {
+		t.Fatalf("final: got %d %q", res.StatusCode, body)
+	}
+	if len(ir.codes) != 1 || ir.codes[0] != http.StatusEarlyHints {
+		t.Fatalf("interim codes: got %v, want [103]", ir.codes)
+	}
+	if links := ir.hdrs[0]["Link"]; len(links) != 2 || !strings.Contains(links[1], "app.js") {
+		t.Errorf("103 Link headers: got %v", links)
+	}
+	if links := res.Header.Values("Link"); len(links) != 0 {
+		t.Errorf("final response leaked early hints: %v", links)
+	}
+}
+
+func TestGateway_ExpectContinue(t *testing.T) {
+	upload := func(t *testing.T, front *httptest.Server, path string) (*http.Response, *interimRecorder, *bool) {
+		t.Helper()
+		sent := new(bool)
+		body := io.MultiReader(strings.NewReader("payload"), readFunc(func([]byte) (int, error) {
+			*sent = true
+			return 0, io.EOF
+		}))
+		req, _ := http.NewRequest("PUT", front.URL+path, body)
+		req.ContentLength = 7
+		req.Header.Set("Expect", "100-continue")
+		ir := new(interimRecorder)
+		return ir.do(t, req), ir, sent
+	}
+
+	t.Run("upstream_accepts", func(t *testing.T) {
+		front := newInformationalGateway(t, "upstream", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Expect") != "100-continue" {
+				t.Errorf("upstream Expect: got %q", r.Header.Get("Expect"))
+			}
+			b, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(b)
+		})
+		res, ir, _ := upload(t, front, "/")
+		defer func() { _ = res.Body.Close() }()
+		b, _ := io.ReadAll(res.Body)
+		if res.StatusCode != 200 || string(b) != "payload" {
+			t.Fatalf("final: got %d %q", res.StatusCode, b)
+		}
+		if len(ir.codes) != 1 || ir.codes[0] != http.StatusContinue {
+			t.Errorf("interim codes: got %v, want [100]", ir.codes)
+		}
+	})
+
+	t.Run("upstream_rejects", func(t *testing.T) {
+		front := newInformationalGateway(t, "upstream", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+		})
+		res, ir, sent := upload(t, front, "/")
+		defer func() { _ = res.Body.Close() }()
+		if res.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Fatalf("status: got %d, want 413", res.StatusCode)
+		}
+		if len(ir.codes) != 0 {
+			t.Errorf("interim codes: got %v, want none", ir.codes)
+		}
+		if *sent {
+			t.Error("client body was sent although the upstream rejected it")
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		front := newInformationalGateway(t, "local", func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Expect"); got != "" {
+				t.Errorf("upstream Expect: got %q, want none", got)
+			}
+			b, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(b)
+		})
+		res, ir, _ := upload(t, front, "/")
+		defer func() { _ = res.Body.Close() }()
+		b, _ := io.ReadAll(res.Body)
+		if res.StatusCode != 200 || string(b) != "payload" {
+			t.Fatalf("final: got %d %q", res.StatusCode, b)
+		}
+		if len(ir.codes) != 1 || ir.codes[0] != http.StatusContinue {
+			t.Errorf("interim codes: got %v, want [100]", ir.codes)
+		}
+	})
+}
+
+type readFunc func([]byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
@@ -1,7 +1,11 @@
 package proxy
 
 import (
+	"math"
+	"math/rand"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,25 +18,141 @@ type Balancer interface {
 
 type Endpoint interface {
 	URL() *url.URL
-	Feedback(success bool)
+	// Feedback reports the outcome and round-trip latency (0 if unknown) of a request.
+	Feedback(success bool, latency time.Duration)
 }
 
-type smoothWRR struct {
-	mu    sync.Mutex
-	peers []*peer
+// PeerState is the administrative state of a peer.
+type PeerState string
+
+const (
+	PeerActive   PeerState = "active"   // eligible for new requests
+	PeerDraining PeerState = "draining" // no new requests; in-flight ones finish
+)
+
+// PeerOverride is a runtime adjustment of a single peer, set via the admin API.
+type PeerOverride struct {
+	State  PeerState `json:"state,omitempty"`  // "" keeps the peer active
+	Weight int       `json:"weight,omitempty"` // 0 keeps the configured weight
+}
+
+// PeerUpdate changes a peer's override via the admin API; nil fields keep
+// their current value.
+type PeerUpdate struct {
+	State  *PeerState `json:"state"`  // "active" clears a drain
+	Weight *int       `json:"weight"` // 0 restores the configured weight
+}
+
+// PeerStatus is a point-in-time view of a peer.
+type PeerStatus struct {
+	URL        string    `json:"url"`
+	Weight     int       `json:"weight"` // effective weight
+	Configured int       `json:"configured_weight"`
+	Priority   int       `json:"priority"`
+	Zone       string    `json:"zone,omitempty"`
+	State      PeerState `json:"state"`
+	Fails      int       `json:"fails"`
+	SkipUntil  time.Time `json:"skip_until,omitzero"`
+	InFlight   int       `json:"in_flight"`
+	LatencyMS  float64   `json:"latency_ewma_ms,omitempty"` // peak_ewma only
+}
+
+// peerAdmin is implemented by balancers that support runtime inspection and overrides.
+type peerAdmin interface {
+	Peers() []PeerStatus
+	// SetOverrides replaces all overrides, keyed by endpoint URL.
+	SetOverrides(map[string]PeerOverride)
+}
+
+// subsetter is implemented by balancers that can restrict selection to peers
+// whose labels match a selector. Subset returns nil if no peer matches.
+type subsetter interface {
+	Subset(selector map[string]string) Balancer
+}
+
+// Load balancing policies.
+const (
+	PolicyWRR      = "wrr"
+	PolicyPeakEWMA = "peak_ewma"
+)
+
+// ewmaFailurePenalty is the latency sample recorded for a failed request, so a
+// fast-failing peer does not look attractive to peak_ewma.
+const ewmaFailurePenalty = time.Second
+
+// peerBalancer holds the peers of a service and applies admin state, passive
+// health, priority groups, zones and subsets before its policy picks a peer.
+type peerBalancer struct {
+	mu     *sync.Mutex // shared with subset views
+	policy string
+	decay  time.Duration
+	peers  []*peer
+	// groups holds the peers split by priority, highest priority (lowest value) first.
+	groups    [][]*peer
+	threshold float64
+	localZone string
+	zoneShare float64
+	rand      func() float64
+	// subsets caches non-empty subset views by selector key; nil on views.
+	subsets map[string]*peerBalancer
+	// current is the smooth WRR state of this balancer or view, so picks
+	// through one view do not shift the rotation of another.
+	current map[*peer]int
 }
 
 type peer struct {
-	url           *url.URL
-	weight        int
-	currentWeight int
+	url      *url.URL
+	weight   int
+	priority int
+	zone     string
+	labels   map[string]string
+	override PeerOverride
+	inflight int
+
+	// Latency estimate for peak_ewma, in nanoseconds
+	ewma       float64
+	lastSample time.Time
 
 	// Passive health
 	fails     int
 	skipUntil time.Time
 }
 
+func (p *peer) effectiveWeight() int {
+	if p.override.Weight > 0 {
+		return p.override.Weight
+	}
+	return p.weight
+}
+
+func (p *peer) state() PeerState {
+	if p.override.State == "" {
+		return PeerActive
+	}
+	return p.override.State
+}
+
+func (p *peer) healthy(now time.Time) bool {
+	return p.skipUntil.IsZero() || !now.Before(p.skipUntil)
+}
+
 func NewSmoothWRR(endpoints []config.Endpoint) Balancer {
+	return newPeerBalancer(endpoints, config.LoadBalancing{Policy: PolicyWRR})
+}
+
+// NewPeakEWMA builds a latency-aware balancer: it keeps a decaying per-peer
+// latency estimate that jumps to new peaks, multiplies it by outstanding
+// requests, and picks the cheaper of two random peers.
+func NewPeakEWMA(endpoints []config.Endpoint) Balancer {
+	return newPeerBalancer(endpoints, config.LoadBalancing{Policy: PolicyPeakEWMA})
+}
+
+// NewServiceBalancer builds the balancer of a service according to its lb settings.
+func NewServiceBalancer(svc config.Service) Balancer {
+	return newPeerBalancer(svc.Endpoints, svc.LB)
+}
+
+func newPeerBalancer(endpoints []config.Endpoint, lb config.LoadBalancing) *peerBalancer {
 	peers := make([]*peer, len(endpoints))
 	for i, e := range endpoints {
 		w := e.Weight
@@ -40,34 +160,136 @@ func NewSmoothWRR(endpoints []config.Endpoint) Balancer {
 			w = 1
 		}
 		peers[i] = &peer{
-			url:    e.URL,
-			weight: w,
+			url:      e.URL,
+			weight:   w,
+			priority: e.Priority,
+			zone:     e.Zone,
+			labels:   e.Labels,
+		}
+	}
+
+	threshold := lb.FailoverThreshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = config.DefaultFailoverThreshold
+	}
+	policy := lb.Policy
+	if policy != PolicyPeakEWMA {
+		policy = PolicyWRR
+	}
+	decay := lb.EWMADecay
+	if decay <= 0 {
+		decay = config.DefaultEWMADecay
+	}
+	return &peerBalancer{
+		mu:        new(sync.Mutex),
+		policy:    policy,
+		decay:     decay,
+		peers:     peers,
+		groups:    groupByPriority(peers),
+		threshold: threshold,
+		localZone: lb.LocalZone,
+		zoneShare: lb.ZoneTrafficShare,
+		rand:      rand.Float64,
+		subsets:   make(map[string]*peerBalancer),
+		current:   make(map[*peer]int, len(peers)),
+	}
+}
+
+// groupByPriority splits peers by priority, highest priority (lowest value) first.
+func groupByPriority(peers []*peer) [][]*peer {
+	byPriority := make(map[int][]*peer)
+	var levels []int
+	for _, p := range peers {
+		if _, ok := byPriority[p.priority]; !ok {
+			levels = append(levels, p.priority)
 		}
+		byPriority[p.priority] = append(byPriority[p.priority], p)
 	}
-	return &smoothWRR{peers: peers}
+	sort.Ints(levels)
+	groups := make([][]*peer, len(levels))
+	for i, lvl := range levels {
+		groups[i] = byPriority[lvl]
+	}
+	return groups
 }
 
-func (b *smoothWRR) Next() Endpoint {
+// Subset returns a view over the peers matching selector. Views share peers,
+// health and overrides with the parent balancer but keep their own WRR state.
+func (b *peerBalancer) Subset(selector map[string]string) Balancer {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	now := time.Now()
-	var best *peer
-	total := 0
-
+	key := selectorKey(selector)
+	if v, ok := b.subsets[key]; ok {
+		return v
+	}
+	var matched []*peer
 	for _, p := range b.peers {
-		// Skip unhealthy peers
-		if !p.skipUntil.IsZero() && now.Before(p.skipUntil) {
-			continue
+		if p.matches(selector) {
+			matched = append(matched, p)
 		}
-		// If probe time passed, treat as candidate (maybe reset fails? or just try once)
+	}
+	if len(matched) == 0 {
+		// not cached: selectors may come from request headers
+		return nil
+	}
+	v := &peerBalancer{
+		mu:        b.mu,
+		policy:    b.policy,
+		decay:     b.decay,
+		peers:     matched,
+		groups:    groupByPriority(matched),
+		threshold: b.threshold,
+		localZone: b.localZone,
+		zoneShare: b.zoneShare,
+		rand:      b.rand,
+		current:   make(map[*peer]int, len(matched)),
+	}
+	if b.subsets != nil {
+		b.subsets[key] = v
+	}
+	return v
+}
 
-		p.currentWeight += p.weight
-		total += p.weight
-		if best == nil || p.currentWeight > best.currentWeight {
-			best = p
+func (p *peer) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := p.labels[k]; !ok || lv != v {
+			return false
 		}
 	}
+	return true
+}
+
+func selectorKey(selector map[string]string) string {
+	keys := make([]string, 0, len(selector))
+	for k := range selector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(selector[k])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+func (b *peerBalancer) Next() Endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	group, available := b.pickGroup(now)
+	candidates := b.preferZone(group, available)
+
+	var best *peer
+	if b.policy == PolicyPeakEWMA {
+		best = b.pickP2C(candidates)
+	} else {
+		best = b.pickWRR(candidates)
+	}
 
 	if best == nil {
 		// All skipped? Fallback to random or just return nil?
@@ -78,23 +300,249 @@ func (b *smoothWRR) Next() Endpoint {
 		return nil
 	}
 
-	best.currentWeight -= total
+	best.inflight++
 	return &peerEndpoint{p: best, b: b}
 }
 
+// pickWRR is Nginx-style smooth weighted round robin.
+func (b *peerBalancer) pickWRR(candidates []*peer) *peer {
+	var best *peer
+	total := 0
+	for _, p := range candidates {
+		w := p.effectiveWeight()
+		b.current[p] += w
+		total += w
+		if best == nil || b.current[p] > b.current[best] {
+			best = p
+		}
+	}
+	if best != nil {
+		b.current[best] -= total
+	}
+	return best
+}
+
+// pickP2C draws two distinct candidates at random and keeps the cheaper one.
+func (b *peerBalancer) pickP2C(candidates []*peer) *peer {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+	n := len(candidates)
+	i := min(int(b.rand()*float64(n)), n-1)
+	j := min(int(b.rand()*float64(n-1)), n-2)
+	if j >= i {
+		j++
+	}
+	a, c := candidates[i], candidates[j]
+	if c.cost() < a.cost() {
+		return c
+	}
+	return a
+}
+
+// cost is the latency estimate scaled by outstanding requests and weight. The
+// +1ns keeps outstanding requests significant for peers without samples yet.
+func (p *peer) cost() float64 {
+	return (p.ewma + 1) * float64(p.inflight+1) / float64(p.effectiveWeight())
+}
+
+// observe folds a latency sample into the peak EWMA: peaks are taken as-is,
+// lower samples decay the estimate with time constant decay.
+func (p *peer) observe(rtt time.Duration, decay time.Duration, now time.Time) {
+	sample := float64(rtt)
+	switch {
+	case p.lastSample.IsZero(), sample > p.ewma:
+		p.ewma = sample
+	default:
+		w := math.Exp(-float64(now.Sub(p.lastSample)) / float64(decay))
+		p.ewma = p.ewma*w + sample*(1-w)
+	}
+	p.lastSample = now
+}
+
+// pickGroup chooses a priority group and returns it along with its available peers.
+//
+// Each group gets a health score of min(1, healthyFraction/threshold). Load is
+// assigned top-down: the highest priority group takes its health share, the next
+// group takes what is left up to its own health, and so on. When the groups
+// together are less than fully healthy, shares are normalized so traffic is still
+// served by whatever capacity remains.
+func (b *peerBalancer) pickGroup(now time.Time) (group, available []*peer) {
+	if len(b.groups) == 0 {
+		return nil, nil
+	}
+
+	avail := make([][]*peer, len(b.groups))
+	health := make([]float64, len(b.groups))
+	sum := 0.0
+	for i, g := range b.groups {
+		active := 0
+		for _, p := range g {
+			// drained peers are out of rotation and don't count against health
+			if p.state() != PeerActive {
+				continue
+			}
+			active++
+			if p.healthy(now) {
+				avail[i] = append(avail[i], p)
+			}
+		}
+		if active > 0 {
+			health[i] = min(1, float64(len(avail[i]))/float64(active)/b.threshold)
+		}
+		sum += health[i]
+	}
+	if sum == 0 {
+		return nil, nil
+	}
+
+	load := make([]float64, len(b.groups))
+	if sum < 1 {
+		for i, h := range health {
+			load[i] = h / sum
+		}
+	} else {
+		remaining := 1.0
+		for i, h := range health {
+			load[i] = min(remaining, h)
+			remaining -= load[i]
+		}
+	}
+
+	// skip the random draw in the common case of a single loaded group
+	for i, l := range load {
+		if l >= 1 {
+			return b.groups[i], avail[i]
+		}
+	}
+	r := b.rand()
+	for i, l := range load {
+		if r < l {
+			return b.groups[i], avail[i]
+		}
+		r -= l
+	}
+	for i := len(load) - 1; i >= 0; i-- {
+		if load[i] > 0 {
+			return b.groups[i], avail[i]
+		}
+	}
+	return nil, nil
+}
+
+// preferZone narrows the available peers of a group to the gateway's own zone.
+//
+// The local zone's share of traffic follows the same health rule as priority
+// groups, min(1, healthyFraction/threshold), and is further scaled down when the
+// local zone holds less healthy weight than its expected traffic share. The rest
+// spills over to peers in other zones. Unless zone_traffic_share says otherwise,
+// gateways are assumed to be spread evenly over the zones of the group, so each
+// zone is expected to originate 1/zones of the traffic (as Envoy does with equal
+// origin distribution).
+func (b *peerBalancer) preferZone(group, available []*peer) []*peer {
+	if b.localZone == "" || len(available) == 0 {
+		return available
+	}
+
+	var local, remote []*peer
+	localWeight, totalWeight := 0, 0
+	for _, p := range available {
+		w := p.effectiveWeight()
+		totalWeight += w
+		if p.zone == b.localZone {
+			local = append(local, p)
+			localWeight += w
+		} else {
+			remote = append(remote, p)
+		}
+	}
+	if len(local) == 0 || len(remote) == 0 {
+		return available
+	}
+
+	localActive := 0
+	zones := make(map[string]bool)
+	for _, p := range group {
+		if p.state() != PeerActive {
+			continue
+		}
+		zones[p.zone] = true
+		if p.zone == b.localZone {
+			localActive++
+		}
+	}
+	expected := b.zoneShare
+	if expected == 0 {
+		expected = 1 / float64(len(zones))
+	}
+	share := min(1, float64(len(local))/float64(localActive)/b.threshold)
+	share *= min(1, float64(localWeight)/float64(totalWeight)/expected)
+
+	if share >= 1 || b.rand() < share {
+		return local
+	}
+	return remote
+}
+
+func (b *peerBalancer) Peers() []PeerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make([]PeerStatus, 0, len(b.peers))
+	for _, p := range b.peers {
+		out = append(out, PeerStatus{
+			URL:        p.url.String(),
+			Weight:     p.effectiveWeight(),
+			Configured: p.weight,
+			Priority:   p.priority,
+			Zone:       p.zone,
+			State:      p.state(),
+			Fails:      p.fails,
+			SkipUntil:  p.skipUntil,
+			InFlight:   p.inflight,
+			LatencyMS:  p.ewma / float64(time.Millisecond),
+		})
+	}
+	return out
+}
+
+func (b *peerBalancer) SetOverrides(overrides map[string]PeerOverride) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.peers {
+		p.override = overrides[p.url.String()]
+	}
+}
+
 type peerEndpoint struct {
 	p *peer
-	b *smoothWRR
+	b *peerBalancer
 }
 
 func (e *peerEndpoint) URL() *url.URL {
 	return e.p.url
 }
 
-func (e *peerEndpoint) Feedback(success bool) {
+// Feedback reports the outcome of a request and releases its in-flight slot.
+// It must be called exactly once per Next.
+func (e *peerEndpoint) Feedback(success bool, latency time.Duration) {
 	e.b.mu.Lock()
 	defer e.b.mu.Unlock()
 
+	if e.p.inflight > 0 {
+		e.p.inflight--
+	}
+	if e.b.policy == PolicyPeakEWMA {
+		if !success {
+			latency = max(latency, ewmaFailurePenalty)
+		}
+		if latency > 0 {
+			e.p.observe(latency, e.b.decay, time.Now())
+		}
+	}
 	if success {
 		e.p.fails = 0
 		e.p.skipUntil = time.Time{}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/fabian4/gateway-homebrew-go/internal/config"
 )
@@ -52,6 +53,25 @@ func TestSmoothWRR_Single(t *testing.T) {
 	}
 }
 
+func TestSmoothWRR_SubsetOwnState(t *testing.T) {
+	u := func(s string) *url.URL { v, _ := url.Parse(s); return v }
+	lb := NewSmoothWRR([]config.Endpoint{
+		{URL: u("http://a"), Weight: 3, Labels: map[string]string{"v": "1"}},
+		{URL: u("http://b"), Weight: 1, Labels: map[string]string{"v": "1"}},
+		{URL: u("http://c"), Weight: 1, Labels: map[string]string{"v": "2"}},
+	}).(*peerBalancer)
+	v1, v2 := lb.Subset(map[string]string{"v": "1"}), lb.Subset(map[string]string{"v": "2"})
+
+	// picks through the parent and another view leave v1's rotation intact
+	for i, want := range []string{"a", "a", "b", "a", "a", "a", "b", "a"} {
+		lb.Next()
+		v2.Next()
+		if got := v1.Next().URL().Host; got != want {
+			t.Errorf("step %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
 func TestSmoothWRR_PassiveHealth(t *testing.T) {
 	u1, _ := url.Parse("http://a")
 	u2, _ := url.Parse("http://b")
@@ -70,32 +90,32 @@ func TestSmoothWRR_PassiveHealth(t *testing.T) {
 	if ep1.URL().Host != "a" {
 		t.Fatalf("want a, got %s", ep1.URL().Host)
 	}
-	ep1.Feedback(false)
+	ep1.Feedback(false, 0)
 
 	// 2. Get B -> OK
 	ep2 := lb.Next()
 	if ep2.URL().Host != "b" {
 		t.Fatalf("want b, got %s", ep2.URL().Host)
 	}
-	ep2.Feedback(true)
+	ep2.Feedback(true, 0)
 
 	// 3. Get A -> Fail
 	ep3 := lb.Next()
 	if ep3.URL().Host != "a" {
 		t.Fatalf("want a, got %s", ep3.URL().Host)
 	}
-	ep3.Feedback(false)
+	ep3.Feedback(false, 0)
 
 	// 4. Get B -> OK
 	ep4 := lb.Next()
-	ep4.Feedback(true)
+	ep4.Feedback(true, 0)
 
 	// 5. Get A -> Fail (3rd strike)
 	ep5 := lb.Next()
 	if ep5.URL().Host != "a" {
 		t.Fatalf("want a, got %s", ep5.URL().Host)
 	}
-	ep5.Feedback(false)
+	ep5.Feedback(false, 0)
 
 	// Now 'a' should be skipped for 10s
 	for i := 0; i < 5; i++ {
@@ -105,3 +125,239 @@ func TestSmoothWRR_PassiveHealth(t *testing.T) {
 		}
 	}
 }
+
+func TestSmoothWRR_Overrides(t *testing.T) {
+	u1, _ := url.Parse("http://a")
+	u2, _ := url.Parse("http://b")
+	lb := NewSmoothWRR([]config.Endpoint{{URL: u1, Weight: 1}, {URL: u2, Weight: 1}}).(*peerBalancer)
+
+	// draining 'a' keeps it out of rotation while its in-flight request finishes
+	inflight := lb.Next()
+	if inflight.URL().Host != "a" {
+		t.Fatalf("want a, got %s", inflight.URL().Host)
+	}
+	lb.SetOverrides(map[string]PeerOverride{"http://a": {State: PeerDraining}})
+	for i := 0; i < 4; i++ {
+		ep := lb.Next()
+		if ep.URL().Host != "b" {
+			t.Fatalf("iteration %d: draining peer selected", i)
+		}
+		ep.Feedback(true, 0)
+	}
+	if st := lb.Peers()[0]; st.State != PeerDraining || st.InFlight != 1 {
+		t.Fatalf("peer a status: %+v", st)
+	}
+	inflight.Feedback(true, 0)
+	if st := lb.Peers()[0]; st.InFlight != 0 {
+		t.Fatalf("in-flight not released: %+v", st)
+	}
+
+	// weight override 3:1
+	lb.SetOverrides(map[string]PeerOverride{"http://a": {Weight: 3}})
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		ep := lb.Next()
+		counts[ep.URL().Host]++
+		ep.Feedback(true, 0)
+	}
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Fatalf("weight override: got %v, want a=6 b=2", counts)
+	}
+	if st := lb.Peers()[0]; st.Weight != 3 || st.Configured != 1 {
+		t.Fatalf("peer a weights: %+v", st)
+	}
+}
+
+func TestSmoothWRR_PriorityFailover(t *testing.T) {
+	u1, _ := url.Parse("http://primary-1")
+	u2, _ := url.Parse("http://primary-2")
+	u3, _ := url.Parse("http://standby")
+	lb := newPeerBalancer([]config.Endpoint{
+		{URL: u1, Weight: 1},
+		{URL: u2, Weight: 1},
+		{URL: u3, Weight: 1, Priority: 1},
+	}, config.LoadBalancing{FailoverThreshold: 0.7})
+	draw := 0.0
+	lb.rand = func() float64 { return draw }
+
+	// healthy primary group takes all traffic
+	for i := 0; i < 6; i++ {
+		ep := lb.Next()
+		if ep.URL().Host == "standby" {
+			t.Fatalf("iteration %d: standby selected while primary healthy", i)
+		}
+		ep.Feedback(true, 0)
+	}
+
+	// primary-1 unhealthy: primary health = 0.5/0.7 ~= 0.714, standby gets the rest
+	lb.peers[0].skipUntil = time.Now().Add(time.Minute)
+	draw = 0.5
+	if got := lb.Next().URL().Host; got != "primary-2" {
+		t.Fatalf("draw 0.5: got %s, want primary-2", got)
+	}
+	draw = 0.8
+	if got := lb.Next().URL().Host; got != "standby" {
+		t.Fatalf("draw 0.8: got %s, want standby", got)
+	}
+
+	// whole primary group down: standby takes everything
+	lb.peers[1].skipUntil = time.Now().Add(time.Minute)
+	draw = 0.0
+	for i := 0; i < 3; i++ {
+		if got := lb.Next().URL().Host; got != "standby" {
+			t.Fatalf("primary down: got %s, want standby", got)
+		}
+	}
+
+	// drained primaries don't count against group health
+	lb.peers[0].skipUntil = time.Time{}
+	lb.peers[1].skipUntil = time.Time{}
+	lb.SetOverrides(map[string]PeerOverride{"http://primary-2": {State: PeerDraining}})
+	draw = 0.99
+	if got := lb.Next().URL().Host; got != "primary-1" {
+		t.Fatalf("drained peer: got %s, want primary-1", got)
+	}
+}
+
+func TestSmoothWRR_ZoneAware(t *testing.T) {
+	ua1, _ := url.Parse("http://a1")
+	ua2, _ := url.Parse("http://a2")
+	ub1, _ := url.Parse("http://b1")
+	lb := newPeerBalancer([]config.Endpoint{
+		{URL: ua1, Zone: "a"},
+		{URL: ua2, Zone: "a"},
+		{URL: ub1, Zone: "b"},
+	}, config.LoadBalancing{FailoverThreshold: 0.7, LocalZone: "a"})
+	draw := 0.0
+	lb.rand = func() float64 { return draw }
+
+	// healthy local zone takes all traffic
+	for i := 0; i < 6; i++ {
+		if got := lb.Next().URL().Host; got == "b1" {
+			t.Fatalf("iteration %d: remote zone selected while local healthy", i)
+		}
+	}
+
+	// one local peer unhealthy: local share = 0.5/0.7 ~= 0.714
+	lb.peers[0].skipUntil = time.Now().Add(time.Minute)
+	draw = 0.5
+	if got := lb.Next().URL().Host; got != "a2" {
+		t.Fatalf("draw 0.5: got %s, want a2", got)
+	}
+	draw = 0.8
+	if got := lb.Next().URL().Host; got != "b1" {
+		t.Fatalf("draw 0.8: got %s, want b1", got)
+	}
+
+	// local zone fully down: fall back to other zones
+	lb.peers[1].skipUntil = time.Now().Add(time.Minute)
+	draw = 0.0
+	if got := lb.Next().URL().Host; got != "b1" {
+		t.Fatalf("local down: got %s, want b1", got)
+	}
+}
+
+func TestSmoothWRR_ZoneTrafficShare(t *testing.T) {
+	ua, _ := url.Parse("http://a")
+	ub1, _ := url.Parse("http://b1")
+	ub2, _ := url.Parse("http://b2")
+	// local zone holds 1/3 of the weight but expects half the traffic:
+	// it keeps (1/3)/0.5 ~= 0.667 and spills the rest
+	lb := newPeerBalancer([]config.Endpoint{
+		{URL: ua, Zone: "a"},
+		{URL: ub1, Zone: "b"},
+		{URL: ub2, Zone: "b"},
+	}, config.LoadBalancing{LocalZone: "a", ZoneTrafficShare: 0.5})
+	draw := 0.6
+	lb.rand = func() float64 { return draw }
+	if got := lb.Next().URL().Host; got != "a" {
+		t.Fatalf("draw 0.6: got %s, want a", got)
+	}
+	draw = 0.7
+	if got := lb.Next().URL().Host; got == "a" {
+		t.Fatalf("draw 0.7: got a, want remote zone")
+	}
+}
+
+func TestSmoothWRR_ZoneDefaultShare(t *testing.T) {
+	// one local peer of five in two zones: the local zone holds 1/5 of the weight
+	// against an expected 1/2 of the traffic, so it keeps (1/5)/(1/2) = 0.4
+	eps := []config.Endpoint{{URL: &url.URL{Scheme: "http", Host: "a"}, Zone: "a"}}
+	for _, h := range []string{"b1", "b2", "b3", "b4"} {
+		eps = append(eps, config.Endpoint{URL: &url.URL{Scheme: "http", Host: h}, Zone: "b"})
+	}
+	lb := newPeerBalancer(eps, config.LoadBalancing{FailoverThreshold: 0.7, LocalZone: "a"})
+	draw := 0.39
+	lb.rand = func() float64 { return draw }
+	if got := lb.Next().URL().Host; got != "a" {
+		t.Fatalf("draw 0.39: got %s, want a", got)
+	}
+	draw = 0.41
+	if got := lb.Next().URL().Host; got == "a" {
+		t.Fatalf("draw 0.41: got a, want remote zone")
+	}
+}
+
+func TestPeakEWMA_PrefersFasterPeer(t *testing.T) {
+	ua, _ := url.Parse("http://fast")
+	ub, _ := url.Parse("http://slow")
+	lb := NewPeakEWMA([]config.Endpoint{{URL: ua}, {URL: ub}}).(*peerBalancer)
+
+	// seed latencies
+	for _, want := range []string{"fast", "slow"} {
+		for {
+			ep := lb.Next()
+			if ep.URL().Host != want {
+				ep.Feedback(true, 0)
+				continue
+			}
+			lat := 5 * time.Millisecond
+			if want == "slow" {
+				lat = 200 * time.Millisecond
+			}
+			ep.Feedback(true, lat)
+			break
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		ep := lb.Next()
+		if got := ep.URL().Host; got != "fast" {
+			t.Fatalf("pick %d: got %s, want fast", i, got)
+		}
+		ep.Feedback(true, 5*time.Millisecond)
+	}
+}
+
+func TestPeakEWMA_OutstandingRaisesCost(t *testing.T) {
+	ua, _ := url.Parse("http://a")
+	ub, _ := url.Parse("http://b")
+	lb := newPeerBalancer([]config.Endpoint{{URL: ua}, {URL: ub}},
+		config.LoadBalancing{Policy: PolicyPeakEWMA})
+	for _, p := range lb.peers {
+		p.observe(10*time.Millisecond, lb.decay, time.Now())
+	}
+
+	// with equal latency, each new pick goes to the peer with fewer in flight
+	first := lb.Next()
+	second := lb.Next()
+	if first.URL().Host == second.URL().Host {
+		t.Fatalf("both picks went to %s, want spread by outstanding requests", first.URL().Host)
+	}
+	first.Feedback(true, 10*time.Millisecond)
+	second.Feedback(true, 10*time.Millisecond)
+}
+
+func TestPeakEWMA_Decay(t *testing.T) {
+	p := &peer{}
+	now := time.Now()
+	p.observe(100*time.Millisecond, 10*time.Second, now)
+	p.observe(300*time.Millisecond, 10*time.Second, now.Add(time.Second))
+	if p.ewma != float64(300*time.Millisecond) {
+		t.Fatalf("peak: got %v, want 300ms", time.Duration(p.ewma))
+	}
+	p.observe(10*time.Millisecond, 10*time.Second, now.Add(time.Minute))
+	if got := time.Duration(p.ewma); got > 15*time.Millisecond {
+		t.Fatalf("decayed: got %v, want close to 10ms", got)
+	}
+}
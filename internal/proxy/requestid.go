@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"golang.org/x/net/http/httpguts"
+)
+
+// maxRequestIDLen bounds accepted client IDs; longer ones are replaced so that
+// a client cannot bloat every log line and upstream request.
+const maxRequestIDLen = 128
+
+// requestID returns the request's correlation ID and the header carrying it:
+// the client's value if it is usable, else a freshly generated one.
+func requestID(r *http.Request, c config.RequestIDConfig) (id, header string) {
+	header = c.Header
+	if header == "" {
+		header = config.DefaultRequestIDHeader
+	}
+	id = r.Header.Get(header)
+	if id != "" && len(id) <= maxRequestIDLen && httpguts.ValidHeaderFieldValue(id) {
+		return id, header
+	}
+	if c.Format == "ulid" {
+		return newULID(time.Now()), header
+	}
+	return newUUID(), header
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: a 48-bit millisecond timestamp followed by 80 random
+// bits, in Crockford base32. IDs sort by creation time.
+func newULID(t time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	hi := uint64(t.UnixMilli())<<16 | uint64(binary.BigEndian.Uint16(b[6:8]))
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	// 128 bits in 26 characters: the first carries the top 3 bits
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
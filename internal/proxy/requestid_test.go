@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+var (
+	uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidRe = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestRequestID(t *testing.T) {
+	get := func(c config.RequestIDConfig, h http.Header) (string, string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header = h
+		return requestID(r, c)
+	}
+
+	if id, hdr := get(config.RequestIDConfig{}, http.Header{"X-Request-Id": {"abc-123"}}); id != "abc-123" || hdr != "X-Request-Id" {
+		t.Errorf("incoming: got %q in %s", id, hdr)
+	}
+	if id, _ := get(config.RequestIDConfig{}, http.Header{}); !uuidRe.MatchString(id) {
+		t.Errorf("generated uuid: got %q", id)
+	}
+	if id, _ := get(config.RequestIDConfig{Format: "ulid"}, http.Header{}); !ulidRe.MatchString(id) {
+		t.Errorf("generated ulid: got %q", id)
+	}
+	long := strings.Repeat("x", maxRequestIDLen+1)
+	if id, _ := get(config.RequestIDConfig{}, http.Header{"X-Request-Id": {long}}); !uuidRe.MatchString(id) {
+		t.Errorf("oversized id: got %q, want a replacement", id)
+	}
+	c := config.RequestIDConfig{Header: "X-Correlation-Id"}
+	if id, _ := get(c, http.Header{"X-Correlation-Id": {"corr"}, "X-Request-Id": {"other"}}); id != "corr" {
+		t.Errorf("custom header: got %q, want corr", id)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	if got := newULID(time.UnixMilli(1469918176385))[:10]; got != "01ARYZ6S41" {
+		t.Errorf("timestamp part: got %q, want 01ARYZ6S41", got)
+	}
+	a, b := newULID(time.UnixMilli(1000)), newULID(time.UnixMilli(2000))
+	if a >= b {
+		t.Errorf("ULIDs do not sort by time: %q >= %q", a, b)
+	}
+}
+
+func TestGateway_RequestID(t *testing.T) {
+	var seen string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("X-Correlation-Id")
+		w.Header().Set("X-Correlation-Id", "upstream-made-up")
+	}))
+	defer up.Close()
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/api", Service: "s1"}}
+	var logs bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, &logs, config.AccessLogConfig{Sampling: 1.0}, nil)
+	gw.UpdateHTTP(config.HTTPConfig{RequestID: config.RequestIDConfig{Header: "X-Correlation-Id", Format: "ulid"}})
+
+	req := httptest.NewRequest("GET", "http://app.local/api", nil)
+	req.Header.Set("X-Correlation-Id", "client-42")
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+
+	if seen != "client-42" {
+		t.Errorf("upstream id: got %q, want client-42", seen)
+	}
+	if got := rr.Header().Get("X-Correlation-Id"); got != "client-42" {
+		t.Errorf("response id: got %q, want client-42", got)
+	}
+	var e AccessLog
+	if err := json.Unmarshal(logs.Bytes(), &e); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if e.RequestID != "client-42" {
+		t.Errorf("log request_id: got %q, want client-42", e.RequestID)
+	}
+
+	// gateway errors carry a generated id in the header and the body
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, httptest.NewRequest("GET", "http://app.local/missing", nil))
+	id := rr.Header().Get("X-Correlation-Id")
+	if rr.Code != http.StatusNotFound || !ulidRe.MatchString(id) {
+		t.Fatalf("404: got %d with id %q", rr.Code, id)
+	}
+	if want := "request_id: " + id; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("error body: got %q, want it to contain %q", rr.Body.String(), want)
+	}
+}
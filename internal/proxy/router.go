@@ -1,10 +1,12 @@
 package proxy
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 
 	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transcode"
 )
 
 type wildcardBucket struct {
@@ -16,9 +18,13 @@ type Table struct {
 	byHost   map[string][]config.Route // exact host -> routes sorted by prefix desc
 	wildcard []wildcardBucket          // wildcard hosts ("*.example.com") ordered by longest suffix first
 	any      []config.Route            // global wildcard routes (no host) -> prefix desc
+	// transcoders of grpc_transcode routes, keyed by the routes above
+	transcoders map[*config.Route]*transcode.Transcoder
 }
 
-func NewRouter(routes []config.Route) *Table {
+// NewRouter builds the route table. It also loads the descriptor sets of
+// grpc_transcode routes, so it fails if one cannot be read or bound.
+func NewRouter(routes []config.Route) (*Table, error) {
 	t := &Table{byHost: make(map[string][]config.Route)}
 
 	// helper to collect wildcard hosts keyed by suffix
@@ -64,7 +70,55 @@ func NewRouter(routes []config.Route) *Table {
 		return len(t.any[i].PathPrefix) > len(t.any[j].PathPrefix)
 	})
 
-	return t
+	if err := t.loadTranscoders(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadTranscoders binds the grpc_transcode routes of the final table. Routes
+// naming the same descriptor set and services share one transcoder.
+func (t *Table) loadTranscoders() error {
+	loaded := make(map[string]*transcode.Transcoder)
+	load := func(rs []config.Route) error {
+		for i := range rs {
+			tc := rs[i].Transcode
+			if tc == nil {
+				continue
+			}
+			key := tc.DescriptorSet + "\x00" + strings.Join(tc.Services, ",")
+			tr, ok := loaded[key]
+			if !ok {
+				var err error
+				if tr, err = transcode.Load(tc.DescriptorSet, tc.Services); err != nil {
+					return fmt.Errorf("route %s: grpc_transcode: %v", rs[i].Name, err)
+				}
+				loaded[key] = tr
+			}
+			if t.transcoders == nil {
+				t.transcoders = make(map[*config.Route]*transcode.Transcoder)
+			}
+			t.transcoders[&rs[i]] = tr
+		}
+		return nil
+	}
+
+	for _, rs := range t.byHost {
+		if err := load(rs); err != nil {
+			return err
+		}
+	}
+	for _, b := range t.wildcard {
+		if err := load(b.routes); err != nil {
+			return err
+		}
+	}
+	return load(t.any)
+}
+
+// transcoder returns the transcoder of a route returned by Match.
+func (t *Table) transcoder(r *config.Route) *transcode.Transcoder {
+	return t.transcoders[r]
 }
 
 func (t *Table) Match(host, path string) *config.Route {
@@ -6,13 +6,22 @@ import (
 	"github.com/fabian4/gateway-homebrew-go/internal/config"
 )
 
+func mustRouter(t *testing.T, routes []config.Route) *Table {
+	t.Helper()
+	rt, err := NewRouter(routes)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return rt
+}
+
 func TestMatch_MultiHostAndLongestPrefix(t *testing.T) {
 	routes := []config.Route{
 		{Name: "r1", Host: "app.example.com", PathPrefix: "/api", Service: "s1"},
 		{Name: "r2", Host: "app.example.com", PathPrefix: "/api/v1", Service: "s2"},
 		{Name: "r3", Host: "other.example.com", PathPrefix: "/", Service: "s3"},
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	// longest prefix wins under same host
 	if got := rt.Match("app.example.com", "/api/v1/items"); got == nil || got.Service != "s2" {
@@ -37,7 +46,7 @@ func TestMatch_WildcardFallback(t *testing.T) {
 		{Name: "r1", Host: "app.example.com", PathPrefix: "/api", Service: "s1"},
 		{Name: "r0", Host: "", PathPrefix: "/", Service: "s0"}, // global wildcard
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	// unmatched host falls back to wildcard
 	if got := rt.Match("nope.example.com", "/hi"); got == nil || got.Service != "s0" {
@@ -55,7 +64,7 @@ func TestMatch_PathSegmentBoundary(t *testing.T) {
 		{Name: "api-v1", Host: "app.example.com", PathPrefix: "/api/v1", Service: "api-v1"},
 		{Name: "wild", Host: "", PathPrefix: "/", Service: "wild"},
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	// exact match on prefix
 	if got := rt.Match("app.example.com", "/api"); got == nil || got.Service != "api" {
@@ -81,7 +90,7 @@ func TestMatch_WildcardHost_SubdomainsOnly(t *testing.T) {
 		{Name: "wild", Host: "*.example.com", PathPrefix: "/", Service: "wild"},
 		{Name: "global", Host: "", PathPrefix: "/", Service: "global"},
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	// exact host must win over wildcard
 	if got := rt.Match("app.example.com", "/"); got == nil || got.Service != "exact" {
@@ -109,7 +118,7 @@ func TestMatch_WildcardHost_PrecedenceBySuffixLength(t *testing.T) {
 		{Name: "broad", Host: "*.example.com", PathPrefix: "/", Service: "broad"},
 		{Name: "narrow", Host: "*.api.example.com", PathPrefix: "/", Service: "narrow"},
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	if got := rt.Match("foo.api.example.com", "/"); got == nil || got.Service != "narrow" {
 		t.Fatalf("want narrow (more specific wildcard) for foo.api.example.com, got %+v", got)
@@ -127,7 +136,7 @@ func TestMatch_DefaultRoutePerHostVsGlobal(t *testing.T) {
 		// global default
 		{Name: "global-default", Host: "", PathPrefix: "/", Service: "global-default"},
 	}
-	rt := NewRouter(routes)
+	rt := mustRouter(t, routes)
 
 	// known prefix still wins on that host
 	if got := rt.Match("app.example.com", "/api/foo"); got == nil || got.Service != "api" {
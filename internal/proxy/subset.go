@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+)
+
+// subsetBalancer narrows lb to the peers selected by the route's subset config.
+// It returns nil when the subset is empty and the fallback policy is "none".
+func subsetBalancer(lb Balancer, sc *config.Subset, r *http.Request) Balancer {
+	ss, ok := lb.(subsetter)
+	if !ok {
+		return lb
+	}
+
+	selector := make(map[string]string, len(sc.Selector)+len(sc.Headers))
+	for k, v := range sc.Selector {
+		selector[k] = v
+	}
+	for header, label := range sc.Headers {
+		if v := r.Header.Get(header); v != "" {
+			selector[label] = v
+		}
+	}
+	if len(selector) == 0 {
+		return lb
+	}
+	if sub := ss.Subset(selector); sub != nil {
+		return sub
+	}
+
+	switch sc.Fallback {
+	case "none":
+		return nil
+	case "default":
+		if sub := ss.Subset(sc.Default); sub != nil {
+			return sub
+		}
+		return nil
+	default: // "any"
+		return lb
+	}
+}
+
+// subsetHeaders returns the sorted request headers that select the subset of
+// a route. Responses of the route differ by their values, so they are part of
+// cache and coalescing keys.
+func subsetHeaders(sc *config.Subset) []string {
+	if sc == nil || len(sc.Headers) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(sc.Headers))
+	for name := range sc.Headers {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	slices.Sort(names)
+	return names
+}
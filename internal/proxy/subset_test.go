@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func TestGateway_SubsetByHeader(t *testing.T) {
+	newUp := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Peer", name)
+		}))
+	}
+	v1, v2 := newUp("v1"), newUp("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{
+			{URL: mustURL(t, v1.URL), Labels: map[string]string{"version": "v1"}},
+			{URL: mustURL(t, v2.URL), Labels: map[string]string{"version": "v2", "hw": "gpu"}},
+		}},
+	}
+	routes := []config.Route{
+		{Name: "any", PathPrefix: "/any", Service: "s1", Subset: &config.Subset{
+			Headers: map[string]string{"X-Version": "version"}, Fallback: "any",
+		}},
+		{Name: "none", PathPrefix: "/none", Service: "s1", Subset: &config.Subset{
+			Headers: map[string]string{"X-Version": "version"}, Fallback: "none",
+		}},
+		{Name: "default", PathPrefix: "/default", Service: "s1", Subset: &config.Subset{
+			Headers:  map[string]string{"X-Version": "version"},
+			Fallback: "default",
+			Default:  map[string]string{"version": "v1"},
+		}},
+		{Name: "gpu", PathPrefix: "/gpu", Service: "s1", Subset: &config.Subset{
+			Selector: map[string]string{"hw": "gpu"}, Fallback: "none",
+		}},
+	}
+	gw := NewGateway(mustRouter(t, routes), svcs, transport.NewDefaultRegistry(), 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	do := func(path, version string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://gw.local"+path, nil)
+		if version != "" {
+			req.Header.Set("X-Version", version)
+		}
+		rr := httptest.NewRecorder()
+		gw.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// header pins every request to the matching peer
+	for i := 0; i < 4; i++ {
+		if got := do("/any", "v2").Header().Get("X-Peer"); got != "v2" {
+			t.Fatalf("pinned v2: got %q", got)
+		}
+	}
+	// static selector
+	if got := do("/gpu", "").Header().Get("X-Peer"); got != "v2" {
+		t.Fatalf("gpu selector: got %q", got)
+	}
+
+	// empty subset: fallback policies
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[do("/any", "v9").Header().Get("X-Peer")]++
+	}
+	if seen["v1"] != 2 || seen["v2"] != 2 {
+		t.Fatalf("fallback any: got %v", seen)
+	}
+	if rr := do("/none", "v9"); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("fallback none: status %d, want 503", rr.Code)
+	}
+	if got := do("/default", "v9").Header().Get("X-Peer"); got != "v1" {
+		t.Fatalf("fallback default: got %q, want v1", got)
+	}
+}
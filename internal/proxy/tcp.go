@@ -54,15 +54,16 @@ func (p *TCPProxy) Handle(conn net.Conn) {
 	// Dial upstream
 	// We use the Host from the URL (e.g. "127.0.0.1:8080")
 	u := ep.URL()
+	dialStart := time.Now()
 	upstream, err := net.DialTimeout("tcp", u.Host, 5*time.Second)
 	if err != nil {
 		log.Printf("tcp proxy: dial upstream %s: %v", u.Host, err)
-		ep.Feedback(false)
+		ep.Feedback(false, time.Since(dialStart))
 		return
 	}
 	defer func() { _ = upstream.Close() }()
 
-	ep.Feedback(true)
+	ep.Feedback(true, time.Since(dialStart))
 
 	// Wrap connections for idle timeout
 	var clientConn, upstreamConn = conn, upstream
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/tracing"
+)
+
+// startServerSpan opens the span covering the whole request. It continues the
+// caller's trace when the request carries one.
+func startServerSpan(t *tracing.Tracer, r *http.Request, client clientAddr, requestID string, start time.Time) *tracing.Span {
+	if t == nil {
+		return nil
+	}
+	span := t.Start(tracing.Extract(r.Header, t.B3()), r.Method, tracing.KindServer, start)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.scheme", scheme)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("server.address", r.Host)
+	span.SetAttr("client.address", client.IP)
+	span.SetAttr("user_agent.original", r.UserAgent())
+	span.SetAttr("network.protocol.version", protocolVersion(r.ProtoMajor, r.ProtoMinor))
+	span.SetAttr("gateway.request_id", requestID)
+	return span
+}
+
+// routeSpan names the server span after the matched route.
+func routeSpan(span *tracing.Span, r *http.Request, route *config.Route) {
+	span.SetName(r.Method + " " + route.Name)
+	span.SetAttr("gateway.route", route.Name)
+	span.SetAttr("gateway.service", route.Service)
+}
+
+// endServerSpan records the final status; only 5xx count as server errors.
+func endServerSpan(span *tracing.Span, status int, upstream string) {
+	span.SetAttr("gateway.upstream", upstream)
+	span.SetAttr("http.response.status_code", status)
+	if status >= 500 {
+		span.SetStatus(tracing.StatusError, http.StatusText(status))
+	}
+	span.End()
+}
+
+// startAttempt opens the client span of one upstream request and propagates
+// its context in req's headers. Without a tracer the caller's trace headers
+// pass through untouched.
+func startAttempt(t *tracing.Tracer, parent *tracing.Span, req *http.Request, route *config.Route) *tracing.Span {
+	if t == nil {
+		return nil
+	}
+	span := t.Start(parent.Context(), req.Method, tracing.KindClient, time.Now())
+	tracing.Inject(req.Header, span.Context(), t.B3())
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("url.full", req.URL.String())
+	span.SetAttr("server.address", req.URL.Hostname())
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		span.SetAttr("server.port", port)
+	}
+	span.SetAttr("gateway.route", route.Name)
+	span.SetAttr("gateway.service", route.Service)
+	return span
+}
+
+// endAttempt records the upstream's answer; 4xx and 5xx are client errors.
+func endAttempt(span *tracing.Span, res *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.SetStatus(tracing.StatusError, err.Error())
+	case res.StatusCode >= 400:
+		span.SetAttr("http.response.status_code", res.StatusCode)
+		span.SetStatus(tracing.StatusError, http.StatusText(res.StatusCode))
+	default:
+		span.SetAttr("http.response.status_code", res.StatusCode)
+	}
+}
+
+// protocolVersion formats the version as in semantic conventions: "1.1", "2".
+func protocolVersion(major, minor int) string {
+	if minor == 0 && major > 1 {
+		return strconv.Itoa(major)
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
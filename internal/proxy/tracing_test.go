@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/tracing"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+// otlpReceiver is an in-process OTLP/HTTP collector that keeps the spans it
+// receives.
+type otlpReceiver struct {
+	*httptest.Server
+	mu    sync.Mutex
+	spans []receivedSpan
+}
+
+type receivedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			IntValue    string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s receivedSpan) attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue + a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func newOTLPReceiver(t *testing.T) *otlpReceiver {
+	t.Helper()
+	rc := &otlpReceiver{}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []receivedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad export", http.StatusBadRequest)
+			return
+		}
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				rc.spans = append(rc.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func TestGateway_Tracing(t *testing.T) {
+	var seen http.Header
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer up.Close()
+	svcs := map[string]config.Service{
+		"s1": {Name: "s1", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	rs := []config.Route{{Name: "r1", PathPrefix: "/", Service: "s1"}}
+	var logs bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, &logs, config.AccessLogConfig{Sampling: 1.0}, nil)
+	rc := newOTLPReceiver(t)
+	gw.UpdateTracer(tracing.New(tracing.Config{Endpoint: rc.URL + "/v1/traces", Sampling: 0, B3: true}))
+
+	req := httptest.NewRequest("GET", "http://app.local/x", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=x")
+	gw.ServeHTTP(httptest.NewRecorder(), req)
+	// a new trace at sampling 0 is propagated but not recorded
+	gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://app.local/y", nil))
+	unsampled, b3 := seen.Get("Traceparent"), seen.Clone()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// swapping the tracer out (as a reload disabling tracing does) stops propagation
+	if err := gw.UpdateTracer(nil).Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://app.local/z", nil))
+	if tp := seen.Get("Traceparent"); tp != "" {
+		t.Errorf("without a tracer: upstream got traceparent %q", tp)
+	}
+
+	if len(rc.spans) != 2 {
+		t.Fatalf("spans: got %d, want server and client span of the sampled request", len(rc.spans))
+	}
+	client, server := rc.spans[0], rc.spans[1]
+	if server.Kind != int(tracing.KindServer) || server.ParentSpanID != "00f067aa0ba902b7" || server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span: %+v", server)
+	}
+	if client.Kind != int(tracing.KindClient) || client.ParentSpanID != server.SpanID || client.TraceID != server.TraceID {
+		t.Errorf("client span: %+v", client)
+	}
+	for k, want := range map[string]string{"gateway.route": "r1", "gateway.service": "s1", "http.response.status_code": "503", "gateway.upstream": up.URL + "/x"} {
+		if got := server.attr(k); got != want {
+			t.Errorf("server %s: got %q, want %q", k, got, want)
+		}
+	}
+	if server.Name != "GET r1" || server.Status.Code != int(tracing.StatusError) || client.Status.Code != int(tracing.StatusError) {
+		t.Errorf("names/status: server %q %d, client %d", server.Name, server.Status.Code, client.Status.Code)
+	}
+
+	var e AccessLog
+	if err := json.NewDecoder(&logs).Decode(&e); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if e.TraceID != server.TraceID {
+		t.Errorf("log trace_id: got %q, want %q", e.TraceID, server.TraceID)
+	}
+
+	// the upstream continues from the client span of the unsampled request
+	if len(unsampled) != 55 || unsampled[53:] != "00" || unsampled[3:35] == server.TraceID {
+		t.Errorf("upstream traceparent of unsampled request: %q", unsampled)
+	}
+	if b3.Get("X-B3-Sampled") != "0" || b3.Get("X-B3-Spanid") != unsampled[36:52] {
+		t.Errorf("upstream B3 headers: %v", b3)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// announceTrailers declares the upstream's announced trailer keys on the
+// client response. It must run before WriteHeader. The returned set is what
+// was declared; anything else arriving later goes out via http.TrailerPrefix.
+func announceTrailers(h http.Header, res *http.Response) map[string]bool {
+	if len(res.Trailer) == 0 {
+		return nil
+	}
+	declared := make(map[string]bool, len(res.Trailer))
+	keys := make([]string, 0, len(res.Trailer))
+	for k := range res.Trailer {
+		k = http.CanonicalHeaderKey(k)
+		declared[k] = true
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h.Set("Trailer", strings.Join(keys, ", "))
+	// trailers need a chunked (HTTP/1.1) or DATA-framed (HTTP/2) body
+	h.Del("Content-Length")
+	return declared
+}
+
+// forwardTrailers copies the upstream trailers to the client response once the
+// body is consumed. Keys that were not declared before WriteHeader (HTTP/2
+// upstreams may send any) are emitted through http.TrailerPrefix.
+func forwardTrailers(h http.Header, res *http.Response, declared map[string]bool) {
+	for k, vv := range res.Trailer {
+		k = http.CanonicalHeaderKey(k)
+		if isHopByHop(k) {
+			continue
+		}
+		if !declared[k] {
+			k = http.TrailerPrefix + k
+		}
+		h.Del(k)
+		for _, v := range vv {
+			h.Add(k, v)
+		}
+	}
+}
+
+// requestTrailers returns the client's declared request trailers for the
+// upstream request. The server fills in the values of r.Trailer once the body
+// reaches EOF, which is before the transport writes them upstream, so the map
+// is shared rather than copied.
+func requestTrailers(r *http.Request) http.Header {
+	if len(r.Trailer) == 0 {
+		return nil
+	}
+	for k := range r.Trailer {
+		if isHopByHop(http.CanonicalHeaderKey(k)) {
+			delete(r.Trailer, k)
+		}
+	}
+	return r.Trailer
+}
+
+func isHopByHop(k string) bool {
+	for h := range hopByHop {
+		if strings.EqualFold(h, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyBody streams the upstream body to the client. Bodies of unknown length
+// (gRPC streams, chunked responses, SSE) are flushed after every read so
+// messages are not held back in the server's write buffer. A failed write to
+// the client is reported as errClientWrite; upstream read errors as they are.
+func copyBody(w http.ResponseWriter, body io.Reader, stream bool) error {
+	f, ok := w.(http.Flusher)
+	stream = stream && ok
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return errClientWrite
+			}
+			if stream {
+				f.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+// newTrailerGateway fronts handler (served over h2c or HTTP/1.1) with a
+// gateway that is itself served over HTTP/1.1 and h2c.
+func newTrailerGateway(t *testing.T, proto string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	protos := new(http.Protocols)
+	protos.SetHTTP1(true)
+	protos.SetUnencryptedHTTP2(true)
+
+	up := httptest.NewUnstartedServer(handler)
+	up.Config.Protocols = protos
+	up.Start()
+	t.Cleanup(up.Close)
+
+	svcs := map[string]config.Service{
+		"up": {Name: "up", Proto: proto, Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+	}
+	reg := transport.NewDefaultRegistry()
+	reg.Register("up", reg.Get(proto))
+	rs := []config.Route{{Name: "r", PathPrefix: "/", Service: "up"}}
+	gw := NewGateway(mustRouter(t, rs), svcs, reg, 0, nil, config.AccessLogConfig{Sampling: 1.0}, nil)
+
+	front := httptest.NewUnstartedServer(gw)
+	front.Config.Protocols = protos
+	front.Start()
+	t.Cleanup(front.Close)
+	return front
+}
+
+func h2cClient() *http.Client {
+	protos := new(http.Protocols)
+	protos.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protos}}
+}
+
+func TestGateway_UndeclaredTrailers(t *testing.T) {
+	front := newTrailerGateway(t, transport.ProtoH2C, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = w.Write(grpcFrame(0, "hi"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "late")
+	})
+
+	for name, c := range map[string]*http.Client{"h2c": h2cClient(), "http1": front.Client()} {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", front.URL+"/pkg.Svc/M", bytes.NewReader(grpcFrame(0, "")))
+			req.Header.Set("Content-Type", "application/grpc")
+			req.Header.Set("TE", "trailers")
+			res, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer func() { _ = res.Body.Close() }()
+			_, _ = io.Copy(io.Discard, res.Body)
+
+			if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+				t.Errorf("declared trailer: got %q, want 0", got)
+			}
+			if got := res.Trailer.Get("Grpc-Message"); got != "late" {
+				t.Errorf("undeclared trailer: got %q, want late", got)
+			}
+		})
+	}
+}
+
+func TestGateway_GRPCBidiStreaming(t *testing.T) {
+	front := newTrailerGateway(t, transport.ProtoH2C, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		// echo every message as soon as it arrives
+		br := bufio.NewReader(r.Body)
+		hdr := make([]byte, 5)
+		for {
+			if _, err := io.ReadFull(br, hdr); err != nil {
+				break
+			}
+			msg := make([]byte, int(hdr[1])<<24|int(hdr[2])<<16|int(hdr[3])<<8|int(hdr[4]))
+			if _, err := io.ReadFull(br, msg); err != nil {
+				break
+			}
+			_, _ = w.Write(append(hdr, msg...))
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"X-Seen", r.Trailer.Get("X-Client-Checksum"))
+	})
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", front.URL+"/pkg.Svc/Chat", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Trailer = http.Header{"X-Client-Checksum": nil}
+	res, err := h2cClient().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("proto: got %s, want HTTP/2", res.Proto)
+	}
+
+	// each echo must come back before the next message is sent
+	for _, m := range []string{"one", "two", "three"} {
+		want := grpcFrame(0, m)
+		if _, err := pw.Write(want); err != nil {
+			t.Fatalf("send %s: %v", m, err)
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(res.Body, got); err != nil {
+			t.Fatalf("recv %s: %v", m, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("echo: got %q, want %q", got, want)
+		}
+	}
+	req.Trailer.Set("X-Client-Checksum", "abc")
+	_ = pw.Close()
+	if rest, _ := io.ReadAll(res.Body); len(rest) != 0 {
+		t.Fatalf("unexpected trailing body %q", rest)
+	}
+	if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status: got %q, want 0", got)
+	}
+	if got := res.Trailer.Get("X-Seen"); got != "abc" {
+		t.Errorf("request trailer upstream: got %q, want abc", got)
+	}
+}
+
+func TestGateway_ChunkedTrailers(t *testing.T) {
+	front := newTrailerGateway(t, "http1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(r.TransferEncoding) == 0 || r.TransferEncoding[0] != "chunked" {
+			t.Errorf("upstream transfer-encoding: got %v, want chunked", r.TransferEncoding)
+		}
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = w.Write([]byte(strings.ToUpper(string(body))))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Checksum", r.Trailer.Get("X-Checksum")+"-echo")
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "yes")
+	})
+
+	req, _ := http.NewRequest("PUT", front.URL+"/upload", io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")))
+	req.ContentLength = -1
+	req.Trailer = http.Header{"X-Checksum": {"sum"}}
+	res, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	body, _ := io.ReadAll(res.Body)
+
+	if string(body) != "ABCDEF" {
+		t.Errorf("body: got %q, want ABCDEF", body)
+	}
+	if len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+		t.Errorf("response transfer-encoding: got %v, want chunked", res.TransferEncoding)
+	}
+	if got := res.Trailer.Get("X-Checksum"); got != "sum-echo" {
+		t.Errorf("X-Checksum trailer: got %q, want sum-echo", got)
+	}
+	if got := res.Trailer.Get("X-Undeclared"); got != "yes" {
+		t.Errorf("X-Undeclared trailer: got %q, want yes", got)
+	}
+}
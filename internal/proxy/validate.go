@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"golang.org/x/net/http/httpguts"
+)
+
+// validateRequest checks the request headers against the configured limits,
+// resolves ambiguous framing and normalizes the path before routing. It
+// returns why the request must be rejected, or "" if it may proceed.
+//
+// Go's HTTP/1.1 parser already rejects much of this; the checks here apply the
+// same rules to HTTP/2 and HTTP/3 requests and add the configured limits.
+func validateRequest(r *http.Request, c config.HTTPConfig) string {
+	lim := c.Headers
+	count, total := 0, 0
+	for k, vv := range r.Header {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Sprintf("invalid header name %q", k)
+		}
+		for _, v := range vv {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return fmt.Sprintf("invalid characters in header %s", k)
+			}
+			size := len(k) + len(v)
+			if lim.MaxFieldBytes > 0 && size > lim.MaxFieldBytes {
+				return fmt.Sprintf("header %s exceeds %d bytes", k, lim.MaxFieldBytes)
+			}
+			count++
+			total += size
+		}
+	}
+	if lim.MaxCount > 0 && count > lim.MaxCount {
+		return fmt.Sprintf("%d header fields exceed the limit of %d", count, lim.MaxCount)
+	}
+	if lim.MaxBytes > 0 && total > lim.MaxBytes {
+		return fmt.Sprintf("headers exceed %d bytes", lim.MaxBytes)
+	}
+
+	if reason := checkHost(r, lim.Strict); reason != "" {
+		return reason
+	}
+	if reason := checkContentLength(r, lim.Strict); reason != "" {
+		return reason
+	}
+	return normalizePath(r, c.NormalizePath)
+}
+
+// checkHost resolves Host header fields that disagree with the request's
+// authority (r.Host, taken from the request line or :authority).
+func checkHost(r *http.Request, strict bool) string {
+	hosts, ok := r.Header["Host"]
+	if !ok {
+		return ""
+	}
+	if strict && (len(hosts) != 1 || !strings.EqualFold(hosts[0], r.Host)) {
+		return "conflicting Host headers"
+	}
+	// the authority wins; the header fields are never forwarded
+	delete(r.Header, "Host")
+	return ""
+}
+
+// checkContentLength rejects unparsable or conflicting Content-Length values
+// and collapses identical duplicates.
+func checkContentLength(r *http.Request, strict bool) string {
+	vals, ok := r.Header["Content-Length"]
+	if !ok {
+		return ""
+	}
+	if len(r.TransferEncoding) > 0 {
+		if strict {
+			return "Content-Length with Transfer-Encoding"
+		}
+		delete(r.Header, "Content-Length")
+		return ""
+	}
+	var n int64 = -1
+	fields := 0
+	for _, v := range vals {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			m, err := strconv.ParseUint(f, 10, 63)
+			if err != nil {
+				return "invalid Content-Length"
+			}
+			if n >= 0 && int64(m) != n {
+				return "conflicting Content-Length"
+			}
+			n = int64(m)
+			fields++
+		}
+	}
+	if r.ContentLength >= 0 && n != r.ContentLength {
+		return "conflicting Content-Length"
+	}
+	if fields > 1 {
+		if strict {
+			return "duplicate Content-Length"
+		}
+		r.Header["Content-Length"] = []string{strconv.FormatInt(n, 10)}
+	}
+	return ""
+}
+
+// normalizePath rewrites r.URL so that routing and the upstream see the same,
+// canonical path.
+func normalizePath(r *http.Request, n config.PathNormalization) string {
+	if !n.MergeSlashes && !n.ResolveDots && !n.DecodeUnreserved {
+		return ""
+	}
+	esc := r.URL.EscapedPath()
+	if !strings.HasPrefix(esc, "/") {
+		return "" // "*" or authority-form
+	}
+	clean := cleanPath(esc, n)
+	if clean == esc {
+		return ""
+	}
+	p, err := url.PathUnescape(clean)
+	if err != nil {
+		return "invalid path encoding"
+	}
+	r.URL.Path, r.URL.RawPath = p, clean
+	if (&url.URL{Path: p}).EscapedPath() == clean {
+		r.URL.RawPath = ""
+	}
+	return ""
+}
+
+// cleanPath normalizes an escaped path. ".." never climbs above the root, and
+// a path ending in a dot segment keeps its trailing slash (RFC 3986, 5.2.4).
+func cleanPath(p string, n config.PathNormalization) string {
+	if n.DecodeUnreserved {
+		p = decodeUnreserved(p)
+	}
+	segs := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(segs))
+	for i, s := range segs {
+		last := i == len(segs)-1
+		if n.ResolveDots && (s == "." || s == "..") {
+			if s == ".." && len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+			continue
+		}
+		if n.MergeSlashes && s == "" && !last {
+			continue
+		}
+		out = append(out, s)
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// decodeUnreserved decodes percent-escapes of unreserved characters
+// (ALPHA / DIGIT / "-" / "." / "_" / "~") and upper-cases the others.
+func decodeUnreserved(p string) string {
+	if !strings.Contains(p, "%") {
+		return p
+	}
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] != '%' || i+2 >= len(p) || !ishex(p[i+1]) || !ishex(p[i+2]) {
+			b.WriteByte(p[i])
+			continue
+		}
+		c := unhex(p[i+1])<<4 | unhex(p[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(p[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
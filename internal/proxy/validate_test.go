@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabian4/gateway-homebrew-go/internal/config"
+	"github.com/fabian4/gateway-homebrew-go/internal/transport"
+)
+
+func TestCleanPath(t *testing.T) {
+	all := config.PathNormalization{MergeSlashes: true, ResolveDots: true, DecodeUnreserved: true}
+	cases := []struct {
+		in, want string
+		n        config.PathNormalization
+	}{
+		{"/a//b///c", "/a/b/c", all},
+		{"/a/b/", "/a/b/", all},
+		{"//", "/", all},
+		{"/a/./b/../c", "/a/c", all},
+		{"/a/b/..", "/a/", all},
+		{"/../../etc/passwd", "/etc/passwd", all},
+		{"/%7Euser/%41%2f%2F", "/~user/A%2F%2F", all},
+		{"/%2e%2e/admin", "/admin", all},
+		{"/%2e%2e/admin", "/%2e%2e/admin", config.PathNormalization{ResolveDots: true}},
+		{"/a//../b", "/a/b", config.PathNormalization{ResolveDots: true}},
+		{"/a//b", "/a//b", config.PathNormalization{ResolveDots: true}},
+		{"/bad%zz", "/bad%zz", all},
+	}
+	for _, c := range cases {
+		if got := cleanPath(c.in, c.n); got != c.want {
+			t.Errorf("cleanPath(%q, %+v): got %q, want %q", c.in, c.n, got, c.want)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	limits := config.HTTPConfig{Headers: config.HeaderLimits{MaxCount: 5, MaxBytes: 200, MaxFieldBytes: 100}}
+	strict := limits
+	strict.Headers.Strict = true
+
+	cases := []struct {
+		name   string
+		mutate func(r *http.Request)
+		cfg    config.HTTPConfig
+		reason string // substring; "" means accepted
+	}{
+		{"plain", func(r *http.Request) {}, limits, ""},
+		{"too many", func(r *http.Request) {
+			for _, k := range []string{"A", "B", "C", "D", "E", "F"} {
+				r.Header.Set("X-"+k, "1")
+			}
+		}, limits, "exceed the limit of 5"},
+		{"field too large", func(r *http.Request) { r.Header.Set("Cookie", strings.Repeat("c", 120)) }, limits, "header Cookie exceeds"},
+		{"total too large", func(r *http.Request) {
+			r.Header.Set("X-A", strings.Repeat("a", 90))
+			r.Header.Set("X-B", strings.Repeat("b", 90))
+			r.Header.Set("X-C", strings.Repeat("c", 90))
+		}, limits, "headers exceed 200 bytes"},
+		{"bad value", func(r *http.Request) { r.Header["X-Evil"] = []string{"a\r\nInjected: 1"} }, limits, "invalid characters in header X-Evil"},
+		{"bad name", func(r *http.Request) { r.Header["Bad Name"] = []string{"1"} }, limits, "invalid header name"},
+		{"dup host lenient", func(r *http.Request) { r.Header["Host"] = []string{"a.example", "b.example"} }, limits, ""},
+		{"dup host strict", func(r *http.Request) { r.Header["Host"] = []string{"a.example", "b.example"} }, strict, "conflicting Host"},
+		{"host mismatch strict", func(r *http.Request) { r.Header["Host"] = []string{"other.example"} }, strict, "conflicting Host"},
+		{"same host strict", func(r *http.Request) { r.Header["Host"] = []string{"App.Example"} }, strict, ""},
+		{"cl conflict", func(r *http.Request) { r.Header["Content-Length"] = []string{"3", "4"} }, limits, "conflicting Content-Length"},
+		{"cl list conflict", func(r *http.Request) { r.Header["Content-Length"] = []string{"3, 4"} }, limits, "conflicting Content-Length"},
+		{"cl invalid", func(r *http.Request) { r.Header["Content-Length"] = []string{"-1"} }, limits, "invalid Content-Length"},
+		{"cl vs body", func(r *http.Request) { r.Header["Content-Length"] = []string{"3"}; r.ContentLength = 5 }, limits, "conflicting Content-Length"},
+		{"cl dup lenient", func(r *http.Request) { r.Header["Content-Length"] = []string{"3", "3"}; r.ContentLength = 3 }, limits, ""},
+		{"cl dup strict", func(r *http.Request) { r.Header["Content-Length"] = []string{"3", "3"}; r.ContentLength = 3 }, strict, "duplicate Content-Length"},
+		{"cl with te strict", func(r *http.Request) {
+			r.Header["Content-Length"] = []string{"3"}
+			r.TransferEncoding = []string{"chunked"}
+		}, strict, "Content-Length with Transfer-Encoding"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://app.example/x", nil)
+			r.ContentLength = -1
+			c.mutate(r)
+			got := validateRequest(r, c.cfg)
+			if c.reason == "" && got != "" {
+				t.Fatalf("rejected: %s", got)
+			}
+			if !strings.Contains(got, c.reason) || (c.reason != "" && got == "") {
+				t.Fatalf("reason: got %q, want %q", got, c.reason)
+			}
+		})
+	}
+
+	// lenient mode normalizes instead of rejecting
+	r := httptest.NewRequest("GET", "http://app.example/x", nil)
+	r.Header["Host"] = []string{"evil.example"}
+	r.Header["Content-Length"] = []string{"0", "0"}
+	r.ContentLength = 0
+	if reason := validateRequest(r, limits); reason != "" {
+		t.Fatalf("rejected: %s", reason)
+	}
+	if _, ok := r.Header["Host"]; ok {
+		t.Error("Host header fields not dropped")
+	}
+	if got := r.Header["Content-Length"]; len(got) != 1 || got[0] != "0" {
+		t.Errorf("Content-Length: got %q, want [0]", got)
+	}
+}
+
+func TestGateway_HeaderValidation(t *testing.T) {
+	var seenPath string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenPath = r.URL.RawPath
+		if seenPath == "" {
+			seenPath = r.URL.Path
+		}
+	}))
+	defer up.Close()
+	svcs := map[string]config.Service{
+		"api":   {Name: "api", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, up.URL)}}},
+		"admin": {Name: "admin", Proto: "http1", Endpoints: []config.Endpoint{{URL: mustURL(t, "http://127.0.0.1:1")}}},
+	}
+	rs := []config.Route{
+		{Name: "admin", PathPrefix: "/admin", Service: "admin"},
+		{Name: "api", PathPrefix: "/", Service: "api"},
+	}
+	var logs bytes.Buffer
+	gw := NewGateway(mustRouter(t, rs), svcs, transport.NewDefaultRegistry(), 0, &logs, config.AccessLogConfig{Sampling: 1.0}, nil)
+	gw.UpdateHTTP(config.HTTPConfig{
+		Headers:       config.HeaderLimits{MaxCount: 10, MaxBytes: 1 << 10, MaxFieldBytes: 256},
+		NormalizePath: config.PathNormalization{MergeSlashes: true, ResolveDots: true, DecodeUnreserved: true},
+	})
+
+	req := httptest.NewRequest("GET", "http://app.local/x", nil)
+	req.Header.Set("X-Big", strings.Repeat("b", 300))
+	rr := httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "header X-Big exceeds 256 bytes") {
+		t.Fatalf("oversized: got %d %q", rr.Code, rr.Body.String())
+	}
+	var entry AccessLog
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal log: %v", err)
+	}
+	if entry.Status != 400 || entry.Reason != "header X-Big exceeds 256 bytes" {
+		t.Errorf("log: got status %d reason %q", entry.Status, entry.Reason)
+	}
+
+	// the normalized path is what is routed and forwarded
+	req = httptest.NewRequest("GET", "http://app.local/public//..%2F/%61dmin/./x/../y", nil)
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("normalized: got %d", rr.Code)
+	}
+	if seenPath != "/public/..%2F/admin/y" {
+		t.Errorf("upstream path: got %q", seenPath)
+	}
+
+	req = httptest.NewRequest("GET", "http://app.local/static/..//%61dmin/", nil)
+	rr = httptest.NewRecorder()
+	gw.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("dot-segment route: got %d, want 502 from the admin route", rr.Code)
+	}
+}
@@ -2,6 +2,7 @@ package ratelimit
 
 import (
 	"sync"
+	"time"
 
 	ratelib "golang.org/x/time/rate"
 )
@@ -12,8 +13,14 @@ type Limiter struct {
 	mu sync.RWMutex
 	// limiters stores rate.Limiter instances, keyed by a string identifier.
 	limiters map[string]*ratelib.Limiter
+	// nextPrune is when Allow next sweeps idle limiters.
+	nextPrune time.Time
 }
 
+// pruneInterval bounds how often Allow sweeps idle limiters. Per-client keys
+// (rate_limit.key: ip) would otherwise grow the map without bound.
+const pruneInterval = time.Minute
+
 // Config defines the parameters for a token bucket rate limiter.
 type Config struct {
 	// RequestsPerSecond is the average number of requests per second allowed.
@@ -32,6 +39,8 @@ func NewLimiter() *Limiter {
 // Allow checks if a request is allowed for the given key, updating the limiter's
 // configuration (rps/burst) if it has changed.
 func (l *Limiter) Allow(key string, rps float64, burst int) bool {
+	l.maybePrune()
+
 	l.mu.RLock()
 	lim, ok := l.limiters[key]
 	l.mu.RUnlock()
@@ -68,5 +77,32 @@ func (l *Limiter) Remove(key string) {
 	delete(l.limiters, key)
 }
 
-// Prune could be added to remove unused limiters, but requires tracking last access time.
-// For now, we assume the set of routes is relatively bounded.
+// Prune removes limiters whose bucket has refilled completely by now. A fresh
+// limiter would behave identically, so pruning never loosens a limit.
+func (l *Limiter) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, lim := range l.limiters {
+		if lim.TokensAt(now) >= float64(lim.Burst()) {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+func (l *Limiter) maybePrune() {
+	now := time.Now()
+	l.mu.RLock()
+	due := now.After(l.nextPrune)
+	l.mu.RUnlock()
+	if !due {
+		return
+	}
+	l.mu.Lock()
+	if !now.After(l.nextPrune) {
+		l.mu.Unlock()
+		return
+	}
+	l.nextPrune = now.Add(pruneInterval)
+	l.mu.Unlock()
+	l.Prune(now)
+}
@@ -56,3 +56,25 @@ func TestLimiter_DifferentKeys(t *testing.T) {
 		t.Error("B should be allowed (independent of A)")
 	}
 }
+
+func TestLimiter_Prune(t *testing.T) {
+	l := NewLimiter()
+
+	l.Allow("idle", 1000, 1)
+	l.Allow("busy", 1, 1)
+	l.Prune(time.Now().Add(100 * time.Millisecond))
+
+	l.mu.RLock()
+	_, idle := l.limiters["idle"]
+	_, busy := l.limiters["busy"]
+	l.mu.RUnlock()
+	if idle {
+		t.Error("idle limiter with a full bucket should be pruned")
+	}
+	if !busy {
+		t.Error("limiter with a drained bucket must be kept")
+	}
+	if l.Allow("busy", 1, 1) {
+		t.Error("busy should still be blocked after prune")
+	}
+}
@@ -0,0 +1,237 @@
+package tracing
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// exporter batches finished spans and POSTs them as OTLP/HTTP JSON. When the
+// collector falls behind, spans are dropped rather than blocking requests.
+type exporter struct {
+	endpoint string
+	headers  map[string]string
+	resource otlpResource
+	batch    int
+	interval time.Duration
+	client   *http.Client
+
+	queue chan *Span
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	dropped int // spans lost since the last warning
+}
+
+func newExporter(c Config) *exporter {
+	e := &exporter{
+		endpoint: c.Endpoint,
+		headers:  c.Headers,
+		resource: otlpResource{Attributes: []otlpKeyValue{kv("service.name", cmp.Or(c.ServiceName, "gateway-homebrew-go"))}},
+		batch:    cmp.Or(c.BatchSize, 512),
+		interval: cmp.Or(c.FlushInterval, 5*time.Second),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, cmp.Or(c.QueueSize, 2048)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) enqueue(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var buf []*Span
+	flush := func() {
+		if len(buf) > 0 {
+			e.export(buf)
+			buf = buf[:0]
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if buf = append(buf, s); len(buf) >= e.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			e.reportDropped()
+		case <-e.stop:
+			for {
+				select {
+				case s := <-e.queue:
+					buf = append(buf, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *exporter) reportDropped() {
+	e.mu.Lock()
+	n := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if n > 0 {
+		log.Printf("tracing: export queue full, dropped %d spans", n)
+	}
+}
+
+func (e *exporter) export(spans []*Span) {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = s.otlp()
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gateway-homebrew-go"}, Spans: out}},
+	}}})
+	if err != nil {
+		log.Printf("tracing: encode: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("tracing: export: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		log.Printf("tracing: export %d spans: %v", len(spans), err)
+		return
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		log.Printf("tracing: export %d spans: collector answered %s", len(spans), res.Status)
+	}
+}
+
+// OTLP/JSON encoding (opentelemetry-proto, trace/v1). IDs are hex, 64-bit
+// integers are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		TraceState        string         `json:"traceState,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    Status `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func kv(key string, value any) otlpKeyValue {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &x
+	case float64:
+		v.DoubleValue = &x
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := otlpSpan{
+		TraceID:           s.ctx.TraceID.String(),
+		SpanID:            s.ctx.SpanID.String(),
+		TraceState:        s.ctx.TraceState,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.status, Message: s.message},
+	}
+	if s.parent != (SpanID{}) {
+		o.ParentSpanID = s.parent.String()
+	}
+	for _, a := range s.attrs {
+		o.Attributes = append(o.Attributes, kv(a.key, a.value))
+	}
+	return o
+}
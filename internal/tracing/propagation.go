@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceID and SpanID identify a trace and a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	Deferred   bool   // the caller made no sampling decision (B3 without a sampled flag)
+	TraceState string // W3C tracestate, passed through unchanged
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Extract reads the caller's span context from W3C traceparent/tracestate,
+// falling back to B3 (single "b3" header or X-B3-*) when b3 is set. The
+// result is invalid if no usable context was sent.
+func Extract(h http.Header, b3 bool) SpanContext {
+	if sc, ok := parseTraceparent(h.Get("Traceparent")); ok {
+		sc.TraceState = strings.Join(h.Values("Tracestate"), ",")
+		return sc
+	}
+	if !b3 {
+		return SpanContext{}
+	}
+	if v := h.Get("B3"); v != "" {
+		parts := strings.Split(v, "-")
+		if len(parts) >= 2 {
+			sc, ok := parseB3(parts[0], parts[1])
+			if len(parts) > 2 {
+				sc.Sampled = parts[2] == "1" || parts[2] == "d"
+			} else {
+				sc.Deferred = true
+			}
+			if ok {
+				return sc
+			}
+		}
+		return SpanContext{}
+	}
+	sc, ok := parseB3(h.Get("X-B3-TraceId"), h.Get("X-B3-SpanId"))
+	if !ok {
+		return SpanContext{}
+	}
+	s := h.Get("X-B3-Sampled")
+	switch {
+	case h.Get("X-B3-Flags") == "1":
+		sc.Sampled = true
+	case s == "":
+		sc.Deferred = true
+	default:
+		sc.Sampled = s == "1" || strings.EqualFold(s, "true")
+	}
+	return sc
+}
+
+// Inject writes sc to h, replacing whatever context the caller sent.
+func Inject(h http.Header, sc SpanContext, b3 bool) {
+	for _, k := range []string{"Traceparent", "Tracestate", "B3", "X-B3-Traceid", "X-B3-Spanid", "X-B3-Parentspanid", "X-B3-Sampled", "X-B3-Flags"} {
+		h.Del(k)
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	h.Set("Traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		h.Set("Tracestate", sc.TraceState)
+	}
+	if b3 {
+		h.Set("X-B3-TraceId", sc.TraceID.String())
+		h.Set("X-B3-SpanId", sc.SpanID.String())
+		h.Set("X-B3-Sampled", flags[1:])
+	}
+}
+
+// parseTraceparent parses "00-<32 hex>-<16 hex>-<2 hex>". Higher versions
+// are accepted as long as the version-00 prefix parses.
+func parseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return sc, false
+	}
+	if (len(v) > 55 && v[55] != '-') || v[:2] == "ff" || (v[:2] == "00" && len(v) != 55) {
+		return sc, false
+	}
+	var ver, flags [1]byte
+	if !decodeLower(ver[:], v[:2]) || !decodeLower(sc.TraceID[:], v[3:35]) ||
+		!decodeLower(sc.SpanID[:], v[36:52]) || !decodeLower(flags[:], v[53:55]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// parseB3 accepts 64- or 128-bit trace IDs; short ones are left-padded.
+func parseB3(traceID, spanID string) (SpanContext, bool) {
+	var sc SpanContext
+	switch len(traceID) {
+	case 16:
+		traceID = strings.Repeat("0", 16) + traceID
+	case 32:
+	default:
+		return SpanContext{}, false
+	}
+	if len(spanID) != 16 ||
+		!decodeLower(sc.TraceID[:], strings.ToLower(traceID)) ||
+		!decodeLower(sc.SpanID[:], strings.ToLower(spanID)) {
+		return SpanContext{}, false
+	}
+	return sc, sc.IsValid()
+}
+
+// decodeLower decodes lower-case hex, as required by traceparent.
+func decodeLower(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+	n, err := hex.Decode(dst, []byte(s))
+	return err == nil && n == len(dst)
+}
@@ -0,0 +1,193 @@
+// Package tracing records request spans and exports them to an OpenTelemetry
+// collector over OTLP/HTTP. A nil *Tracer and the nil spans it returns are
+// valid no-ops, so callers need not check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Config configures a Tracer.
+type Config struct {
+	Endpoint    string            // OTLP/HTTP traces URL, e.g. http://collector:4318/v1/traces
+	Headers     map[string]string // sent with every export request
+	ServiceName string
+	Sampling    float64 // fraction of new traces recorded, 0..1
+	B3          bool    // also accept and send B3 headers
+
+	BatchSize     int           // spans per export request; 0 means 512
+	FlushInterval time.Duration // 0 means 5s
+	QueueSize     int           // spans buffered before dropping; 0 means 2048
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindServer Kind = 2
+	KindClient Kind = 3
+)
+
+// Tracer creates spans and hands finished, sampled ones to the exporter.
+type Tracer struct {
+	b3       bool
+	sampling float64
+	exp      *exporter
+}
+
+// New starts a tracer and its background exporter.
+func New(c Config) *Tracer {
+	return &Tracer{b3: c.B3, sampling: c.Sampling, exp: newExporter(c)}
+}
+
+// B3 reports whether B3 propagation is enabled.
+func (t *Tracer) B3() bool { return t != nil && t.b3 }
+
+// Shutdown exports the spans still queued, giving up when ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exp.shutdown(ctx)
+}
+
+// Start begins a span. A valid parent continues its trace and sampling
+// decision; a new trace, or a parent that deferred the decision, is sampled
+// with the configured ratio.
+func (t *Tracer) Start(parent SpanContext, name string, kind Kind, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: start}
+	if parent.IsValid() {
+		s.ctx = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled, TraceState: parent.TraceState}
+		if parent.Deferred {
+			s.ctx.Sampled = sampled(parent.TraceID, t.sampling)
+		}
+		s.parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = sampled(s.ctx.TraceID, t.sampling)
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// sampled decides on the trace ID alone, so every component using the same
+// ratio agrees on a trace (the OpenTelemetry TraceIDRatioBased rule).
+func sampled(id TraceID, ratio float64) bool {
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(ratio*(1<<63))
+}
+
+// Status is the OTLP span status code.
+type Status int
+
+const (
+	StatusUnset Status = 0
+	StatusOK    Status = 1
+	StatusError Status = 2
+)
+
+// Span is an operation in a trace. Its methods are safe on a nil Span.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+	end    time.Time
+
+	mu      sync.Mutex
+	attrs   []attribute
+	status  Status
+	message string
+	ended   bool
+}
+
+type attribute struct {
+	key   string
+	value any // string, int64, bool or float64
+}
+
+// Context returns the span's propagation context.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetName replaces the span name, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr records an attribute. Values other than string, int, int64, bool
+// and float64 are ignored; empty strings are skipped.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+	case int:
+		value = int64(v)
+	case int64, bool, float64:
+	default:
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key, value})
+	s.mu.Unlock()
+}
+
+// SetStatus sets the span status; msg is only kept for StatusError.
+func (s *Span) SetStatus(code Status, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status = code
+	if code == StatusError {
+		s.message = msg
+	}
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if it was sampled. Only the
+// first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.ctx.Sampled {
+		s.tracer.exp.enqueue(s)
+	}
+}
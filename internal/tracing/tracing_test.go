@@ -0,0 +1,184 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		name     string
+		h        http.Header
+		b3       bool
+		trace    string
+		sampled  bool
+		deferred bool
+	}{
+		{name: "traceparent", h: http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+			trace: "4bf92f3577b34da6a3ce929d0e0e4736", sampled: true},
+		{name: "not_sampled", h: http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}},
+			trace: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "future_version", h: http.Header{"Traceparent": {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"}},
+			trace: "4bf92f3577b34da6a3ce929d0e0e4736", sampled: true},
+		{name: "upper_case", h: http.Header{"Traceparent": {"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"}}},
+		{name: "zero_trace", h: http.Header{"Traceparent": {"00-00000000000000000000000000000000-00f067aa0ba902b7-01"}}},
+		{name: "b3_disabled", h: http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"}}},
+		{name: "b3_single", b3: true, h: http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"}},
+			trace: "80f198ee56343ba864fe8b2a57d3eff7", sampled: true},
+		{name: "b3_multi_64bit", b3: true, h: http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}, "X-B3-Sampled": {"1"}},
+			trace: "0000000000000000a3ce929d0e0e4736", sampled: true},
+		{name: "b3_single_deferred", b3: true, h: http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"}},
+			trace: "80f198ee56343ba864fe8b2a57d3eff7", deferred: true},
+		{name: "b3_single_denied", b3: true, h: http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0"}},
+			trace: "80f198ee56343ba864fe8b2a57d3eff7"},
+		{name: "b3_multi_deferred", b3: true, h: http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}},
+			trace: "0000000000000000a3ce929d0e0e4736", deferred: true},
+		{name: "b3_multi_denied", b3: true, h: http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}, "X-B3-Sampled": {"0"}},
+			trace: "0000000000000000a3ce929d0e0e4736"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := Extract(tc.h, tc.b3)
+			if tc.trace == "" {
+				if sc.IsValid() {
+					t.Fatalf("got %+v, want invalid", sc)
+				}
+				return
+			}
+			if sc.TraceID.String() != tc.trace || sc.Sampled != tc.sampled || sc.Deferred != tc.deferred {
+				t.Errorf("got trace %s sampled=%v deferred=%v, want %s sampled=%v deferred=%v",
+					sc.TraceID, sc.Sampled, sc.Deferred, tc.trace, tc.sampled, tc.deferred)
+			}
+		})
+	}
+}
+
+func TestStart_DeferredB3UsesLocalSampler(t *testing.T) {
+	deferred := http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}}
+	denied := http.Header{"X-B3-Traceid": {"a3ce929d0e0e4736"}, "X-B3-Spanid": {"00f067aa0ba902b7"}, "X-B3-Sampled": {"0"}}
+
+	all := &Tracer{b3: true, sampling: 1}
+	if s := all.Start(Extract(deferred, true), "x", KindServer, time.Now()); !s.Context().Sampled {
+		t.Error("deferred decision with ratio 1: want sampled")
+	}
+	if s := all.Start(Extract(denied, true), "x", KindServer, time.Now()); s.Context().Sampled {
+		t.Error("explicit X-B3-Sampled: 0 must stay unsampled")
+	}
+	none := &Tracer{b3: true, sampling: 0}
+	if s := none.Start(Extract(deferred, true), "x", KindServer, time.Now()); s.Context().Sampled {
+		t.Error("deferred decision with ratio 0: want unsampled")
+	}
+}
+
+func TestInject(t *testing.T) {
+	sc := Extract(http.Header{
+		"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"Tracestate":  {"vendor=x"},
+	}, false)
+	h := http.Header{"B3": {"stale"}}
+	Inject(h, sc, true)
+	want := map[string]string{
+		"Traceparent":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"Tracestate":   "vendor=x",
+		"X-B3-Traceid": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"X-B3-Spanid":  "00f067aa0ba902b7",
+		"X-B3-Sampled": "1",
+		"B3":           "",
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("%s: got %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	n, hits := 10000, 0
+	for i := 0; i < n; i++ {
+		var id TraceID
+		v := i * 65536 / n
+		id[8], id[9] = byte(v>>8), byte(v)
+		if sampled(id, 0.25) {
+			hits++
+		}
+	}
+	// the IDs above cover the top 16 bits evenly
+	if hits < 2300 || hits > 2700 {
+		t.Errorf("ratio 0.25: sampled %d of %d", hits, n)
+	}
+	if sampled(TraceID{15: 1}, 0) || !sampled(TraceID{8: 0xff}, 1) {
+		t.Error("ratio 0 and 1 must be absolute")
+	}
+}
+
+func TestTracer_Export(t *testing.T) {
+	reqs := make(chan otlpRequest, 4)
+	col := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Api-Key") != "k" {
+			t.Errorf("export headers: %v", r.Header)
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		reqs <- req
+	}))
+	defer col.Close()
+
+	tr := New(Config{Endpoint: col.URL, Headers: map[string]string{"Api-Key": "k"}, ServiceName: "edge", Sampling: 1, FlushInterval: time.Hour})
+	parent := Extract(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, false)
+	srv := tr.Start(parent, "GET", KindServer, time.Now())
+	srv.SetName("GET r1")
+	srv.SetAttr("http.response.status_code", 502)
+	srv.SetStatus(StatusError, "upstream failed")
+	cli := tr.Start(srv.Context(), "GET", KindClient, time.Now())
+	cli.End()
+	srv.End()
+	srv.End()
+	unsampled := tr.Start(Extract(http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}}, false), "x", KindServer, time.Now())
+	unsampled.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	req := <-reqs
+	rs := req.ResourceSpans[0]
+	if got := *rs.Resource.Attributes[0].Value.StringValue; got != "edge" {
+		t.Errorf("service.name: got %q", got)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("spans: got %d, want 2 (sampled, ended once)", len(spans))
+	}
+	c, s := spans[0], spans[1]
+	if c.Kind != KindClient || c.ParentSpanID != s.SpanID || c.TraceID != s.TraceID {
+		t.Errorf("client span not a child of the server span: %+v", c)
+	}
+	if s.ParentSpanID != "00f067aa0ba902b7" || s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server span did not continue the caller's trace: %+v", s)
+	}
+	if s.Name != "GET r1" || s.Status.Code != StatusError || s.Status.Message != "upstream failed" {
+		t.Errorf("server span: %+v", s)
+	}
+	if a := s.Attributes[0]; a.Key != "http.response.status_code" || *a.Value.IntValue != "502" {
+		t.Errorf("attribute: %+v", a)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+	s := tr.Start(SpanContext{}, "x", KindServer, time.Now())
+	s.SetAttr("k", "v")
+	s.SetStatus(StatusError, "e")
+	s.End()
+	if s.Context().IsValid() || tr.B3() {
+		t.Error("nil tracer must be a no-op")
+	}
+}
@@ -0,0 +1,147 @@
+package transcode
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// httpRuleField is the extension number of google.api.http on MethodOptions.
+const httpRuleField = 72295728
+
+// httpRule is the subset of google.api.HttpRule the gateway understands.
+type httpRule struct {
+	method       string // GET, PUT, POST, DELETE, PATCH or a custom verb
+	path         string
+	body         string
+	responseBody string
+	additional   []httpRule
+}
+
+// httpRules reads the google.api.http annotation of a method. The extension is
+// decoded from the raw option bytes so no generated annotation package is needed.
+func httpRules(opts *descriptorpb.MethodOptions) ([]httpRule, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	raw := opts.ProtoReflect().GetUnknown()
+	var rules []httpRule
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		raw = raw[n:]
+		if num != httpRuleField || typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, raw)
+			if m < 0 {
+				return nil, protowire.ParseError(m)
+			}
+			raw = raw[m:]
+			continue
+		}
+		b, m := protowire.ConsumeBytes(raw)
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		raw = raw[m:]
+		rule, err := parseHTTPRule(b)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	var out []httpRule
+	for _, r := range rules {
+		out = append(out, r)
+		out = append(out, r.additional...)
+	}
+	return out, nil
+}
+
+func parseHTTPRule(b []byte) (httpRule, error) {
+	var r httpRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return r, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return r, protowire.ParseError(m)
+			}
+			b = b[m:]
+			continue
+		}
+		v, m := protowire.ConsumeBytes(b)
+		if m < 0 {
+			return r, protowire.ParseError(m)
+		}
+		b = b[m:]
+		switch num {
+		case 2:
+			r.method, r.path = "GET", string(v)
+		case 3:
+			r.method, r.path = "PUT", string(v)
+		case 4:
+			r.method, r.path = "POST", string(v)
+		case 5:
+			r.method, r.path = "DELETE", string(v)
+		case 6:
+			r.method, r.path = "PATCH", string(v)
+		case 7:
+			r.body = string(v)
+		case 8:
+			kind, path, err := parseCustomPattern(v)
+			if err != nil {
+				return r, err
+			}
+			r.method, r.path = kind, path
+		case 11:
+			add, err := parseHTTPRule(v)
+			if err != nil {
+				return r, err
+			}
+			r.additional = append(r.additional, add)
+		case 12:
+			r.responseBody = string(v)
+		}
+	}
+	if r.path == "" {
+		return r, fmt.Errorf("http rule without a pattern")
+	}
+	return r, nil
+}
+
+func parseCustomPattern(b []byte) (kind, path string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return "", "", protowire.ParseError(m)
+			}
+			b = b[m:]
+			continue
+		}
+		v, m := protowire.ConsumeBytes(b)
+		if m < 0 {
+			return "", "", protowire.ParseError(m)
+		}
+		b = b[m:]
+		switch num {
+		case 1:
+			kind = string(v)
+		case 2:
+			path = string(v)
+		}
+	}
+	return kind, path, nil
+}
@@ -0,0 +1,91 @@
+package transcode
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// gRPC status codes.
+const (
+	codeOK                 = 0
+	codeCanceled           = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codeAlreadyExists      = 6
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeAborted            = 10
+	codeOutOfRange         = 11
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+	codeDataLoss           = 15
+	codeUnauthenticated    = 16
+)
+
+// HTTPStatus maps a gRPC status code to the HTTP status of a JSON response.
+func HTTPStatus(code int) int {
+	switch code {
+	case codeOK:
+		return http.StatusOK
+	case codeCanceled:
+		return 499
+	case codeInvalidArgument, codeFailedPrecondition, codeOutOfRange:
+		return http.StatusBadRequest
+	case codeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codeNotFound:
+		return http.StatusNotFound
+	case codeAlreadyExists, codeAborted:
+		return http.StatusConflict
+	case codePermissionDenied:
+		return http.StatusForbidden
+	case codeResourceExhausted:
+		return http.StatusTooManyRequests
+	case codeUnimplemented:
+		return http.StatusNotImplemented
+	case codeUnavailable:
+		return http.StatusServiceUnavailable
+	case codeUnauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError // UNKNOWN, INTERNAL, DATA_LOSS and anything else
+}
+
+// errorBody is the JSON error returned to REST clients.
+type errorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// WriteError writes a JSON error for a gRPC status code.
+func WriteError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(HTTPStatus(code))
+	_ = json.NewEncoder(w).Encode(errorBody{Code: code, Message: msg})
+}
+
+// statusOf extracts grpc-status and the decoded grpc-message of a response.
+func statusOf(res *http.Response) (code int, msg string, ok bool) {
+	s, m := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if s == "" {
+		s, m = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	if s == "" {
+		return 0, "", false
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return codeUnknown, "invalid grpc-status " + s, true
+	}
+	if dec, err := url.PathUnescape(m); err == nil {
+		m = dec
+	}
+	return code, m, true
+}
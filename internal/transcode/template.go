@@ -0,0 +1,191 @@
+package transcode
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// segment kinds of a compiled path template
+const (
+	segLiteral = iota
+	segStar    // "*": exactly one path segment
+	segDStar   // "**": zero or more path segments
+)
+
+type segment struct {
+	kind int
+	lit  string
+}
+
+// variable binds a field path to a run of template segments.
+type variable struct {
+	field      []string
+	start, end int // segment indexes, end exclusive
+}
+
+// template is a compiled google.api.http path template:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	Verb     = ":" LITERAL ;
+type template struct {
+	segs []segment
+	vars []variable
+	verb string
+}
+
+func parseTemplate(s string) (*template, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("template %q: must start with '/'", s)
+	}
+	t := &template{}
+	rest := s[1:]
+	// the verb follows the last ':' that is outside of a variable
+	depth := 0
+	for i := len(rest) - 1; i >= 0; i-- {
+		switch rest[i] {
+		case '}':
+			depth++
+		case '{':
+			depth--
+		case '/':
+			i = 0 // only the last segment can carry a verb
+		case ':':
+			if depth == 0 {
+				t.verb = rest[i+1:]
+				rest = rest[:i]
+				i = 0
+			}
+		}
+	}
+
+	for rest != "" {
+		var part string
+		if strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("template %q: unterminated variable", s)
+			}
+			part, rest = rest[:end+1], rest[end+1:]
+		} else if i := strings.IndexByte(rest, '/'); i >= 0 {
+			part, rest = rest[:i], rest[i:]
+		} else {
+			part, rest = rest, ""
+		}
+		rest = strings.TrimPrefix(rest, "/")
+
+		if strings.HasPrefix(part, "{") {
+			inner := part[1 : len(part)-1]
+			field, pattern, hasPattern := strings.Cut(inner, "=")
+			if field == "" {
+				return nil, fmt.Errorf("template %q: empty variable name", s)
+			}
+			if !hasPattern {
+				pattern = "*"
+			}
+			v := variable{field: strings.Split(field, "."), start: len(t.segs)}
+			for _, p := range strings.Split(pattern, "/") {
+				if strings.ContainsAny(p, "{}") {
+					return nil, fmt.Errorf("template %q: nested variable", s)
+				}
+				t.segs = append(t.segs, literalOrWildcard(p))
+			}
+			v.end = len(t.segs)
+			t.vars = append(t.vars, v)
+			continue
+		}
+		if part == "" {
+			return nil, fmt.Errorf("template %q: empty segment", s)
+		}
+		t.segs = append(t.segs, literalOrWildcard(part))
+	}
+
+	dstars := 0
+	for _, sg := range t.segs {
+		if sg.kind == segDStar {
+			dstars++
+		}
+	}
+	if dstars > 1 {
+		return nil, fmt.Errorf("template %q: at most one '**' is allowed", s)
+	}
+	return t, nil
+}
+
+func literalOrWildcard(p string) segment {
+	switch p {
+	case "*":
+		return segment{kind: segStar}
+	case "**":
+		return segment{kind: segDStar}
+	}
+	return segment{kind: segLiteral, lit: p}
+}
+
+// match matches an escaped request path and returns the variable values.
+func (t *template) match(escapedPath string) (map[string]string, bool) {
+	if !strings.HasPrefix(escapedPath, "/") {
+		return nil, false
+	}
+	p := escapedPath[1:]
+	if t.verb != "" {
+		var ok bool
+		if p, ok = strings.CutSuffix(p, ":"+t.verb); !ok {
+			return nil, false
+		}
+	}
+	var parts []string
+	if p != "" {
+		parts = strings.Split(p, "/")
+	}
+
+	// every segment but "**" consumes exactly one part
+	fixed := len(t.segs)
+	for _, sg := range t.segs {
+		if sg.kind == segDStar {
+			fixed--
+		}
+	}
+	if len(parts) < fixed || (fixed == len(t.segs) && len(parts) != fixed) {
+		return nil, false
+	}
+	// bounds[i] is the first part consumed by segment i
+	bounds := make([]int, len(t.segs)+1)
+	pos := 0
+	for i, sg := range t.segs {
+		bounds[i] = pos
+		switch sg.kind {
+		case segLiteral:
+			if parts[pos] != sg.lit {
+				return nil, false
+			}
+			pos++
+		case segStar:
+			if parts[pos] == "" {
+				return nil, false
+			}
+			pos++
+		case segDStar:
+			pos += len(parts) - fixed
+		}
+	}
+	bounds[len(t.segs)] = pos
+
+	vars := make(map[string]string, len(t.vars))
+	for _, v := range t.vars {
+		run := parts[bounds[v.start]:bounds[v.end]]
+		vals := make([]string, len(run))
+		for i, r := range run {
+			u, err := url.PathUnescape(r)
+			if err != nil {
+				return nil, false
+			}
+			vals[i] = u
+		}
+		vars[strings.Join(v.field, ".")] = strings.Join(vals, "/")
+	}
+	return vars, true
+}
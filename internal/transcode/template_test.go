@@ -0,0 +1,48 @@
+package transcode
+
+import "testing"
+
+func TestTemplate_Match(t *testing.T) {
+	cases := []struct {
+		tmpl string
+		path string
+		ok   bool
+		vars map[string]string
+	}{
+		{"/v1/books", "/v1/books", true, map[string]string{}},
+		{"/v1/books", "/v1/books/1", false, nil},
+		{"/v1/books/{id}", "/v1/books/42", true, map[string]string{"id": "42"}},
+		{"/v1/books/{id}", "/v1/books/", false, nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/s1/books/b%201", true, map[string]string{"name": "shelves/s1/books/b 1"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/s1/other/b1", false, nil},
+		{"/v1/{book.name=books/*}:publish", "/v1/books/b1:publish", true, map[string]string{"book.name": "books/b1"}},
+		{"/v1/{book.name=books/*}:publish", "/v1/books/b1", false, nil},
+		{"/files/{path=**}", "/files/a/b/c.txt", true, map[string]string{"path": "a/b/c.txt"}},
+		{"/files/{path=**}/meta", "/files/a/b/meta", true, map[string]string{"path": "a/b"}},
+		{"/v1/*/books", "/v1/anything/books", true, map[string]string{}},
+	}
+	for _, tc := range cases {
+		tmpl, err := parseTemplate(tc.tmpl)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.tmpl, err)
+		}
+		vars, ok := tmpl.match(tc.path)
+		if ok != tc.ok {
+			t.Errorf("%s ~ %s: got match=%v, want %v", tc.tmpl, tc.path, ok, tc.ok)
+			continue
+		}
+		for k, want := range tc.vars {
+			if vars[k] != want {
+				t.Errorf("%s ~ %s: var %s got %q, want %q", tc.tmpl, tc.path, k, vars[k], want)
+			}
+		}
+	}
+}
+
+func TestTemplate_ParseErrors(t *testing.T) {
+	for _, s := range []string{"v1/books", "/v1/{id", "/v1//books", "/{a=**}/{b=**}", "/v1/{=x}"} {
+		if _, err := parseTemplate(s); err == nil {
+			t.Errorf("parseTemplate(%q): want error", s)
+		}
+	}
+}
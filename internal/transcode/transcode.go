@@ -0,0 +1,412 @@
+// Package transcode maps JSON/REST requests to gRPC methods described by a
+// compiled FileDescriptorSet and its google.api.http annotations.
+package transcode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxMessageSize bounds a single gRPC message read from the upstream.
+const maxMessageSize = 16 << 20
+
+// Transcoder holds the HTTP bindings of a set of gRPC services.
+type Transcoder struct {
+	bindings []*binding
+}
+
+type binding struct {
+	httpMethod   string
+	tmpl         *template
+	body         string // "", "*" or a field path
+	responseBody string
+	method       protoreflect.MethodDescriptor
+	grpcPath     string // "/pkg.Service/Method"
+}
+
+// Load reads a binary FileDescriptorSet (protoc --include_imports
+// --descriptor_set_out) and binds the methods of services (all if empty).
+func Load(path string, services []string) (*Transcoder, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fds := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(b, fds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return New(fds, services)
+}
+
+// New binds the methods of services (all services if empty) found in fds.
+// Methods without a google.api.http annotation are reachable as
+// POST /<package>.<Service>/<Method> with the whole message as body.
+func New(fds *descriptorpb.FileDescriptorSet, services []string) (*Transcoder, error) {
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(services))
+	for _, s := range services {
+		want[s] = true
+	}
+
+	t := &Transcoder{}
+	var rangeErr error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		svcs := fd.Services()
+		for i := 0; i < svcs.Len(); i++ {
+			sd := svcs.Get(i)
+			if len(want) > 0 && !want[string(sd.FullName())] {
+				continue
+			}
+			delete(want, string(sd.FullName()))
+			if err := t.bindService(sd); err != nil {
+				rangeErr = err
+				return false
+			}
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+	for s := range want {
+		return nil, fmt.Errorf("service %q not found in descriptor set", s)
+	}
+	if len(t.bindings) == 0 {
+		return nil, errors.New("descriptor set has no services")
+	}
+	return t, nil
+}
+
+func (t *Transcoder) bindService(sd protoreflect.ServiceDescriptor) error {
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if md.IsStreamingClient() {
+			continue // a single JSON body cannot feed a client stream
+		}
+		grpcPath := "/" + string(sd.FullName()) + "/" + string(md.Name())
+		opts, _ := md.Options().(*descriptorpb.MethodOptions)
+		rules, err := httpRules(opts)
+		if err != nil {
+			return fmt.Errorf("%s: google.api.http: %w", md.FullName(), err)
+		}
+		if len(rules) == 0 {
+			rules = []httpRule{{method: "POST", path: grpcPath, body: "*"}}
+		}
+		for _, r := range rules {
+			tmpl, err := parseTemplate(r.path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", md.FullName(), err)
+			}
+			if r.body != "" && r.body != "*" {
+				if err := checkFieldPath(md.Input(), r.body); err != nil {
+					return fmt.Errorf("%s: body: %w", md.FullName(), err)
+				}
+			}
+			for _, v := range tmpl.vars {
+				if err := checkFieldPath(md.Input(), strings.Join(v.field, ".")); err != nil {
+					return fmt.Errorf("%s: %s: %w", md.FullName(), r.path, err)
+				}
+			}
+			t.bindings = append(t.bindings, &binding{
+				httpMethod:   r.method,
+				tmpl:         tmpl,
+				body:         r.body,
+				responseBody: r.responseBody,
+				method:       md,
+				grpcPath:     grpcPath,
+			})
+		}
+	}
+	return nil
+}
+
+func checkFieldPath(md protoreflect.MessageDescriptor, path string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := lookupField(md, name)
+		if fd == nil {
+			return fmt.Errorf("unknown field %q in %s", name, md.FullName())
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil {
+				return fmt.Errorf("field %q is not a message", name)
+			}
+			md = fd.Message()
+		}
+	}
+	return nil
+}
+
+// Call is one transcoded request.
+type Call struct {
+	// Path is the gRPC method path, e.g. "/pkg.Service/Method".
+	Path string
+	// Body is the length-prefixed gRPC request message.
+	Body []byte
+
+	b *binding
+}
+
+// ErrNoBinding is returned by Request when no method matches.
+var ErrNoBinding = errors.New("no gRPC method bound to this request")
+
+// Request matches r against the bindings and builds the gRPC request message
+// from the JSON body, path variables and query parameters. Errors other than
+// ErrNoBinding are client errors (INVALID_ARGUMENT).
+func (t *Transcoder) Request(r *http.Request) (*Call, error) {
+	var (
+		b    *binding
+		vars map[string]string
+	)
+	for _, cand := range t.bindings {
+		if cand.httpMethod != r.Method {
+			continue
+		}
+		if v, ok := cand.tmpl.match(r.URL.EscapedPath()); ok {
+			b, vars = cand, v
+			break
+		}
+	}
+	if b == nil {
+		return nil, ErrNoBinding
+	}
+
+	msg := dynamicpb.NewMessage(b.method.Input())
+	if b.body != "" && r.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		if len(data) > maxMessageSize {
+			return nil, errors.New("request body too large")
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := unmarshalBody(msg, b.body, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	bound := map[string]bool{}
+	for path, v := range vars {
+		if err := setField(msg, strings.Split(path, "."), v); err != nil {
+			return nil, err
+		}
+		bound[path] = true
+	}
+	if b.body != "*" {
+		if b.body != "" {
+			bound[b.body] = true
+		}
+		for key, vals := range r.URL.Query() {
+			if bound[key] || coveredBy(key, bound) {
+				continue
+			}
+			for _, v := range vals {
+				if err := setField(msg, strings.Split(key, "."), v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return &Call{Path: b.grpcPath, Body: append(frame, payload...), b: b}, nil
+}
+
+// coveredBy reports whether key is nested inside a bound field.
+func coveredBy(key string, bound map[string]bool) bool {
+	for p := range bound {
+		if strings.HasPrefix(key, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func unmarshalBody(msg *dynamicpb.Message, body string, data []byte) error {
+	if body == "*" {
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return nil
+	}
+	// wrap the body as {"a": {"b": <body>}} and merge it in
+	names := strings.Split(body, ".")
+	var wrapped bytes.Buffer
+	md := msg.Descriptor()
+	for _, n := range names {
+		fd := lookupField(md, n)
+		wrapped.WriteString(`{` + strconv.Quote(fd.JSONName()) + `:`)
+		md = fd.Message()
+	}
+	wrapped.Write(data)
+	wrapped.WriteString(strings.Repeat("}", len(names)))
+	tmp := dynamicpb.NewMessage(msg.Descriptor())
+	if err := protojson.Unmarshal(wrapped.Bytes(), tmp); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	proto.Merge(msg, tmp)
+	return nil
+}
+
+// UpstreamHeaders rewrites request headers for the gRPC upstream.
+func (c *Call) UpstreamHeaders(h http.Header) {
+	h.Set("Content-Type", "application/grpc")
+	h.Set("TE", "trailers")
+	h.Del("Content-Length")
+	h.Del("Accept-Encoding")
+	h.Del("Grpc-Encoding")
+}
+
+// WriteResponse converts the upstream gRPC response to JSON. Unary methods
+// produce one object; server-streaming methods produce one object per line,
+// flushed as messages arrive. It returns the grpc-status seen ("" if none).
+func (c *Call) WriteResponse(w http.ResponseWriter, res *http.Response) string {
+	if res.StatusCode != http.StatusOK {
+		WriteError(w, codeUnavailable, fmt.Sprintf("upstream returned HTTP %d", res.StatusCode))
+		return ""
+	}
+	streaming := c.b.method.IsStreamingServer()
+	out := c.b.method.Output()
+
+	var msgs [][]byte
+	wroteHeader := false
+	writeHeader := func() {
+		if !wroteHeader {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			wroteHeader = true
+		}
+	}
+	for {
+		payload, err := readFrame(res.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !wroteHeader {
+				WriteError(w, codeInternal, err.Error())
+			}
+			return ""
+		}
+		js, err := c.marshal(out, payload)
+		if err != nil {
+			if !wroteHeader {
+				WriteError(w, codeInternal, err.Error())
+			}
+			return ""
+		}
+		if !streaming {
+			msgs = append(msgs, js)
+			continue
+		}
+		writeHeader()
+		_, _ = w.Write(append(js, '\n'))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	code, msg, ok := statusOf(res)
+	if !ok {
+		code, msg = codeInternal, "missing grpc-status"
+	}
+	status := strconv.Itoa(code)
+	if code != codeOK {
+		if wroteHeader {
+			// the stream already started: report the error as a last line
+			js, _ := json.Marshal(map[string]errorBody{"error": {Code: code, Message: msg}})
+			_, _ = w.Write(append(js, '\n'))
+			return status
+		}
+		WriteError(w, code, msg)
+		return status
+	}
+	if !streaming {
+		if len(msgs) != 1 {
+			WriteError(w, codeInternal, fmt.Sprintf("unary call returned %d messages", len(msgs)))
+			return status
+		}
+		writeHeader()
+		_, _ = w.Write(msgs[0])
+	} else {
+		writeHeader()
+	}
+	return status
+}
+
+func (c *Call) marshal(md protoreflect.MessageDescriptor, payload []byte) ([]byte, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	js, err := protojson.Marshal(m)
+	if err != nil || c.b.responseBody == "" {
+		return js, err
+	}
+	// response_body selects one (possibly nested) field of the response
+	var v any
+	if err := json.Unmarshal(js, &v); err != nil {
+		return nil, err
+	}
+	for _, n := range strings.Split(c.b.responseBody, ".") {
+		obj, _ := v.(map[string]any)
+		fd := lookupField(md, n)
+		if fd == nil {
+			return nil, fmt.Errorf("response_body: unknown field %q", n)
+		}
+		v = obj[fd.JSONName()]
+		if fd.Message() != nil {
+			md = fd.Message()
+		}
+	}
+	return json.Marshal(v)
+}
+
+// readFrame reads one length-prefixed gRPC message.
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated gRPC frame")
+		}
+		return nil, err
+	}
+	if hdr[0]&1 != 0 {
+		return nil, errors.New("compressed gRPC messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("gRPC message of %d bytes exceeds limit", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.New("truncated gRPC frame")
+	}
+	return payload, nil
+}
@@ -0,0 +1,329 @@
+package transcode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpOption encodes a google.api.http annotation: verb field number, path,
+// body and optional nested additional bindings.
+func httpOption(verb protowire.Number, path, body string, additional ...[]byte) []byte {
+	var rule []byte
+	rule = protowire.AppendTag(rule, verb, protowire.BytesType)
+	rule = protowire.AppendString(rule, path)
+	if body != "" {
+		rule = protowire.AppendTag(rule, 7, protowire.BytesType)
+		rule = protowire.AppendString(rule, body)
+	}
+	for _, a := range additional {
+		rule = protowire.AppendTag(rule, 11, protowire.BytesType)
+		rule = protowire.AppendBytes(rule, a)
+	}
+	return rule
+}
+
+func methodOptions(rule []byte) *descriptorpb.MethodOptions {
+	opts := &descriptorpb.MethodOptions{}
+	if rule != nil {
+		raw := protowire.AppendTag(nil, httpRuleField, protowire.BytesType)
+		raw = protowire.AppendBytes(raw, rule)
+		opts.ProtoReflect().SetUnknown(raw)
+	}
+	return opts
+}
+
+func field(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(num),
+		Type:     typ.Enum(),
+		Label:    label.Enum(),
+		JsonName: proto.String(jsonName(name)),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func jsonName(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// libraryDescriptors describes:
+//
+//	service Library {
+//	  rpc GetBook(GetBookRequest) returns (Book) { get: "/v1/{name=shelves/*/books/*}" }
+//	  rpc CreateBook(CreateBookRequest) returns (Book) {
+//	    post: "/v1/{parent=shelves/*}/books" body: "book"
+//	    additional_bindings { put: "/v1/books:create" body: "*" } }
+//	  rpc ListBooks(ListBooksRequest) returns (stream Book) { get: "/v1/{parent=shelves/*}/books" }
+//	  rpc Ping(GetBookRequest) returns (Book);
+//	}
+func libraryDescriptors() *descriptorpb.FileDescriptorSet {
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	msg := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	i32 := descriptorpb.FieldDescriptorProto_TYPE_INT32
+	boolean := descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	method := func(name, in, out string, stream bool, rule []byte) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".library." + in),
+			OutputType:      proto.String(".library." + out),
+			ServerStreaming: proto.Bool(stream),
+			Options:         methodOptions(rule),
+		}
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("library.proto"),
+		Package: proto.String("library"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Book"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, str, "", false),
+				field("title", 2, str, "", false),
+				field("page_count", 3, i32, "", false),
+				field("tags", 4, str, "", true),
+			}},
+			{Name: proto.String("GetBookRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, str, "", false),
+				field("full", 2, boolean, "", false),
+			}},
+			{Name: proto.String("CreateBookRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("parent", 1, str, "", false),
+				field("book", 2, msg, ".library.Book", false),
+			}},
+			{Name: proto.String("ListBooksRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("parent", 1, str, "", false),
+				field("page_size", 2, i32, "", false),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Library"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetBook", "GetBookRequest", "Book", false, httpOption(2, "/v1/{name=shelves/*/books/*}", "")),
+				method("CreateBook", "CreateBookRequest", "Book", false,
+					httpOption(4, "/v1/{parent=shelves/*}/books", "book", httpOption(3, "/v1/books:create", "*"))),
+				method("ListBooks", "ListBooksRequest", "Book", true, httpOption(2, "/v1/{parent=shelves/*}/books", "")),
+				method("Ping", "GetBookRequest", "Book", false, nil),
+			},
+		}},
+	}}}
+}
+
+func newLibrary(t *testing.T) *Transcoder {
+	t.Helper()
+	tc, err := New(libraryDescriptors(), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return tc
+}
+
+// decodeCall decodes the gRPC request message of c into JSON-ish fields.
+func decodeCall(t *testing.T, tc *Transcoder, c *Call) protoreflect.Message {
+	t.Helper()
+	if c.Body[0] != 0 || int(binary.BigEndian.Uint32(c.Body[1:5])) != len(c.Body)-5 {
+		t.Fatalf("bad frame header % x", c.Body[:5])
+	}
+	m := dynamicpb.NewMessage(c.b.method.Input())
+	if err := proto.Unmarshal(c.Body[5:], m); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return m
+}
+
+func get(m protoreflect.Message, path string) protoreflect.Value {
+	names := strings.Split(path, ".")
+	for _, n := range names[:len(names)-1] {
+		m = m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(n))).Message()
+	}
+	return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(names[len(names)-1])))
+}
+
+func TestTranscoder_Request(t *testing.T) {
+	tc := newLibrary(t)
+
+	t.Run("path and query", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/v1/shelves/s1/books/b1?full=true", nil)
+		c, err := tc.Request(r)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if c.Path != "/library.Library/GetBook" {
+			t.Errorf("path: got %q", c.Path)
+		}
+		m := decodeCall(t, tc, c)
+		if got := get(m, "name").String(); got != "shelves/s1/books/b1" {
+			t.Errorf("name: got %q", got)
+		}
+		if !get(m, "full").Bool() {
+			t.Error("full: got false, want true")
+		}
+	})
+
+	t.Run("body field", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/v1/shelves/s1/books", strings.NewReader(`{"title":"Go","pageCount":300,"tags":["a","b"]}`))
+		c, err := tc.Request(r)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		m := decodeCall(t, tc, c)
+		if got := get(m, "parent").String(); got != "shelves/s1" {
+			t.Errorf("parent: got %q", got)
+		}
+		if got := get(m, "book.title").String(); got != "Go" {
+			t.Errorf("book.title: got %q", got)
+		}
+		if got := get(m, "book.page_count").Int(); got != 300 {
+			t.Errorf("book.page_count: got %d", got)
+		}
+		if got := get(m, "book.tags").List().Len(); got != 2 {
+			t.Errorf("book.tags: got %d entries", got)
+		}
+	})
+
+	t.Run("additional binding with verb", func(t *testing.T) {
+		r := httptest.NewRequest("PUT", "/v1/books:create", strings.NewReader(`{"parent":"shelves/s2","book":{"name":"x"}}`))
+		c, err := tc.Request(r)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if c.Path != "/library.Library/CreateBook" {
+			t.Errorf("path: got %q", c.Path)
+		}
+		if got := get(decodeCall(t, tc, c), "book.name").String(); got != "x" {
+			t.Errorf("book.name: got %q", got)
+		}
+	})
+
+	t.Run("default binding", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/library.Library/Ping", strings.NewReader(`{"name":"n"}`))
+		c, err := tc.Request(r)
+		if err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if got := get(decodeCall(t, tc, c), "name").String(); got != "n" {
+			t.Errorf("name: got %q", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := tc.Request(httptest.NewRequest("DELETE", "/v1/shelves/s1/books/b1", nil)); err != ErrNoBinding {
+			t.Errorf("unbound method: got %v, want ErrNoBinding", err)
+		}
+		r := httptest.NewRequest("POST", "/v1/shelves/s1/books", strings.NewReader(`{"title":`))
+		if _, err := tc.Request(r); err == nil || err == ErrNoBinding {
+			t.Errorf("bad JSON: got %v, want a decode error", err)
+		}
+		r = httptest.NewRequest("GET", "/v1/shelves/s1/books/b1?full=maybe", nil)
+		if _, err := tc.Request(r); err == nil {
+			t.Error("bad query value: want error")
+		}
+	})
+}
+
+func grpcResponse(status, msg string, frames ...[]byte) *http.Response {
+	var body bytes.Buffer
+	for _, f := range frames {
+		var hdr [5]byte
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(f)))
+		body.Write(hdr[:])
+		body.Write(f)
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/grpc"}},
+		Body:       io.NopCloser(&body),
+		Trailer:    http.Header{"Grpc-Status": {status}, "Grpc-Message": {msg}},
+	}
+}
+
+func book(t *testing.T, tc *Transcoder, title string) []byte {
+	t.Helper()
+	md := tc.bindings[0].method.Output()
+	m := dynamicpb.NewMessage(md)
+	m.Set(md.Fields().ByName("title"), protoreflect.ValueOfString(title))
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCall_WriteResponse(t *testing.T) {
+	tc := newLibrary(t)
+	unary, _ := tc.Request(httptest.NewRequest("GET", "/v1/shelves/s1/books/b1", nil))
+	stream, _ := tc.Request(httptest.NewRequest("GET", "/v1/shelves/s1/books", nil))
+
+	rr := httptest.NewRecorder()
+	if got := unary.WriteResponse(rr, grpcResponse("0", "", book(t, tc, "Go"))); got != "0" {
+		t.Errorf("grpc status: got %q", got)
+	}
+	if rr.Code != 200 || strings.TrimSpace(rr.Body.String()) != `{"title":"Go"}` {
+		t.Errorf("unary: got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	stream.WriteResponse(rr, grpcResponse("0", "", book(t, tc, "a"), book(t, tc, "b")))
+	if got := rr.Body.String(); got != "{\"title\":\"a\"}\n{\"title\":\"b\"}\n" {
+		t.Errorf("stream: got %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	unary.WriteResponse(rr, grpcResponse("5", "book%20not%20found"))
+	var e errorBody
+	if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+		t.Fatalf("error body: %v", err)
+	}
+	if rr.Code != http.StatusNotFound || e.Code != 5 || e.Message != "book not found" {
+		t.Errorf("error: got %d %+v", rr.Code, e)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	b, err := proto.Marshal(libraryDescriptors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "library.pb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, []string{"library.Library"}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := Load(path, []string{"library.Missing"}); err == nil {
+		t.Fatal("want error for unknown service")
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for code, want := range map[int]int{0: 200, 3: 400, 4: 504, 5: 404, 7: 403, 8: 429, 12: 501, 14: 503, 16: 401, 13: 500} {
+		if got := HTTPStatus(code); got != want {
+			t.Errorf("HTTPStatus(%d): got %d, want %d", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+)
+
+// ProtoH2C speaks prior-knowledge HTTP/2 over plain TCP (e.g. gRPC without TLS).
+const ProtoH2C = "h2c"
+
+// newH2C builds an HTTP/2-only transport for http:// upstreams. Streams are
+// multiplexed over pooled connections; idle connections are probed with PING
+// frames and closed when the peer stops answering.
+func (r *Registry) newH2C() http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   r.opts.DialTimeout,
+		KeepAlive: r.opts.DialKeepAlive,
+	}
+	protos := new(http.Protocols)
+	protos.SetUnencryptedHTTP2(true)
+	tr := &http.Transport{
+		// no Proxy: h2c with prior knowledge cannot go through an HTTP proxy
+		DialContext:           dialer.DialContext,
+		Protocols:             protos,
+		MaxIdleConns:          r.opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   r.opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       r.opts.IdleConnTimeout,
+		MaxConnsPerHost:       r.opts.MaxConnsPerHost,
+		ExpectContinueTimeout: r.opts.ExpectContinueTimeout,
+		HTTP2: &http.HTTP2Config{
+			SendPingTimeout: r.opts.H2PingInterval,
+			PingTimeout:     r.opts.H2PingTimeout,
+		},
+	}
+	if r.opts.ResponseHeaderTimeout > 0 {
+		tr.ResponseHeaderTimeout = r.opts.ResponseHeaderTimeout
+	}
+	return tr
+}
@@ -13,7 +13,6 @@ import (
 const (
 	ProtoHTTP1 = "http1" // strictly HTTP/1.1 to upstream
 	ProtoAuto  = "auto"  // ALPN, allow h2 over TLS when available
-	// ProtoH2C = "h2c"   // recommend registering lazily in another file if needed
 )
 
 // Options tunes the default transports.
@@ -33,6 +32,10 @@ type Options struct {
 	ExpectContinueTimeout time.Duration
 	ResponseHeaderTimeout time.Duration // optional, 0 to disable
 
+	// HTTP/2 health (h2c): PING after this much read silence, close if unanswered
+	H2PingInterval time.Duration // 0 disables pings
+	H2PingTimeout  time.Duration
+
 	// TLS knobs for defaults (cluster-specific/mTLS should register their own RT)
 	InsecureSkipVerify bool
 	RootCAs            *x509.CertPool
@@ -50,6 +53,8 @@ func DefaultOptions() Options {
 		TLSHandshakeTimeout:   5 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		ResponseHeaderTimeout: 0,
+		H2PingInterval:        30 * time.Second,
+		H2PingTimeout:         15 * time.Second,
 		InsecureSkipVerify:    false,
 		RootCAs:               nil,
 	}
@@ -62,10 +67,10 @@ type Registry struct {
 	opts  Options
 }
 
-// NewDefaultRegistry builds a registry with DefaultOptions and pre-registers http1/auto.
+// NewDefaultRegistry builds a registry with DefaultOptions and pre-registers http1/auto/h2c.
 func NewDefaultRegistry() *Registry { return NewRegistry(DefaultOptions()) }
 
-// NewRegistry builds a registry with given options and pre-registers http1/auto.
+// NewRegistry builds a registry with given options and pre-registers http1/auto/h2c.
 func NewRegistry(opts Options) *Registry {
 	r := &Registry{
 		store: make(map[string]http.RoundTripper),
@@ -73,8 +78,7 @@ func NewRegistry(opts Options) *Registry {
 	}
 	r.store[ProtoHTTP1] = r.newHTTP1()
 	r.store[ProtoAuto] = r.newAuto()
-	// h2c/h3: register in your bootstrapping code when needed, e.g.:
-	//   r.Register(ProtoH2C, newH2C(opts))
+	r.store[ProtoH2C] = r.newH2C()
 	return r
 }
 
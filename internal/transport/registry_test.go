@@ -3,7 +3,9 @@ package transport
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -199,6 +201,50 @@ func TestRegistry_AutoTransport(t *testing.T) {
 	}
 }
 
+func TestRegistry_H2CTransport(t *testing.T) {
+	reg := NewDefaultRegistry()
+	tr, ok := reg.Get(ProtoH2C).(*http.Transport)
+	if !ok {
+		t.Fatal("expected *http.Transport")
+	}
+	if tr == reg.Get(ProtoHTTP1) {
+		t.Fatal("h2c fell back to http1")
+	}
+	if tr.Protocols == nil || !tr.Protocols.UnencryptedHTTP2() || tr.Protocols.HTTP1() {
+		t.Errorf("protocols: got %v, want UnencryptedHTTP2 only", tr.Protocols)
+	}
+	if tr.HTTP2 == nil || tr.HTTP2.SendPingTimeout != 30*time.Second || tr.HTTP2.PingTimeout != 15*time.Second {
+		t.Errorf("ping config: got %+v", tr.HTTP2)
+	}
+
+	protos := new(http.Protocols)
+	protos.SetUnencryptedHTTP2(true)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.WriteString(w, r.Proto)
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	srv.Config.Protocols = protos
+	srv.Start()
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip: %v", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+			t.Fatalf("proto: got %s / server saw %q, want HTTP/2.0", res.Proto, body)
+		}
+		if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+			t.Errorf("trailer Grpc-Status: got %q, want 0", got)
+		}
+	}
+}
+
 func TestRegistry_WithRootCAs(t *testing.T) {
 	pool := x509.NewCertPool()
 	opts := Options{
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestH2C_Listener(t *testing.T) {
+	// Upstream: plaintext gRPC-like service over h2c, streaming a frame and sending trailers
+	protos := new(http.Protocols)
+	protos.SetHTTP1(true)
+	protos.SetUnencryptedHTTP2(true)
+	upstreamSrv := &http.Server{
+		Addr:      ":19006",
+		Protocols: protos,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("X-Upstream-Proto", r.Proto)
+			w.WriteHeader(200)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+			w.Header().Set("Grpc-Status", "0")
+		}),
+	}
+	go func() { _ = upstreamSrv.ListenAndServe() }()
+	defer func() { _ = upstreamSrv.Close() }()
+	waitForPort(t, "127.0.0.1:19006")
+
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configContent := `
+entrypoint:
+  - name: web
+    address: ":18447"
+    h2c: true
+services:
+  - name: grpc-svc
+    proto: h2c
+    endpoints: ["http://127.0.0.1:19006"]
+routes:
+  - match: { path_prefix: "/" }
+    service: grpc-svc
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	binPath := filepath.Join(tmpDir, "gateway")
+	buildCmd := exec.Command("go", "build", "-o", binPath, "../cmd/gateway")
+	if err := buildCmd.Run(); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	gwCmd := exec.Command(binPath, "-config", configFile)
+	gwCmd.Stdout = os.Stdout
+	gwCmd.Stderr = os.Stderr
+	if err := gwCmd.Start(); err != nil {
+		t.Fatalf("start gateway: %v", err)
+	}
+	defer func() { _ = gwCmd.Process.Kill() }()
+	waitForPort(t, "127.0.0.1:18447")
+
+	t.Run("prior knowledge", func(t *testing.T) {
+		h2 := new(http.Protocols)
+		h2.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: h2}, Timeout: 5 * time.Second}
+
+		req, _ := http.NewRequest("POST", "http://127.0.0.1:18447/grpc.health.v1.Health/Check", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer func() { _ = res.Body.Close() }()
+		_, _ = io.ReadAll(res.Body)
+
+		if res.ProtoMajor != 2 {
+			t.Errorf("downstream proto: got %s, want HTTP/2.0", res.Proto)
+		}
+		if got := res.Header.Get("X-Upstream-Proto"); got != "HTTP/2.0" {
+			t.Errorf("upstream proto: got %q, want HTTP/2.0", got)
+		}
+		if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+			t.Errorf("Grpc-Status trailer: got %q, want 0", got)
+		}
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		conn, err := (&net.Dialer{}).DialContext(context.Background(), "tcp", "127.0.0.1:18447")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		// HTTP2-Settings: empty SETTINGS payload, base64url
+		_, _ = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+			"Upgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		if res.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(res.Header.Get("Upgrade"), "h2c") {
+			t.Errorf("upgrade: got %d Upgrade=%q, want 101 h2c", res.StatusCode, res.Header.Get("Upgrade"))
+		}
+	})
+
+	t.Run("http1", func(t *testing.T) {
+		client := &http.Client{Timeout: 5 * time.Second}
+		res, err := client.Get("http://127.0.0.1:18447/")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer func() { _ = res.Body.Close() }()
+		_, _ = io.ReadAll(res.Body)
+		if res.ProtoMajor != 1 || res.StatusCode != 200 {
+			t.Errorf("http1: got %s %d, want HTTP/1.1 200", res.Proto, res.StatusCode)
+		}
+	})
+}
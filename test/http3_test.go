@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTP3_Listener(t *testing.T) {
+	upstreamMux := http.NewServeMux()
+	upstreamMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	upstreamSrv := &http.Server{Addr: ":19007", Handler: upstreamMux}
+	go func() { _ = upstreamSrv.ListenAndServe() }()
+	defer func() { _ = upstreamSrv.Close() }()
+	waitForPort(t, "127.0.0.1:19007")
+
+	tmpDir := t.TempDir()
+	certFile := filepath.Join(tmpDir, "server.crt")
+	keyFile := filepath.Join(tmpDir, "server.key")
+	cmd := exec.Command("openssl", "req", "-x509", "-newkey", "rsa:2048",
+		"-keyout", keyFile, "-out", certFile, "-days", "1", "-nodes",
+		"-subj", "/CN=example.com")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("openssl: %v", err)
+	}
+
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configContent := fmt.Sprintf(`
+entrypoint:
+  - name: https
+    address: ":18448"
+    http3: true
+tls:
+  enabled: true
+  certificates:
+    - cert_file: %q
+      key_file: %q
+access_log:
+  fields: ["protocol", "status"]
+services:
+  - name: web
+    endpoints: ["http://127.0.0.1:19007"]
+routes:
+  - match: { path_prefix: "/" }
+    service: web
+`, certFile, keyFile)
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	binPath := filepath.Join(tmpDir, "gateway")
+	buildCmd := exec.Command("go", "build", "-o", binPath, "../cmd/gateway")
+	if err := buildCmd.Run(); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	gwCmd := exec.Command(binPath, "-config", configFile)
+	stdoutPipe, err := gwCmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout pipe: %v", err)
+	}
+	gwCmd.Stderr = os.Stderr
+	if err := gwCmd.Start(); err != nil {
+		t.Fatalf("start gateway: %v", err)
+	}
+	defer func() { _ = gwCmd.Process.Kill() }()
+	waitForPort(t, "127.0.0.1:18448")
+
+	tlsConf := &tls.Config{InsecureSkipVerify: true, ServerName: "example.com"}
+
+	// TCP (h2) response advertises HTTP/3
+	h2Client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConf, ForceAttemptHTTP2: true},
+		Timeout:   5 * time.Second,
+	}
+	res, err := h2Client.Get("https://127.0.0.1:18448/")
+	if err != nil {
+		t.Fatalf("h2 request: %v", err)
+	}
+	_, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if got := res.Header.Get("Alt-Svc"); !strings.Contains(got, `h3=":18448"`) {
+		t.Errorf("Alt-Svc: got %q, want h3=\":18448\"", got)
+	}
+
+	// QUIC request goes through the same gateway
+	h3Tr := &http3.Transport{TLSClientConfig: tlsConf}
+	defer func() { _ = h3Tr.Close() }()
+	h3Client := &http.Client{Transport: h3Tr, Timeout: 5 * time.Second}
+	res, err = h3Client.Get("https://127.0.0.1:18448/")
+	if err != nil {
+		t.Fatalf("h3 request: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.ProtoMajor != 3 || res.StatusCode != 200 || string(body) != "hello" {
+		t.Errorf("h3: got %s %d %q, want HTTP/3.0 200 hello", res.Proto, res.StatusCode, body)
+	}
+
+	// Access log records the protocol
+	found := make(chan bool)
+	go func() {
+		scanner := bufio.NewScanner(stdoutPipe)
+		for scanner.Scan() {
+			var entry map[string]any
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry["protocol"] == "HTTP/3.0" {
+				found <- true
+				return
+			}
+		}
+		found <- false
+	}()
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Error("no access log entry with protocol HTTP/3.0")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for HTTP/3.0 access log")
+	}
+}